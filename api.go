@@ -122,6 +122,14 @@ func (server *ApiServer) Routes() *mux.Router {
 		Name("ProxyUpdate")
 	r.HandleFunc("/proxies/{proxy}", server.ProxyDelete).Methods("DELETE").
 		Name("ProxyDelete")
+	r.HandleFunc("/proxies/{proxy}/capture", server.CaptureStart).Methods("POST").
+		Name("CaptureStart")
+	r.HandleFunc("/proxies/{proxy}/capture", server.CaptureStop).Methods("DELETE").
+		Name("CaptureStop")
+	r.HandleFunc("/proxies/{proxy}/record", server.RecordStart).Methods("POST").
+		Name("RecordStart")
+	r.HandleFunc("/proxies/{proxy}/record", server.RecordStop).Methods("DELETE").
+		Name("RecordStop")
 	r.HandleFunc("/proxies/{proxy}/toxics", server.ToxicIndex).Methods("GET").
 		Name("ToxicIndex")
 	r.HandleFunc("/proxies/{proxy}/toxics", server.ToxicCreate).Methods("POST").
@@ -218,6 +226,16 @@ func (server *ApiServer) ProxyCreate(response http.ResponseWriter, request *http
 	}
 
 	proxy := NewProxy(server, input.Name, input.Listen, input.Upstream)
+	proxy.Upstreams = input.Upstreams
+	proxy.Policy = input.Policy
+	proxy.IdleTimeout = input.IdleTimeout
+	proxy.MaxSessions = input.MaxSessions
+	proxy.Seed = input.Seed
+	proxy.Transparent = input.Transparent
+	proxy.Demultiplexer = input.Demultiplexer
+	proxy.MulticastInterface = input.MulticastInterface
+	proxy.Capture = input.Capture
+	proxy.Record = input.Record
 
 	err = server.Collection.Add(proxy, input.Enabled)
 	if server.apiError(response, err) {
@@ -306,7 +324,21 @@ func (server *ApiServer) ProxyUpdate(response http.ResponseWriter, request *http
 	}
 
 	// Default fields are the same as existing proxy
-	input := Proxy{Listen: proxy.Listen, Upstream: proxy.Upstream, Enabled: proxy.Enabled}
+	input := Proxy{
+		Listen:             proxy.Listen,
+		Upstream:           proxy.Upstream,
+		Upstreams:          proxy.Upstreams,
+		Policy:             proxy.Policy,
+		Enabled:            proxy.Enabled,
+		IdleTimeout:        proxy.IdleTimeout,
+		MaxSessions:        proxy.MaxSessions,
+		Seed:               proxy.Seed,
+		Transparent:        proxy.Transparent,
+		Demultiplexer:      proxy.Demultiplexer,
+		MulticastInterface: proxy.MulticastInterface,
+		Capture:            proxy.Capture,
+		Record:             proxy.Record,
+	}
 	err = json.NewDecoder(request.Body).Decode(&input)
 	if server.apiError(response, joinError(err, ErrBadRequestBody)) {
 		return
@@ -345,6 +377,126 @@ func (server *ApiServer) ProxyDelete(response http.ResponseWriter, request *http
 	}
 }
 
+func (server *ApiServer) CaptureStart(response http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+
+	proxy, err := server.Collection.Get(vars["proxy"])
+	if server.apiError(response, err) {
+		return
+	}
+
+	input := struct {
+		Path string `json:"path"`
+	}{}
+	err = json.NewDecoder(request.Body).Decode(&input)
+	if server.apiError(response, joinError(err, ErrBadRequestBody)) {
+		return
+	}
+	if len(input.Path) < 1 {
+		server.apiError(response, joinError(fmt.Errorf("path"), ErrMissingField))
+		return
+	}
+
+	err = proxy.StartCapture(input.Path)
+	if server.apiError(response, err) {
+		return
+	}
+
+	data, err := json.Marshal(proxyWithToxics(proxy))
+	if server.apiError(response, err) {
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusCreated)
+	_, err = response.Write(data)
+	if err != nil {
+		log := zerolog.Ctx(request.Context())
+		log.Warn().Err(err).Msg("CaptureStart: Failed to write response to client")
+	}
+}
+
+func (server *ApiServer) CaptureStop(response http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+
+	proxy, err := server.Collection.Get(vars["proxy"])
+	if server.apiError(response, err) {
+		return
+	}
+
+	err = proxy.StopCapture()
+	if server.apiError(response, err) {
+		return
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+	_, err = response.Write(nil)
+	if err != nil {
+		log := zerolog.Ctx(request.Context())
+		log.Warn().Err(err).Msg("CaptureStop: Failed to write headers to client")
+	}
+}
+
+func (server *ApiServer) RecordStart(response http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+
+	proxy, err := server.Collection.Get(vars["proxy"])
+	if server.apiError(response, err) {
+		return
+	}
+
+	input := struct {
+		Path string `json:"path"`
+	}{}
+	err = json.NewDecoder(request.Body).Decode(&input)
+	if server.apiError(response, joinError(err, ErrBadRequestBody)) {
+		return
+	}
+	if len(input.Path) < 1 {
+		server.apiError(response, joinError(fmt.Errorf("path"), ErrMissingField))
+		return
+	}
+
+	err = proxy.StartRecording(input.Path)
+	if server.apiError(response, err) {
+		return
+	}
+
+	data, err := json.Marshal(proxyWithToxics(proxy))
+	if server.apiError(response, err) {
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusCreated)
+	_, err = response.Write(data)
+	if err != nil {
+		log := zerolog.Ctx(request.Context())
+		log.Warn().Err(err).Msg("RecordStart: Failed to write response to client")
+	}
+}
+
+func (server *ApiServer) RecordStop(response http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+
+	proxy, err := server.Collection.Get(vars["proxy"])
+	if server.apiError(response, err) {
+		return
+	}
+
+	err = proxy.StopRecording()
+	if server.apiError(response, err) {
+		return
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+	_, err = response.Write(nil)
+	if err != nil {
+		log := zerolog.Ctx(request.Context())
+		log.Warn().Err(err).Msg("RecordStop: Failed to write headers to client")
+	}
+}
+
 func (server *ApiServer) ToxicIndex(response http.ResponseWriter, request *http.Request) {
 	vars := mux.Vars(request)
 
@@ -512,9 +664,23 @@ var (
 		"stream was invalid, can be either upstream or downstream",
 		http.StatusBadRequest,
 	)
-	ErrInvalidToxicType   = newError("invalid toxic type", http.StatusBadRequest)
-	ErrToxicAlreadyExists = newError("toxic already exists", http.StatusConflict)
-	ErrToxicNotFound      = newError("toxic not found", http.StatusNotFound)
+	ErrInvalidToxicType    = newError("invalid toxic type", http.StatusBadRequest)
+	ErrInvalidToxicityMode = newError(
+		"toxicity mode was invalid, can be either packet or link",
+		http.StatusBadRequest,
+	)
+	ErrInvalidToxicAttributes  = newError("invalid toxic attributes", http.StatusBadRequest)
+	ErrInvalidProfile          = newError("invalid toxic profile", http.StatusBadRequest)
+	ErrInvalidSelector         = newError("invalid toxic selector", http.StatusBadRequest)
+	ErrInvalidMatch            = newError("invalid toxic match", http.StatusBadRequest)
+	ErrInvalidCapturePath      = newError("invalid capture path", http.StatusBadRequest)
+	ErrCaptureAlreadyStarted   = newError("capture already started", http.StatusConflict)
+	ErrCaptureNotStarted       = newError("capture not started", http.StatusNotFound)
+	ErrInvalidRecordPath       = newError("invalid recording path", http.StatusBadRequest)
+	ErrRecordingAlreadyStarted = newError("recording already started", http.StatusConflict)
+	ErrRecordingNotStarted     = newError("recording not started", http.StatusNotFound)
+	ErrToxicAlreadyExists      = newError("toxic already exists", http.StatusConflict)
+	ErrToxicNotFound           = newError("toxic not found", http.StatusNotFound)
 )
 
 func (server *ApiServer) apiError(resp http.ResponseWriter, err error) bool {
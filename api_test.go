@@ -2,24 +2,31 @@ package toxiproxy_test
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 
-	"github.com/Shopify/toxiproxy/v2"
-	tclient "github.com/Shopify/toxiproxy/v2/client"
+	toxiproxy "github.com/badrootd/udpcrusher"
+	"github.com/badrootd/udpcrusher/capture"
+	tclient "github.com/badrootd/udpcrusher/client"
+	"github.com/badrootd/udpcrusher/replay"
 )
 
 var testServer *toxiproxy.ApiServer
 
 var client = tclient.NewClient("http://127.0.0.1:8475")
 
+var ctx = context.Background()
+
 func WithServer(t *testing.T, f func(string)) {
 	log := zerolog.Nop()
 	if flag.Lookup("test.v").DefValue == "true" {
@@ -114,7 +121,7 @@ func TestNonBrowserGets200(t *testing.T) {
 func TestIndexWithNoProxies(t *testing.T) {
 	WithServer(t, func(addr string) {
 		client := tclient.NewClient(addr)
-		proxies, err := client.Proxies()
+		proxies, err := client.Proxies(ctx)
 		if err != nil {
 			t.Fatal("Failed getting proxies:", err)
 		}
@@ -127,7 +134,7 @@ func TestIndexWithNoProxies(t *testing.T) {
 
 func TestCreateProxyBlankName(t *testing.T) {
 	WithServer(t, func(addr string) {
-		_, err := client.CreateProxy("", "", "")
+		_, err := client.CreateProxy(ctx, "", "", "")
 
 		expected := "Create: HTTP 400: missing required field: name"
 		if err == nil {
@@ -140,7 +147,7 @@ func TestCreateProxyBlankName(t *testing.T) {
 
 func TestCreateProxyBlankUpstream(t *testing.T) {
 	WithServer(t, func(addr string) {
-		_, err := client.CreateProxy("test", "", "")
+		_, err := client.CreateProxy(ctx, "test", "", "")
 		if err == nil {
 			t.Error("Expected error creating proxy, got nil")
 		} else if err.Error() != "Create: HTTP 400: missing required field: upstream" {
@@ -151,7 +158,7 @@ func TestCreateProxyBlankUpstream(t *testing.T) {
 
 func TestPopulateProxy(t *testing.T) {
 	WithServer(t, func(addr string) {
-		testProxies, err := client.Populate([]tclient.Proxy{
+		testProxies, err := client.Populate(ctx, []tclient.Proxy{
 			{
 				Name:     "one",
 				Listen:   "localhost:7070",
@@ -201,7 +208,7 @@ func TestPopulateDefaultEnabled(t *testing.T) {
 			t.Fatalf("Failed to populate proxy list: HTTP %s\n%s", resp.Status, string(message))
 		}
 
-		proxies, err := client.Proxies()
+		proxies, err := client.Proxies(ctx)
 		if err != nil {
 			t.Fatal(err)
 		} else if len(proxies) != 1 {
@@ -218,7 +225,7 @@ func TestPopulateDefaultEnabled(t *testing.T) {
 
 func TestPopulateDisabledProxy(t *testing.T) {
 	WithServer(t, func(addr string) {
-		testProxies, err := client.Populate([]tclient.Proxy{
+		testProxies, err := client.Populate(ctx, []tclient.Proxy{
 			{
 				Name:     "one",
 				Listen:   "localhost:7070",
@@ -252,22 +259,22 @@ func TestPopulateDisabledProxy(t *testing.T) {
 
 func TestPopulateExistingProxy(t *testing.T) {
 	WithServer(t, func(addr string) {
-		testProxy, err := client.CreateProxy("one", "localhost:7070", "localhost:7171")
+		testProxy, err := client.CreateProxy(ctx, "one", "localhost:7070", "localhost:7171")
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
-		_, err = client.CreateProxy("two", "localhost:7373", "localhost:7474")
+		_, err = client.CreateProxy(ctx, "two", "localhost:7373", "localhost:7474")
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
 
 		// Create a toxic so we can make sure the proxy wasn't replaced
-		_, err = testProxy.AddToxic("", "latency", "downstream", 1, nil)
+		_, err = testProxy.AddToxic(ctx, "", "latency", "downstream", 1, nil)
 		if err != nil {
 			t.Fatal("Unable to create toxic:", err)
 		}
 
-		testProxies, err := client.Populate([]tclient.Proxy{
+		testProxies, err := client.Populate(ctx, []tclient.Proxy{
 			{
 				Name:     "one",
 				Listen:   "127.0.0.1:7070",
@@ -301,7 +308,7 @@ func TestPopulateExistingProxy(t *testing.T) {
 			)
 		}
 
-		toxics, err := testProxy.Toxics()
+		toxics, err := testProxy.Toxics(ctx)
 		if err != nil {
 			t.Fatal("Unable to get toxics:", err)
 		}
@@ -317,7 +324,7 @@ func TestPopulateExistingProxy(t *testing.T) {
 
 func TestPopulateWithBadName(t *testing.T) {
 	WithServer(t, func(addr string) {
-		testProxies, err := client.Populate([]tclient.Proxy{
+		testProxies, err := client.Populate(ctx, []tclient.Proxy{
 			{
 				Name:     "one",
 				Listen:   "localhost:7070",
@@ -344,7 +351,7 @@ func TestPopulateWithBadName(t *testing.T) {
 			t.Fatalf("Wrong number of proxies returned: %d != 0", len(testProxies))
 		}
 
-		proxies, err := client.Proxies()
+		proxies, err := client.Proxies(ctx)
 		if err != nil {
 			t.Fatal(err)
 		} else if len(proxies) != 0 {
@@ -355,7 +362,7 @@ func TestPopulateWithBadName(t *testing.T) {
 
 func TestPopulateProxyWithBadDataShouldReturnError(t *testing.T) {
 	WithServer(t, func(addr string) {
-		testProxies, err := client.Populate([]tclient.Proxy{
+		testProxies, err := client.Populate(ctx, []tclient.Proxy{
 			{
 				Name:     "one",
 				Listen:   "localhost:7070",
@@ -384,7 +391,7 @@ func TestPopulateProxyWithBadDataShouldReturnError(t *testing.T) {
 			t.Fatalf("Expected Proxies to be empty, got %v", testProxies)
 		}
 
-		proxies, err := client.Proxies()
+		proxies, err := client.Proxies(ctx)
 		if err != nil {
 			t.Fatalf("Expected no error, got: %v", err)
 		}
@@ -411,7 +418,7 @@ func TestPopulateProxyWithBadDataShouldReturnError(t *testing.T) {
 
 func TestPopulateAddToxic(t *testing.T) {
 	WithServer(t, func(addr string) {
-		testProxies, err := client.Populate([]tclient.Proxy{
+		testProxies, err := client.Populate(ctx, []tclient.Proxy{
 			{
 				Name:     "one",
 				Listen:   "localhost:7070",
@@ -432,7 +439,7 @@ func TestPopulateAddToxic(t *testing.T) {
 			t.Fatalf("Wrong proxy name returned: %s != one", testProxies[0].Name)
 		}
 
-		_, err = testProxies[0].AddToxic("", "latency", "downstream", 1, nil)
+		_, err = testProxies[0].AddToxic(ctx, "", "latency", "downstream", 1, nil)
 		if err != nil {
 			t.Fatal("Failed to AddToxic.")
 		}
@@ -441,12 +448,12 @@ func TestPopulateAddToxic(t *testing.T) {
 
 func TestListingProxies(t *testing.T) {
 	WithServer(t, func(addr string) {
-		_, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		_, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
 
-		proxies, err := client.Proxies()
+		proxies, err := client.Proxies(ctx)
 		if err != nil {
 			t.Fatal("Error listing proxies:", err)
 		}
@@ -473,12 +480,12 @@ func TestListingProxies(t *testing.T) {
 
 func TestCreateAndGetProxy(t *testing.T) {
 	WithServer(t, func(addr string) {
-		_, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		_, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
 
-		proxy, err := client.Proxy("mysql_master")
+		proxy, err := client.Proxy(ctx, "mysql_master")
 		if err != nil {
 			t.Fatal("Unable to retriecve proxy:", err)
 		}
@@ -507,12 +514,12 @@ func TestCreateProxyWithSave(t *testing.T) {
 		testProxy.Upstream = "localhost:20001"
 		testProxy.Enabled = true
 
-		err := testProxy.Save()
+		err := testProxy.Save(ctx)
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
 
-		proxy, err := client.Proxy("mysql_master")
+		proxy, err := client.Proxy(ctx, "mysql_master")
 		if err != nil {
 			t.Fatal("Unable to retriecve proxy:", err)
 		}
@@ -540,12 +547,12 @@ func TestCreateDisabledProxy(t *testing.T) {
 		disabledProxy.Listen = "localhost:3310"
 		disabledProxy.Upstream = "localhost:20001"
 
-		err := disabledProxy.Save()
+		err := disabledProxy.Save(ctx)
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
 
-		proxy, err := client.Proxy("mysql_master")
+		proxy, err := client.Proxy(ctx, "mysql_master")
 		if err != nil {
 			t.Fatal("Unable to retriecve proxy:", err)
 		}
@@ -573,12 +580,12 @@ func TestCreateDisabledProxyAndEnable(t *testing.T) {
 		disabledProxy.Listen = "localhost:3310"
 		disabledProxy.Upstream = "localhost:20001"
 
-		err := disabledProxy.Save()
+		err := disabledProxy.Save(ctx)
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
 
-		proxy, err := client.Proxy("mysql_master")
+		proxy, err := client.Proxy(ctx, "mysql_master")
 		if err != nil {
 			t.Fatal("Unable to retriecve proxy:", err)
 		}
@@ -597,7 +604,7 @@ func TestCreateDisabledProxyAndEnable(t *testing.T) {
 
 		proxy.Enabled = true
 
-		err = proxy.Save()
+		err = proxy.Save(ctx)
 		if err != nil {
 			t.Fatal("Failed to update proxy:", err)
 		}
@@ -606,7 +613,7 @@ func TestCreateDisabledProxyAndEnable(t *testing.T) {
 
 		proxy.Enabled = false
 
-		err = proxy.Save()
+		err = proxy.Save(ctx)
 		if err != nil {
 			t.Fatal("Failed to update proxy:", err)
 		}
@@ -615,14 +622,48 @@ func TestCreateDisabledProxyAndEnable(t *testing.T) {
 	})
 }
 
+func TestResetProxySettings(t *testing.T) {
+	WithServer(t, func(addr string) {
+		proxy, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		proxy.MaxSessions = 5
+		proxy.Seed = 42
+		err = proxy.Save(ctx)
+		if err != nil {
+			t.Fatal("Failed to update proxy:", err)
+		}
+		if proxy.MaxSessions != 5 || proxy.Seed != 42 {
+			t.Fatal("Expected the settings to be set, got:", proxy.MaxSessions, proxy.Seed)
+		}
+
+		proxy.MaxSessions = 0
+		proxy.Seed = 0
+		err = proxy.Save(ctx)
+		if err != nil {
+			t.Fatal("Failed to update proxy:", err)
+		}
+
+		proxy, err = client.Proxy(ctx, "mysql_master")
+		if err != nil {
+			t.Fatal("Unable to retrieve proxy:", err)
+		}
+		if proxy.MaxSessions != 0 || proxy.Seed != 0 {
+			t.Fatal("Expected the settings to be reset, got:", proxy.MaxSessions, proxy.Seed)
+		}
+	})
+}
+
 func TestDeleteProxy(t *testing.T) {
 	WithServer(t, func(addr string) {
-		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		testProxy, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
 
-		proxies, err := client.Proxies()
+		proxies, err := client.Proxies(ctx)
 		if err != nil {
 			t.Fatal("Error listing proxies:", err)
 		}
@@ -633,14 +674,14 @@ func TestDeleteProxy(t *testing.T) {
 
 		AssertProxyUp(t, testProxy.Listen, true)
 
-		err = testProxy.Delete()
+		err = testProxy.Delete(ctx)
 		if err != nil {
 			t.Fatal("Failed deleting proxy:", err)
 		}
 
 		AssertProxyUp(t, testProxy.Listen, false)
 
-		proxies, err = client.Proxies()
+		proxies, err = client.Proxies(ctx)
 		if err != nil {
 			t.Fatal("Error listing proxies:", err)
 		}
@@ -650,7 +691,7 @@ func TestDeleteProxy(t *testing.T) {
 		}
 
 		expected := "Delete: HTTP 404: proxy not found"
-		err = testProxy.Delete()
+		err = testProxy.Delete(ctx)
 		if err == nil {
 			t.Error("Proxy did not result in not found.")
 		} else if err.Error() != expected {
@@ -661,24 +702,24 @@ func TestDeleteProxy(t *testing.T) {
 
 func TestCreateProxyPortConflict(t *testing.T) {
 	WithServer(t, func(addr string) {
-		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		testProxy, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
 
-		expected := "Create: HTTP 500: listen tcp 127.0.0.1:3310: bind: address already in use"
-		_, err = client.CreateProxy("test", "localhost:3310", "localhost:20001")
+		expected := "Create: HTTP 500: listen udp 127.0.0.1:3310: bind: address already in use"
+		_, err = client.CreateProxy(ctx, "test", "localhost:3310", "localhost:20001")
 		if err == nil {
 			t.Error("Proxy did not result in conflict.")
 		} else if err.Error() != expected {
 			t.Errorf("Expected error `%s',\n\tgot: `%s'", expected, err)
 		}
 
-		err = testProxy.Delete()
+		err = testProxy.Delete(ctx)
 		if err != nil {
 			t.Fatal("Unable to delete proxy:", err)
 		}
-		_, err = client.CreateProxy("test", "localhost:3310", "localhost:20001")
+		_, err = client.CreateProxy(ctx, "test", "localhost:3310", "localhost:20001")
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
@@ -687,24 +728,24 @@ func TestCreateProxyPortConflict(t *testing.T) {
 
 func TestCreateProxyNameConflict(t *testing.T) {
 	WithServer(t, func(addr string) {
-		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		testProxy, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
 
 		expected := "Create: HTTP 409: proxy already exists"
-		_, err = client.CreateProxy("mysql_master", "localhost:3311", "localhost:20001")
+		_, err = client.CreateProxy(ctx, "mysql_master", "localhost:3311", "localhost:20001")
 		if err == nil {
 			t.Fatal("Proxy did not result in conflict.")
 		} else if err.Error() != expected {
 			t.Fatalf("Expected error `%s',\n\tgot: `%s'", expected, err)
 		}
 
-		err = testProxy.Delete()
+		err = testProxy.Delete(ctx)
 		if err != nil {
 			t.Fatal("Unable to delete proxy:", err)
 		}
-		_, err = client.CreateProxy("mysql_master", "localhost:3311", "localhost:20001")
+		_, err = client.CreateProxy(ctx, "mysql_master", "localhost:3311", "localhost:20001")
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
@@ -713,12 +754,12 @@ func TestCreateProxyNameConflict(t *testing.T) {
 
 func TestResetState(t *testing.T) {
 	WithServer(t, func(addr string) {
-		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		testProxy, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
 
-		latency, err := testProxy.AddToxic("", "latency", "downstream", 1, tclient.Attributes{
+		latency, err := testProxy.AddToxic(ctx, "", "latency", "downstream", 1, tclient.Attributes{
 			"latency": 100,
 			"jitter":  10,
 		})
@@ -730,12 +771,12 @@ func TestResetState(t *testing.T) {
 			t.Fatal("Latency toxic did not start up with correct settings")
 		}
 
-		err = client.ResetState()
+		err = client.ResetState(ctx)
 		if err != nil {
 			t.Fatal("unable to reset state:", err)
 		}
 
-		proxies, err := client.Proxies()
+		proxies, err := client.Proxies(ctx)
 		if err != nil {
 			t.Fatal("Error listing proxies:", err)
 		}
@@ -748,7 +789,7 @@ func TestResetState(t *testing.T) {
 			t.Fatal("Expected proxy to be enabled")
 		}
 
-		toxics, err := proxy.Toxics()
+		toxics, err := proxy.Toxics(ctx)
 		if err != nil {
 			t.Fatal("Error requesting toxics:", err)
 		}
@@ -761,12 +802,12 @@ func TestResetState(t *testing.T) {
 
 func TestListingToxics(t *testing.T) {
 	WithServer(t, func(addr string) {
-		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		testProxy, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
 
-		toxics, err := testProxy.Toxics()
+		toxics, err := testProxy.Toxics(ctx)
 		if err != nil {
 			t.Fatal("Error returning toxics:", err)
 		}
@@ -777,12 +818,12 @@ func TestListingToxics(t *testing.T) {
 
 func TestAddToxic(t *testing.T) {
 	WithServer(t, func(addr string) {
-		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		testProxy, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
 
-		latency, err := testProxy.AddToxic("foobar", "latency", "downstream", 1, tclient.Attributes{
+		latency, err := testProxy.AddToxic(ctx, "foobar", "latency", "downstream", 1, tclient.Attributes{
 			"latency": 100,
 			"jitter":  10,
 		})
@@ -794,7 +835,7 @@ func TestAddToxic(t *testing.T) {
 			t.Fatal("Latency toxic did not start up with correct settings")
 		}
 
-		toxics, err := testProxy.Toxics()
+		toxics, err := testProxy.Toxics(ctx)
 		if err != nil {
 			t.Fatal("Error returning toxics:", err)
 		}
@@ -806,24 +847,48 @@ func TestAddToxic(t *testing.T) {
 	})
 }
 
+func TestAddCorruptToxic(t *testing.T) {
+	WithServer(t, func(addr string) {
+		testProxy, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		corrupt, err := testProxy.AddToxic(ctx, "", "corrupt", "upstream", 1, tclient.Attributes{
+			"mode":        "zero",
+			"probability": 0.5,
+			"offset":      4,
+			"length":      2,
+		})
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+
+		if corrupt.Attributes["mode"] != "zero" || corrupt.Attributes["probability"] != 0.5 ||
+			corrupt.Attributes["offset"] != 4.0 || corrupt.Attributes["length"] != 2.0 {
+			t.Fatal("Corrupt toxic did not start up with correct settings:", corrupt)
+		}
+	})
+}
+
 func TestAddMultipleToxics(t *testing.T) {
 	WithServer(t, func(addr string) {
-		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		testProxy, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
 
-		_, err = testProxy.AddToxic("latency1", "latency", "downstream", 1, nil)
+		_, err = testProxy.AddToxic(ctx, "latency1", "latency", "downstream", 1, nil)
 		if err != nil {
 			t.Fatal("Error setting toxic:", err)
 		}
 
-		_, err = testProxy.AddToxic("latency2", "latency", "downstream", 1, nil)
+		_, err = testProxy.AddToxic(ctx, "latency2", "latency", "downstream", 1, nil)
 		if err != nil {
 			t.Fatal("Error setting toxic:", err)
 		}
 
-		toxics, err := testProxy.Toxics()
+		toxics, err := testProxy.Toxics(ctx)
 		if err != nil {
 			t.Fatal("Error returning toxics:", err)
 		}
@@ -840,24 +905,24 @@ func TestAddMultipleToxics(t *testing.T) {
 
 func TestAddConflictingToxic(t *testing.T) {
 	WithServer(t, func(addr string) {
-		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		testProxy, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
 
-		_, err = testProxy.AddToxic("foobar", "latency", "downstream", 1, nil)
+		_, err = testProxy.AddToxic(ctx, "foobar", "latency", "downstream", 1, nil)
 		if err != nil {
 			t.Fatal("Error setting toxic:", err)
 		}
 
-		_, err = testProxy.AddToxic("foobar", "slow_close", "downstream", 1, nil)
+		_, err = testProxy.AddToxic(ctx, "foobar", "bandwidth", "downstream", 1, nil)
 		if err == nil {
 			t.Fatal("Toxic did not result in conflict.")
 		} else if err.Error() != "AddToxic: HTTP 409: toxic already exists" {
 			t.Fatal("Incorrect error setting toxic:", err)
 		}
 
-		toxics, err := testProxy.Toxics()
+		toxics, err := testProxy.Toxics(ctx)
 		if err != nil {
 			t.Fatal("Error returning toxics:", err)
 		}
@@ -872,24 +937,24 @@ func TestAddConflictingToxic(t *testing.T) {
 
 func TestAddConflictingToxicsMultistream(t *testing.T) {
 	WithServer(t, func(addr string) {
-		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		testProxy, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
 
-		_, err = testProxy.AddToxic("foobar", "latency", "upstream", 1, nil)
+		_, err = testProxy.AddToxic(ctx, "foobar", "latency", "upstream", 1, nil)
 		if err != nil {
 			t.Fatal("Error setting toxic:", err)
 		}
 
-		_, err = testProxy.AddToxic("foobar", "latency", "downstream", 1, nil)
+		_, err = testProxy.AddToxic(ctx, "foobar", "latency", "downstream", 1, nil)
 		if err == nil {
 			t.Fatal("Toxic did not result in conflict.")
 		} else if err.Error() != "AddToxic: HTTP 409: toxic already exists" {
 			t.Fatal("Incorrect error setting toxic:", err)
 		}
 
-		toxics, err := testProxy.Toxics()
+		toxics, err := testProxy.Toxics(ctx)
 		if err != nil {
 			t.Fatal("Error returning toxics:", err)
 		}
@@ -905,22 +970,22 @@ func TestAddConflictingToxicsMultistream(t *testing.T) {
 
 func TestAddConflictingToxicsMultistreamDefaults(t *testing.T) {
 	WithServer(t, func(addr string) {
-		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		testProxy, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
 
-		_, err = testProxy.AddToxic("", "latency", "upstream", 1, nil)
+		_, err = testProxy.AddToxic(ctx, "", "latency", "upstream", 1, nil)
 		if err != nil {
 			t.Fatal("Error setting toxic:", err)
 		}
 
-		_, err = testProxy.AddToxic("", "latency", "downstream", 1, nil)
+		_, err = testProxy.AddToxic(ctx, "", "latency", "downstream", 1, nil)
 		if err != nil {
 			t.Fatal("Error setting toxic:", err)
 		}
 
-		toxics, err := testProxy.Toxics()
+		toxics, err := testProxy.Toxics(ctx)
 		if err != nil {
 			t.Fatal("Error returning toxics:", err)
 		}
@@ -939,12 +1004,12 @@ func TestAddConflictingToxicsMultistreamDefaults(t *testing.T) {
 
 func TestAddToxicWithToxicity(t *testing.T) {
 	WithServer(t, func(addr string) {
-		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		testProxy, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
 
-		latency, err := testProxy.AddToxic("", "latency", "downstream", 0.2, tclient.Attributes{
+		latency, err := testProxy.AddToxic(ctx, "", "latency", "downstream", 0.2, tclient.Attributes{
 			"latency": 100,
 			"jitter":  10,
 		})
@@ -957,7 +1022,7 @@ func TestAddToxicWithToxicity(t *testing.T) {
 			t.Fatal("Latency toxic did not start up with correct settings:", latency)
 		}
 
-		toxics, err := testProxy.Toxics()
+		toxics, err := testProxy.Toxics(ctx)
 		if err != nil {
 			t.Fatal("Error returning toxics:", err)
 		}
@@ -971,12 +1036,12 @@ func TestAddToxicWithToxicity(t *testing.T) {
 
 func TestAddNoop(t *testing.T) {
 	WithServer(t, func(addr string) {
-		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		testProxy, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
 
-		noop, err := testProxy.AddToxic("foobar", "noop", "", 1, nil)
+		noop, err := testProxy.AddToxic(ctx, "foobar", "noop", "", 1, nil)
 		if err != nil {
 			t.Fatal("Error setting toxic:", err)
 		}
@@ -986,7 +1051,7 @@ func TestAddNoop(t *testing.T) {
 			t.Fatal("Noop toxic did not start up with correct settings:", noop)
 		}
 
-		toxics, err := testProxy.Toxics()
+		toxics, err := testProxy.Toxics(ctx)
 		if err != nil {
 			t.Fatal("Error returning toxics:", err)
 		}
@@ -999,12 +1064,12 @@ func TestAddNoop(t *testing.T) {
 
 func TestUpdateToxics(t *testing.T) {
 	WithServer(t, func(addr string) {
-		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		testProxy, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
 
-		latency, err := testProxy.AddToxic("", "latency", "downstream", -1, tclient.Attributes{
+		latency, err := testProxy.AddToxic(ctx, "", "latency", "downstream", -1, tclient.Attributes{
 			"latency": 100,
 			"jitter":  10,
 		})
@@ -1017,7 +1082,7 @@ func TestUpdateToxics(t *testing.T) {
 			t.Fatal("Latency toxic did not start up with correct settings:", latency)
 		}
 
-		latency, err = testProxy.UpdateToxic("latency_downstream", 0.5, tclient.Attributes{
+		latency, err = testProxy.UpdateToxic(ctx, "latency_downstream", 0.5, tclient.Attributes{
 			"latency": 1000,
 		})
 		if err != nil {
@@ -1029,7 +1094,7 @@ func TestUpdateToxics(t *testing.T) {
 			t.Fatal("Latency toxic did not get updated with the correct settings:", latency)
 		}
 
-		latency, err = testProxy.UpdateToxic("latency_downstream", -1, tclient.Attributes{
+		latency, err = testProxy.UpdateToxic(ctx, "latency_downstream", -1, tclient.Attributes{
 			"latency": 500,
 		})
 		if err != nil {
@@ -1041,7 +1106,7 @@ func TestUpdateToxics(t *testing.T) {
 			t.Fatal("Latency toxic did not get updated with the correct settings:", latency)
 		}
 
-		toxics, err := testProxy.Toxics()
+		toxics, err := testProxy.Toxics(ctx)
 		if err != nil {
 			t.Fatal("Error returning toxics:", err)
 		}
@@ -1056,17 +1121,17 @@ func TestUpdateToxics(t *testing.T) {
 
 func TestRemoveToxic(t *testing.T) {
 	WithServer(t, func(addr string) {
-		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		testProxy, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
 
-		_, err = testProxy.AddToxic("", "latency", "downstream", 1, nil)
+		_, err = testProxy.AddToxic(ctx, "", "latency", "downstream", 1, nil)
 		if err != nil {
 			t.Fatal("Error setting toxic:", err)
 		}
 
-		toxics, err := testProxy.Toxics()
+		toxics, err := testProxy.Toxics(ctx)
 		if err != nil {
 			t.Fatal("Error returning toxics:", err)
 		}
@@ -1077,12 +1142,12 @@ func TestRemoveToxic(t *testing.T) {
 			t.Fatal("Toxic was not read back correctly:", toxic)
 		}
 
-		err = testProxy.RemoveToxic("latency_downstream")
+		err = testProxy.RemoveToxic(ctx, "latency_downstream")
 		if err != nil {
 			t.Fatal("Error removing toxic:", err)
 		}
 
-		toxics, err = testProxy.Toxics()
+		toxics, err = testProxy.Toxics(ctx)
 		if err != nil {
 			t.Fatal("Error returning toxics:", err)
 		}
@@ -1111,18 +1176,573 @@ func TestVersionEndpointReturnsVersion(t *testing.T) {
 
 func TestInvalidStream(t *testing.T) {
 	WithServer(t, func(addr string) {
-		testProxy, err := client.CreateProxy("mysql_master", "localhost:3310", "localhost:20001")
+		testProxy, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
 		if err != nil {
 			t.Fatal("Unable to create proxy:", err)
 		}
 
-		_, err = testProxy.AddToxic("", "latency", "walrustream", 1, nil)
+		_, err = testProxy.AddToxic(ctx, "", "latency", "walrustream", 1, nil)
 		if err == nil {
 			t.Fatal("Error setting toxic:", err)
 		}
 	})
 }
 
+func TestInvalidToxicityMode(t *testing.T) {
+	WithServer(t, func(addr string) {
+		_, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		_, err = client.AddToxic(ctx, &tclient.ToxicOptions{
+			ProxyName:    "mysql_master",
+			ToxicType:    "latency",
+			ToxicityMode: "walrus",
+			Toxicity:     1,
+		})
+		if err == nil || !strings.Contains(err.Error(), "toxicity mode was invalid") {
+			t.Fatal("Expected an invalid toxicity mode error, got:", err)
+		}
+
+		toxic, err := client.AddToxic(ctx, &tclient.ToxicOptions{
+			ProxyName:    "mysql_master",
+			ToxicType:    "latency",
+			ToxicityMode: "link",
+			Toxicity:     0.5,
+		})
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+		if toxic.ToxicityMode != "link" {
+			t.Fatal("Expected toxicity mode link, got:", toxic.ToxicityMode)
+		}
+	})
+}
+
+func TestUpdateToxicityMode(t *testing.T) {
+	WithServer(t, func(addr string) {
+		_, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		_, err = client.AddToxic(ctx, &tclient.ToxicOptions{
+			ProxyName:    "mysql_master",
+			ToxicType:    "latency",
+			ToxicityMode: "link",
+			Toxicity:     0.5,
+		})
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+
+		_, err = client.UpdateToxic(ctx, &tclient.ToxicOptions{
+			ProxyName:    "mysql_master",
+			ToxicName:    "latency_downstream",
+			ToxicityMode: "walrus",
+			Toxicity:     -1,
+		})
+		if err == nil || !strings.Contains(err.Error(), "toxicity mode was invalid") {
+			t.Fatal("Expected an invalid toxicity mode error, got:", err)
+		}
+
+		// An empty mode keeps the current one
+		toxic, err := client.UpdateToxic(ctx, &tclient.ToxicOptions{
+			ProxyName: "mysql_master",
+			ToxicName: "latency_downstream",
+			Toxicity:  -1,
+		})
+		if err != nil {
+			t.Fatal("Error updating toxic:", err)
+		}
+		if toxic.ToxicityMode != "link" || toxic.Toxicity != 0.5 {
+			t.Fatal("Expected toxicity mode link and toxicity 0.5, got:", toxic.ToxicityMode, toxic.Toxicity)
+		}
+
+		toxic, err = client.UpdateToxic(ctx, &tclient.ToxicOptions{
+			ProxyName:    "mysql_master",
+			ToxicName:    "latency_downstream",
+			ToxicityMode: "packet",
+			Toxicity:     -1,
+		})
+		if err != nil {
+			t.Fatal("Error updating toxic:", err)
+		}
+		if toxic.ToxicityMode != "packet" {
+			t.Fatal("Expected toxicity mode packet, got:", toxic.ToxicityMode)
+		}
+	})
+}
+
+func TestInvalidToxicAttributes(t *testing.T) {
+	WithServer(t, func(addr string) {
+		testProxy, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		_, err = testProxy.AddToxic(ctx, "", "loss", "downstream", 1, tclient.Attributes{
+			"model": "uniformm",
+		})
+		if err == nil || !strings.Contains(err.Error(), "invalid toxic attributes") {
+			t.Fatal("Expected an invalid toxic attributes error, got:", err)
+		}
+
+		_, err = testProxy.AddToxic(ctx, "", "dns_rcode", "downstream", 1, tclient.Attributes{
+			"rcode": "nxdomian",
+		})
+		if err == nil || !strings.Contains(err.Error(), "invalid toxic attributes") {
+			t.Fatal("Expected an invalid toxic attributes error, got:", err)
+		}
+
+		_, err = testProxy.AddToxic(ctx, "", "loss", "downstream", 1, tclient.Attributes{
+			"model": "nth",
+			"nth":   3,
+		})
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+
+		_, err = testProxy.UpdateToxic(ctx, "loss_downstream", 1, tclient.Attributes{
+			"model": "bogus",
+		})
+		if err == nil || !strings.Contains(err.Error(), "invalid toxic attributes") {
+			t.Fatal("Expected an invalid toxic attributes error, got:", err)
+		}
+
+		toxics, err := testProxy.Toxics(ctx)
+		if err != nil {
+			t.Fatal("Error returning toxics:", err)
+		}
+		toxic := AssertToxicExists(t, toxics, "loss_downstream", "loss", "downstream", true)
+		if toxic.Attributes["model"] != "nth" || toxic.Attributes["nth"] != 3.0 {
+			t.Fatal("Expected the rejected update to keep the attributes, got:", toxic.Attributes)
+		}
+	})
+}
+
+func TestUpdateToxicSelector(t *testing.T) {
+	WithServer(t, func(addr string) {
+		_, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		toxic, err := client.AddToxic(ctx, &tclient.ToxicOptions{
+			ProxyName:  "mysql_master",
+			ToxicType:  "latency",
+			Toxicity:   1,
+			Attributes: tclient.Attributes{"latency": 10},
+			Selector:   &tclient.Selector{Source: "127.0.0.0/8"},
+		})
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+		if toxic.Selector == nil || toxic.Selector.Source != "127.0.0.0/8" {
+			t.Fatal("Toxic selector was not returned:", toxic.Selector)
+		}
+
+		_, err = client.UpdateToxic(ctx, &tclient.ToxicOptions{
+			ProxyName: "mysql_master",
+			ToxicName: toxic.Name,
+			Toxicity:  -1,
+			Selector:  &tclient.Selector{Ports: "40100-40000"},
+		})
+		if err == nil || !strings.Contains(err.Error(), "invalid toxic selector") {
+			t.Fatal("Expected an invalid toxic selector error, got:", err)
+		}
+
+		// Updating the attributes keeps the selector
+		toxic, err = client.UpdateToxic(ctx, &tclient.ToxicOptions{
+			ProxyName:  "mysql_master",
+			ToxicName:  toxic.Name,
+			Toxicity:   -1,
+			Attributes: tclient.Attributes{"latency": 20},
+		})
+		if err != nil {
+			t.Fatal("Error updating toxic:", err)
+		}
+		if toxic.Selector == nil || toxic.Selector.Source != "127.0.0.0/8" {
+			t.Fatal("Expected the selector to be kept, got:", toxic.Selector)
+		}
+
+		toxic, err = client.UpdateToxic(ctx, &tclient.ToxicOptions{
+			ProxyName: "mysql_master",
+			ToxicName: toxic.Name,
+			Toxicity:  -1,
+			Selector:  &tclient.Selector{Ports: "40000-40100"},
+		})
+		if err != nil {
+			t.Fatal("Error updating toxic:", err)
+		}
+		if toxic.Selector == nil || toxic.Selector.Source != "" || toxic.Selector.Ports != "40000-40100" {
+			t.Fatal("Expected the selector to be replaced, got:", toxic.Selector)
+		}
+
+		toxic, err = client.UpdateToxic(ctx, &tclient.ToxicOptions{
+			ProxyName:      "mysql_master",
+			ToxicName:      toxic.Name,
+			Toxicity:       -1,
+			RemoveSelector: true,
+		})
+		if err != nil {
+			t.Fatal("Error updating toxic:", err)
+		}
+		if toxic.Selector != nil {
+			t.Fatal("Expected the selector to be removed, got:", toxic.Selector)
+		}
+	})
+}
+
+func TestRejectedToxicUpdateKeepsToxic(t *testing.T) {
+	WithServer(t, func(addr string) {
+		testProxy, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		_, err = testProxy.AddToxic(ctx, "", "latency", "downstream", 1, tclient.Attributes{
+			"latency": 10,
+		})
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+
+		for _, options := range []*tclient.ToxicOptions{
+			{ToxicityMode: "walrus"},
+			{Selector: &tclient.Selector{Ports: "walrus"}},
+			{Match: &tclient.Match{Regex: "("}},
+			{Profile: &tclient.Profile{Mode: "walrus"}},
+		} {
+			options.ProxyName = "mysql_master"
+			options.ToxicName = "latency_downstream"
+			options.Toxicity = 0.5
+			options.Attributes = tclient.Attributes{"latency": 50}
+			_, err = client.UpdateToxic(ctx, options)
+			if err == nil {
+				t.Fatalf("Expected the update %+v to be rejected", options)
+			}
+		}
+
+		request, err := http.NewRequest(
+			"PATCH",
+			addr+"/proxies/mysql_master/toxics/latency_downstream",
+			strings.NewReader(`{"attributes":{"latency":50},"toxicity":"walrus"}`),
+		)
+		if err != nil {
+			t.Fatal("Unable to create request:", err)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal("Error updating toxic:", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatal("Expected a bad request, got:", resp.Status)
+		}
+
+		toxics, err := testProxy.Toxics(ctx)
+		if err != nil {
+			t.Fatal("Error returning toxics:", err)
+		}
+		toxic := AssertToxicExists(t, toxics, "latency_downstream", "latency", "downstream", true)
+		if toxic.Attributes["latency"] != 10.0 || toxic.Toxicity != 1 {
+			t.Fatal("Expected the rejected updates to keep the toxic, got:", toxic.Attributes, toxic.Toxicity)
+		}
+	})
+}
+
+func TestToxicMatch(t *testing.T) {
+	WithServer(t, func(addr string) {
+		_, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		_, err = client.AddToxic(ctx, &tclient.ToxicOptions{
+			ProxyName:  "mysql_master",
+			ToxicType:  "latency",
+			Toxicity:   1,
+			Attributes: tclient.Attributes{"latency": 10},
+			Match:      &tclient.Match{Bytes: "40", Mask: "c0c0"},
+		})
+		if err == nil || !strings.Contains(err.Error(), "invalid toxic match") {
+			t.Fatal("Expected an invalid toxic match error, got:", err)
+		}
+
+		toxic, err := client.AddToxic(ctx, &tclient.ToxicOptions{
+			ProxyName:  "mysql_master",
+			ToxicType:  "latency",
+			Toxicity:   1,
+			Attributes: tclient.Attributes{"latency": 10},
+			Match:      &tclient.Match{Bytes: "40", Mask: "c0", MaxLength: 1200},
+		})
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+		if toxic.Match == nil || toxic.Match.Mask != "c0" || toxic.Match.MaxLength != 1200 {
+			t.Fatal("Toxic match was not returned:", toxic.Match)
+		}
+
+		// Updating the attributes keeps the match
+		toxic, err = client.UpdateToxic(ctx, &tclient.ToxicOptions{
+			ProxyName:  "mysql_master",
+			ToxicName:  toxic.Name,
+			Toxicity:   1,
+			Attributes: tclient.Attributes{"latency": 20},
+		})
+		if err != nil {
+			t.Fatal("Error updating toxic:", err)
+		}
+		if toxic.Match == nil {
+			t.Fatal("Expected the match to be kept")
+		}
+
+		_, err = client.UpdateToxic(ctx, &tclient.ToxicOptions{
+			ProxyName: "mysql_master",
+			ToxicName: toxic.Name,
+			Toxicity:  -1,
+			Match:     &tclient.Match{Regex: "("},
+		})
+		if err == nil || !strings.Contains(err.Error(), "invalid toxic match") {
+			t.Fatal("Expected an invalid toxic match error, got:", err)
+		}
+
+		toxic, err = client.UpdateToxic(ctx, &tclient.ToxicOptions{
+			ProxyName: "mysql_master",
+			ToxicName: toxic.Name,
+			Toxicity:  -1,
+			Match:     &tclient.Match{Regex: "^hello"},
+		})
+		if err != nil {
+			t.Fatal("Error updating toxic:", err)
+		}
+		if toxic.Match == nil || toxic.Match.Bytes != "" || toxic.Match.Regex != "^hello" {
+			t.Fatal("Expected the match to be replaced, got:", toxic.Match)
+		}
+
+		toxic, err = client.UpdateToxic(ctx, &tclient.ToxicOptions{
+			ProxyName:   "mysql_master",
+			ToxicName:   toxic.Name,
+			Toxicity:    -1,
+			RemoveMatch: true,
+		})
+		if err != nil {
+			t.Fatal("Error updating toxic:", err)
+		}
+		if toxic.Match != nil {
+			t.Fatal("Expected the match to be removed, got:", toxic.Match)
+		}
+	})
+}
+
+func TestToxicProfile(t *testing.T) {
+	WithServer(t, func(addr string) {
+		testProxy, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		_, err = client.AddToxic(ctx, &tclient.ToxicOptions{
+			ProxyName:  "mysql_master",
+			ToxicType:  "latency",
+			Toxicity:   1,
+			Attributes: tclient.Attributes{"latency": 10},
+			Profile: &tclient.Profile{Points: []tclient.ProfilePoint{
+				{Attributes: map[string]float64{"walrus": 1}},
+			}},
+		})
+		if err == nil || !strings.Contains(err.Error(), "invalid toxic profile") {
+			t.Fatal("Expected an invalid toxic profile error, got:", err)
+		}
+
+		toxic, err := client.AddToxic(ctx, &tclient.ToxicOptions{
+			ProxyName:  "mysql_master",
+			ToxicType:  "latency",
+			Toxicity:   1,
+			Attributes: tclient.Attributes{"latency": 10},
+			Profile: &tclient.Profile{
+				Mode:     "step",
+				Interval: 10,
+				Points: []tclient.ProfilePoint{
+					{Time: 0, Attributes: map[string]float64{"latency": 100}},
+					{Time: 100, Attributes: map[string]float64{"latency": 500}},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal("Error setting toxic:", err)
+		}
+		if toxic.Profile == nil || len(toxic.Profile.Points) != 2 {
+			t.Fatal("Toxic profile was not returned:", toxic.Profile)
+		}
+
+		latency := func() float64 {
+			toxics, err := testProxy.Toxics(ctx)
+			if err != nil {
+				t.Fatal("Error returning toxics:", err)
+			}
+			toxic := AssertToxicExists(t, toxics, "latency_downstream", "latency", "downstream", true)
+			return toxic.Attributes["latency"].(float64)
+		}
+
+		deadline := time.Now().Add(time.Second)
+		for latency() != 500 {
+			if time.Now().After(deadline) {
+				t.Fatal("Profile did not reach the final latency, got", latency())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		toxic, err = client.UpdateToxic(ctx, &tclient.ToxicOptions{
+			ProxyName: "mysql_master",
+			ToxicName: "latency_downstream",
+			Toxicity:  -1,
+			Profile: &tclient.Profile{Points: []tclient.ProfilePoint{
+				{Attributes: map[string]float64{"latency": 50}},
+			}},
+		})
+		if err != nil {
+			t.Fatal("Error updating toxic:", err)
+		}
+		if toxic.Profile == nil || len(toxic.Profile.Points) != 1 {
+			t.Fatal("Expected the profile to be replaced, got:", toxic.Profile)
+		}
+		deadline = time.Now().Add(time.Second)
+		for latency() != 50 {
+			if time.Now().After(deadline) {
+				t.Fatal("Replaced profile did not set the latency, got", latency())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		toxic, err = client.UpdateToxic(ctx, &tclient.ToxicOptions{
+			ProxyName:     "mysql_master",
+			ToxicName:     "latency_downstream",
+			Toxicity:      -1,
+			RemoveProfile: true,
+		})
+		if err != nil {
+			t.Fatal("Error updating toxic:", err)
+		}
+		if toxic.Profile != nil {
+			t.Fatal("Expected the profile to be removed, got:", toxic.Profile)
+		}
+
+		err = testProxy.RemoveToxic(ctx, "latency_downstream")
+		if err != nil {
+			t.Fatal("Error removing toxic:", err)
+		}
+	})
+}
+
+func TestProxyCaptureEndpoints(t *testing.T) {
+	WithServer(t, func(addr string) {
+		testProxy, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		path := filepath.Join(t.TempDir(), "mysql_master.pcapng")
+		err = testProxy.StartCapture(ctx, path)
+		if err != nil {
+			t.Fatal("Unable to start capture:", err)
+		}
+		if testProxy.Capture != path {
+			t.Fatalf("Expected capture %s, got %s", path, testProxy.Capture)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Fatal("Capture file was not created:", err)
+		}
+
+		err = testProxy.StartCapture(ctx, path)
+		if err == nil || !strings.Contains(err.Error(), "capture already started") {
+			t.Fatal("Expected capture already started error, got:", err)
+		}
+
+		err = testProxy.StopCapture(ctx)
+		if err != nil {
+			t.Fatal("Unable to stop capture:", err)
+		}
+		capture.Dir = filepath.Dir(path)
+		defer func() { capture.Dir = "" }()
+		err = testProxy.StartCapture(ctx, "../mysql_master.pcapng")
+		if err == nil || !strings.Contains(err.Error(), "invalid capture path") {
+			t.Fatal("Expected invalid capture path error, got:", err)
+		}
+		if testProxy.Capture != "" {
+			t.Fatal("Expected the rejected capture not to be started, got:", testProxy.Capture)
+		}
+		err = testProxy.StartCapture(ctx, "mysql_master.pcapng")
+		if err != nil {
+			t.Fatal("Unable to start capture in the capture directory:", err)
+		}
+
+		err = testProxy.StopCapture(ctx)
+		if err != nil {
+			t.Fatal("Unable to stop capture:", err)
+		}
+		err = testProxy.StopCapture(ctx)
+		if err == nil || !strings.Contains(err.Error(), "capture not started") {
+			t.Fatal("Expected capture not started error, got:", err)
+		}
+	})
+}
+
+func TestProxyRecordEndpoints(t *testing.T) {
+	WithServer(t, func(addr string) {
+		testProxy, err := client.CreateProxy(ctx, "mysql_master", "localhost:3310", "localhost:20001")
+		if err != nil {
+			t.Fatal("Unable to create proxy:", err)
+		}
+
+		path := filepath.Join(t.TempDir(), "mysql_master.jsonl")
+		err = testProxy.StartRecording(ctx, path)
+		if err != nil {
+			t.Fatal("Unable to start recording:", err)
+		}
+		if testProxy.Record != path {
+			t.Fatalf("Expected recording %s, got %s", path, testProxy.Record)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Fatal("Recording file was not created:", err)
+		}
+
+		err = testProxy.StartRecording(ctx, path)
+		if err == nil || !strings.Contains(err.Error(), "recording already started") {
+			t.Fatal("Expected recording already started error, got:", err)
+		}
+
+		err = testProxy.StopRecording(ctx)
+		if err != nil {
+			t.Fatal("Unable to stop recording:", err)
+		}
+		replay.Dir = filepath.Dir(path)
+		defer func() { replay.Dir = "" }()
+		err = testProxy.StartRecording(ctx, "../mysql_master.jsonl")
+		if err == nil || !strings.Contains(err.Error(), "invalid recording path") {
+			t.Fatal("Expected invalid recording path error, got:", err)
+		}
+		if testProxy.Record != "" {
+			t.Fatal("Expected the rejected recording not to be started, got:", testProxy.Record)
+		}
+		err = testProxy.StartRecording(ctx, "mysql_master.jsonl")
+		if err != nil {
+			t.Fatal("Unable to start recording in the recording directory:", err)
+		}
+
+		err = testProxy.StopRecording(ctx)
+		if err != nil {
+			t.Fatal("Unable to stop recording:", err)
+		}
+		err = testProxy.StopRecording(ctx)
+		if err == nil || !strings.Contains(err.Error(), "recording not started") {
+			t.Fatal("Expected recording not started error, got:", err)
+		}
+	})
+}
+
 func AssertToxicExists(
 	t *testing.T,
 	toxics tclient.Toxics,
@@ -0,0 +1,87 @@
+package toxiproxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/badrootd/udpcrusher/capture"
+)
+
+// captureWriter records every datagram written through it while the proxy is
+// capturing.
+type captureWriter struct {
+	io.Writer
+	proxy *Proxy
+	iface capture.Interface
+	src   *net.UDPAddr
+	dst   *net.UDPAddr
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	if writer := w.proxy.capturer.Load(); writer != nil {
+		err := writer.WritePacket(w.iface, w.src, w.dst, p, time.Now())
+		if err != nil && err != capture.ErrClosed {
+			w.proxy.Logger.Warn().Err(err).Str("capture", w.proxy.Capture).Msg("Could not capture datagram")
+		}
+	}
+	return w.Writer.Write(p)
+}
+
+// StartCapture records every datagram of the proxy to a pcapng file at path,
+// as read on the ingress interface and as written on the egress interface.
+func (proxy *Proxy) StartCapture(path string) error {
+	proxy.Lock()
+	defer proxy.Unlock()
+
+	if proxy.Capture != "" {
+		return ErrCaptureAlreadyStarted
+	}
+	proxy.Capture = path
+	if proxy.Enabled {
+		if err := proxy.openCapture(); err != nil {
+			proxy.Capture = ""
+			return err
+		}
+	}
+	return nil
+}
+
+// StopCapture stops recording datagrams and closes the capture file.
+func (proxy *Proxy) StopCapture() error {
+	proxy.Lock()
+	defer proxy.Unlock()
+
+	if proxy.Capture == "" {
+		return ErrCaptureNotStarted
+	}
+	proxy.closeCapture()
+	proxy.Capture = ""
+	return nil
+}
+
+// openCapture opens the capture file, assumes the lock has already been taken.
+func (proxy *Proxy) openCapture() error {
+	writer, err := capture.Create(proxy.Capture)
+	if errors.Is(err, capture.ErrOutsideDir) {
+		return joinError(err, ErrInvalidCapturePath)
+	} else if err != nil {
+		return err
+	}
+	proxy.capturer.Store(writer)
+	proxy.Logger.Info().Str("capture", proxy.Capture).Msg("Started capture")
+	return nil
+}
+
+// closeCapture closes the capture file, if any, assumes the lock has already
+// been taken.
+func (proxy *Proxy) closeCapture() {
+	writer := proxy.capturer.Swap(nil)
+	if writer == nil {
+		return
+	}
+	if err := writer.Close(); err != nil {
+		proxy.Logger.Warn().Err(err).Str("capture", proxy.Capture).Msg("Could not close capture")
+	}
+}
@@ -0,0 +1,242 @@
+// Package capture writes proxied datagrams to pcapng files that can be opened
+// in Wireshark. The proxy only sees UDP payloads, so IP and UDP headers are
+// synthesized from the addresses of the flow.
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// An Interface is the capture interface a datagram is recorded on.
+type Interface uint32
+
+const (
+	// Datagrams as they are read by the proxy, before the toxics
+	Ingress Interface = iota
+	// Datagrams as they are written by the proxy, after the toxics
+	Egress
+)
+
+var interfaceNames = []string{"ingress", "egress"}
+
+const (
+	blockSectionHeader   = 0x0A0D0D0A
+	blockInterface       = 0x00000001
+	blockEnhancedPacket  = 0x00000006
+	byteOrderMagic       = 0x1A2B3C4D
+	linkTypeRaw          = 101 // Raw IPv4 or IPv6, told apart by the version
+	optionEnd            = 0
+	optionInterfaceName  = 2
+	optionTimeResolution = 9
+)
+
+var (
+	ErrClosed     = errors.New("capture is closed")
+	ErrOutsideDir = errors.New("outside of the capture directory")
+)
+
+// Dir restricts capture files to a directory, capture paths are relative to it
+// then. Empty allows any path on the server.
+var Dir string
+
+// A Writer records datagrams as pcapng. It is safe for concurrent use.
+type Writer struct {
+	mutex  sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// Create opens a pcapng file for writing, resolved against Dir. An existing
+// file is truncated, so a file always holds a single capture.
+func Create(path string) (*Writer, error) {
+	if Dir != "" {
+		if !filepath.IsLocal(path) {
+			return nil, fmt.Errorf("capture %s is %w", path, ErrOutsideDir)
+		}
+		path = filepath.Join(Dir, path)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := NewWriter(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	writer.closer = file
+	return writer, nil
+}
+
+// NewWriter writes the section header and interface descriptions to w.
+func NewWriter(w io.Writer) (*Writer, error) {
+	writer := &Writer{w: w}
+
+	// Byte order magic, version 1.0 and an unspecified section length
+	header := make([]byte, 16)
+	binary.LittleEndian.PutUint32(header, byteOrderMagic)
+	binary.LittleEndian.PutUint16(header[4:], 1)
+	binary.LittleEndian.PutUint16(header[6:], 0)
+	binary.LittleEndian.PutUint64(header[8:], ^uint64(0))
+	if err := writer.block(blockSectionHeader, header); err != nil {
+		return nil, err
+	}
+
+	for _, name := range interfaceNames {
+		// Link type, reserved and an unlimited snap length
+		description := make([]byte, 8)
+		binary.LittleEndian.PutUint16(description, linkTypeRaw)
+		description = appendOption(description, optionInterfaceName, []byte(name))
+		// Nanosecond timestamps
+		description = appendOption(description, optionTimeResolution, []byte{9})
+		description = appendOption(description, optionEnd, nil)
+		if err := writer.block(blockInterface, description); err != nil {
+			return nil, err
+		}
+	}
+	return writer, nil
+}
+
+// WritePacket records a datagram of the flow from src to dst.
+func (w *Writer) WritePacket(iface Interface, src, dst *net.UDPAddr, data []byte, timestamp time.Time) error {
+	packet := synthesize(src, dst, data)
+
+	body := make([]byte, 20, 20+len(packet)+3)
+	nanos := uint64(timestamp.UnixNano())
+	binary.LittleEndian.PutUint32(body, uint32(iface))
+	binary.LittleEndian.PutUint32(body[4:], uint32(nanos>>32))
+	binary.LittleEndian.PutUint32(body[8:], uint32(nanos))
+	binary.LittleEndian.PutUint32(body[12:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(body[16:], uint32(len(packet)))
+	body = append(body, packet...)
+	body = pad(body)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.w == nil {
+		return ErrClosed
+	}
+	return w.block(blockEnhancedPacket, body)
+}
+
+// Close stops recording and closes the file opened by Create.
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.w = nil
+	if w.closer != nil {
+		return w.closer.Close()
+	}
+	return nil
+}
+
+// block writes a block with the type and length framing the body, which must
+// be padded to 32 bits.
+func (w *Writer) block(blockType uint32, body []byte) error {
+	length := uint32(12 + len(body))
+	block := make([]byte, 0, length)
+	block = binary.LittleEndian.AppendUint32(block, blockType)
+	block = binary.LittleEndian.AppendUint32(block, length)
+	block = append(block, body...)
+	block = binary.LittleEndian.AppendUint32(block, length)
+	_, err := w.w.Write(block)
+	return err
+}
+
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	return pad(append(b, value...))
+}
+
+func pad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// synthesize prepends IP and UDP headers to the payload. The flow is IPv6 if
+// either address is, IPv4 addresses are mapped then.
+func synthesize(src, dst *net.UDPAddr, payload []byte) []byte {
+	srcIP, dstIP := address(src), address(dst)
+
+	udp := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(udp, uint16(port(src)))
+	binary.BigEndian.PutUint16(udp[2:], uint16(port(dst)))
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+len(payload)))
+	udp = append(udp, payload...)
+
+	if src4, dst4 := srcIP.To4(), dstIP.To4(); src4 != nil && dst4 != nil {
+		// The UDP checksum is optional over IPv4 and left 0
+		ip := make([]byte, 20, 20+len(udp))
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(udp)))
+		ip[8] = 64
+		ip[9] = 17
+		copy(ip[12:], src4)
+		copy(ip[16:], dst4)
+		binary.BigEndian.PutUint16(ip[10:], checksum(0, ip))
+		return append(ip, udp...)
+	}
+
+	ip := make([]byte, 40, 40+len(udp))
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:], uint16(len(udp)))
+	ip[6] = 17
+	ip[7] = 64
+	copy(ip[8:], srcIP.To16())
+	copy(ip[24:], dstIP.To16())
+
+	// Pseudo header of addresses, length and next header
+	sum := sumWords(0, ip[8:40])
+	sum += uint32(len(udp)) + 17
+	udpSum := checksum(sum, udp)
+	if udpSum == 0 {
+		udpSum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:], udpSum)
+	return append(ip, udp...)
+}
+
+func address(addr *net.UDPAddr) net.IP {
+	if addr == nil || addr.IP == nil {
+		return net.IPv4zero
+	}
+	return addr.IP
+}
+
+func port(addr *net.UDPAddr) int {
+	if addr == nil {
+		return 0
+	}
+	return addr.Port
+}
+
+func sumWords(sum uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
+
+// checksum is the internet checksum of b continuing sum.
+func checksum(sum uint32, b []byte) uint16 {
+	sum = sumWords(sum, b)
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,184 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type block struct {
+	kind uint32
+	body []byte
+}
+
+func readBlocks(t *testing.T, data []byte) []block {
+	var blocks []block
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatalf("Truncated block: %x", data)
+		}
+		kind := binary.LittleEndian.Uint32(data)
+		length := binary.LittleEndian.Uint32(data[4:])
+		if length%4 != 0 || int(length) > len(data) {
+			t.Fatalf("Invalid block length %d", length)
+		}
+		if trailer := binary.LittleEndian.Uint32(data[length-4:]); trailer != length {
+			t.Fatalf("Block length %d doesn't match trailer %d", length, trailer)
+		}
+		blocks = append(blocks, block{kind, data[8 : length-4]})
+		data = data[length:]
+	}
+	return blocks
+}
+
+func TestWriterIPv4(t *testing.T) {
+	var buffer bytes.Buffer
+	writer, err := NewWriter(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+	upstream := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 53}
+	timestamp := time.Unix(1700000000, 123456789)
+	err = writer.WritePacket(Egress, client, upstream, []byte("hello"), timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := readBlocks(t, buffer.Bytes())
+	if len(blocks) != 4 {
+		t.Fatalf("Expected a section header, 2 interfaces and a packet, got %d blocks", len(blocks))
+	}
+	if blocks[0].kind != blockSectionHeader || binary.LittleEndian.Uint32(blocks[0].body) != byteOrderMagic {
+		t.Fatal("Missing section header")
+	}
+	for _, b := range blocks[1:3] {
+		if b.kind != blockInterface || binary.LittleEndian.Uint16(b.body) != linkTypeRaw {
+			t.Fatal("Expected raw IP interfaces, got", b)
+		}
+	}
+	if !bytes.Contains(blocks[2].body, []byte("egress")) {
+		t.Fatal("Second interface is not named egress")
+	}
+
+	packet := blocks[3]
+	if packet.kind != blockEnhancedPacket || binary.LittleEndian.Uint32(packet.body) != uint32(Egress) {
+		t.Fatal("Expected a packet on the egress interface, got", packet)
+	}
+	nanos := uint64(binary.LittleEndian.Uint32(packet.body[4:]))<<32 | uint64(binary.LittleEndian.Uint32(packet.body[8:]))
+	if nanos != uint64(timestamp.UnixNano()) {
+		t.Fatalf("Expected timestamp %d, got %d", timestamp.UnixNano(), nanos)
+	}
+	length := binary.LittleEndian.Uint32(packet.body[12:])
+	ip := packet.body[20 : 20+length]
+	if length != 20+8+5 || ip[0] != 0x45 || ip[9] != 17 {
+		t.Fatalf("Invalid IPv4 header: %x", ip)
+	}
+	if checksum(0, ip[:20]) != 0 {
+		t.Fatal("Invalid IPv4 header checksum")
+	}
+	if !net.IP(ip[12:16]).Equal(client.IP) || !net.IP(ip[16:20]).Equal(upstream.IP) {
+		t.Fatalf("Invalid addresses: %x", ip[12:20])
+	}
+	udp := ip[20:]
+	if binary.BigEndian.Uint16(udp) != 40000 || binary.BigEndian.Uint16(udp[2:]) != 53 ||
+		binary.BigEndian.Uint16(udp[4:]) != 13 || string(udp[8:]) != "hello" {
+		t.Fatalf("Invalid UDP datagram: %x", udp)
+	}
+}
+
+func TestWriterIPv6(t *testing.T) {
+	var buffer bytes.Buffer
+	writer, err := NewWriter(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A mixed flow is recorded as IPv6
+	client := &net.UDPAddr{IP: net.ParseIP("::1"), Port: 40000}
+	upstream := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 53}
+	err = writer.WritePacket(Ingress, client, upstream, []byte("hello"), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet := readBlocks(t, buffer.Bytes())[3]
+	length := binary.LittleEndian.Uint32(packet.body[12:])
+	ip := packet.body[20 : 20+length]
+	if length != 40+8+5 || ip[0]>>4 != 6 || ip[6] != 17 {
+		t.Fatalf("Invalid IPv6 header: %x", ip)
+	}
+	sum := sumWords(0, ip[8:40]) + uint32(len(ip)-40) + 17
+	if checksum(sum, ip[40:]) != 0 {
+		t.Fatal("Invalid UDP checksum")
+	}
+}
+
+func TestWriterClosed(t *testing.T) {
+	writer, err := NewWriter(new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+
+	err = writer.WritePacket(Ingress, nil, nil, []byte("hello"), time.Now())
+	if err != ErrClosed {
+		t.Fatal("Expected ErrClosed, got", err)
+	}
+}
+
+func TestCreateTruncates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.pcapng")
+	for i := 0; i < 2; i++ {
+		writer, err := Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = writer.WritePacket(Ingress, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1},
+			&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2}, []byte("hello"), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.Close()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var headers int
+	for _, block := range readBlocks(t, data) {
+		if block.kind == blockSectionHeader {
+			headers++
+		}
+	}
+	if headers != 1 {
+		t.Fatalf("Expected a single section header, got %d", headers)
+	}
+}
+
+func TestCreateDir(t *testing.T) {
+	Dir = t.TempDir()
+	defer func() { Dir = "" }()
+
+	writer, err := Create("test.pcapng")
+	if err != nil {
+		t.Fatal("Failed to create capture in the capture directory:", err)
+	}
+	writer.Close()
+	if _, err := os.Stat(filepath.Join(Dir, "test.pcapng")); err != nil {
+		t.Fatal("Capture was not created in the capture directory:", err)
+	}
+
+	for _, path := range []string{"../test.pcapng", filepath.Join(Dir, "test.pcapng")} {
+		if _, err := Create(path); !errors.Is(err, ErrOutsideDir) {
+			t.Errorf("Expected capture %s outside of the capture directory to be rejected, got %v", path, err)
+		}
+	}
+}
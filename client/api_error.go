@@ -0,0 +1,15 @@
+package toxiproxy
+
+import (
+	"fmt"
+)
+
+// ApiError is the error returned by the udp-crusher API for any non-2xx response.
+type ApiError struct {
+	Message string `json:"error"`
+	Status  int    `json:"status"`
+}
+
+func (err *ApiError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", err.Status, err.Message)
+}
@@ -0,0 +1,306 @@
+// Package toxiproxy provides a client wrapper around the udp-crusher HTTP API
+// for testing the resiliency of Go applications talking over UDP.
+package toxiproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client holds information about where to connect to udp-crusher.
+type Client struct {
+	UserAgent string
+	endpoint  string
+	http      *http.Client
+}
+
+// NewClient creates a new client which provides the base of all communication
+// with udp-crusher. Endpoint is the address to the API (e.g. localhost:8474 if
+// not overridden).
+func NewClient(endpoint string) *Client {
+	if !strings.HasPrefix(endpoint, "https://") &&
+		!strings.HasPrefix(endpoint, "http://") {
+		endpoint = "http://" + endpoint
+	}
+
+	http := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+
+	return &Client{
+		UserAgent: "toxiproxy-cli",
+		endpoint:  endpoint,
+		http:      http,
+	}
+}
+
+// Version returns the running udp-crusher version.
+func (client *Client) Version(ctx context.Context) ([]byte, error) {
+	return client.get(ctx, "/version")
+}
+
+// Proxies returns a map with all the proxies and their toxics.
+func (client *Client) Proxies(ctx context.Context) (map[string]*Proxy, error) {
+	resp, err := client.get(ctx, "/proxies")
+	if err != nil {
+		return nil, err
+	}
+
+	proxies := make(map[string]*Proxy)
+	err = json.Unmarshal(resp, &proxies)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, proxy := range proxies {
+		proxy.client = client
+		proxy.created = true
+	}
+
+	return proxies, nil
+}
+
+// NewProxy generates a new uncommitted proxy instance. In order to use the
+// result, the proxy fields will need to be set and have `Save()` called.
+func (client *Client) NewProxy() *Proxy {
+	return &Proxy{
+		client: client,
+	}
+}
+
+// CreateProxy instantiates a new proxy and starts listening on the specified address.
+// This is an alias for `NewProxy()` + `proxy.Save()`.
+func (client *Client) CreateProxy(
+	ctx context.Context,
+	name, listen, upstream string,
+) (*Proxy, error) {
+	proxy := &Proxy{
+		Name:     name,
+		Listen:   listen,
+		Upstream: upstream,
+		Enabled:  true,
+		client:   client,
+	}
+
+	err := proxy.Save(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Create: %w", err)
+	}
+
+	return proxy, nil
+}
+
+// Proxy returns a proxy by name.
+func (client *Client) Proxy(ctx context.Context, name string) (*Proxy, error) {
+	resp, err := client.get(ctx, "/proxies/"+name)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := new(Proxy)
+	err = json.Unmarshal(resp, &proxy)
+	if err != nil {
+		return nil, err
+	}
+	proxy.client = client
+	proxy.created = true
+
+	return proxy, nil
+}
+
+// Populate creates a list of proxies using a configuration list. If a proxy
+// already exists, it will be replaced with the specified configuration.
+// For large amounts of proxies, `config` can be loaded from a file.
+// Returns a list of the successfully created proxies.
+func (client *Client) Populate(ctx context.Context, config []Proxy) ([]*Proxy, error) {
+	proxies := struct {
+		Proxies []*Proxy `json:"proxies"`
+	}{}
+	request, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.post(ctx, "/populate", bytes.NewReader(request))
+	if err != nil {
+		return nil, fmt.Errorf("Populate: %w", err)
+	}
+
+	err = json.Unmarshal(resp, &proxies)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, proxy := range proxies.Proxies {
+		proxy.client = client
+		proxy.created = true
+	}
+
+	return proxies.Proxies, err
+}
+
+// AddToxic creates a toxic on the proxy named in options.
+func (client *Client) AddToxic(ctx context.Context, options *ToxicOptions) (*Toxic, error) {
+	proxy, err := client.Proxy(ctx, options.ProxyName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve proxy with name `%s`: %v", options.ProxyName, err)
+	}
+
+	toxic, err := proxy.addToxic(ctx, &Toxic{
+		Name:         options.ToxicName,
+		Type:         options.ToxicType,
+		Stream:       options.Stream,
+		Toxicity:     options.Toxicity,
+		ToxicityMode: options.ToxicityMode,
+		Attributes:   options.Attributes,
+		Profile:      options.Profile,
+		Selector:     options.Selector,
+		Match:        options.Match,
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to add toxic to proxy %s: %v", options.ProxyName, err)
+	}
+
+	return toxic, nil
+}
+
+// UpdateToxic updates a toxic on the proxy named in options. A Toxicity of -1
+// and an empty ToxicityMode keep the current values. A given Profile, Selector
+// or Match replaces the current one, RemoveProfile, RemoveSelector and
+// RemoveMatch remove them.
+func (client *Client) UpdateToxic(ctx context.Context, options *ToxicOptions) (*Toxic, error) {
+	proxy, err := client.Proxy(ctx, options.ProxyName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve proxy with name `%s`: %v", options.ProxyName, err)
+	}
+
+	toxic := map[string]interface{}{
+		"attributes": options.Attributes,
+	}
+	if options.Toxicity != -1 {
+		toxic["toxicity"] = options.Toxicity
+	}
+	if options.ToxicityMode != "" {
+		toxic["toxicity_mode"] = options.ToxicityMode
+	}
+	if options.Profile != nil {
+		toxic["profile"] = options.Profile
+	} else if options.RemoveProfile {
+		toxic["profile"] = nil
+	}
+	if options.Selector != nil {
+		toxic["selector"] = options.Selector
+	} else if options.RemoveSelector {
+		toxic["selector"] = nil
+	}
+	if options.Match != nil {
+		toxic["match"] = options.Match
+	} else if options.RemoveMatch {
+		toxic["match"] = nil
+	}
+	result, err := proxy.updateToxic(ctx, options.ToxicName, toxic)
+
+	if err != nil {
+		return nil,
+			fmt.Errorf(
+				"failed to update toxic '%s' of proxy '%s': %v",
+				options.ToxicName, options.ProxyName, err,
+			)
+	}
+
+	return result, nil
+}
+
+// RemoveToxic removes a toxic from the proxy named in options.
+func (client *Client) RemoveToxic(ctx context.Context, options *ToxicOptions) error {
+	proxy, err := client.Proxy(ctx, options.ProxyName)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve proxy with name `%s`: %v", options.ProxyName, err)
+	}
+
+	err = proxy.RemoveToxic(ctx, options.ToxicName)
+	if err != nil {
+		return fmt.Errorf(
+			"failed to remove toxic '%s' from proxy '%s': %v",
+			options.ToxicName, options.ProxyName, err,
+		)
+	}
+
+	return nil
+}
+
+// ResetState re-enables all proxies and removes all their toxics.
+func (client *Client) ResetState(ctx context.Context) error {
+	_, err := client.post(ctx, "/reset", bytes.NewReader([]byte{}))
+	return err
+}
+
+func (c *Client) get(ctx context.Context, path string) ([]byte, error) {
+	return c.send(ctx, "GET", path, nil)
+}
+
+func (c *Client) post(ctx context.Context, path string, body io.Reader) ([]byte, error) {
+	return c.send(ctx, "POST", path, body)
+}
+
+func (c *Client) patch(ctx context.Context, path string, body io.Reader) ([]byte, error) {
+	return c.send(ctx, "PATCH", path, body)
+}
+
+func (c *Client) delete(ctx context.Context, path string) error {
+	_, err := c.send(ctx, "DELETE", path, nil)
+	return err
+}
+
+func (c *Client) send(ctx context.Context, verb, path string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, verb, c.endpoint+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", c.UserAgent)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fail to request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	err = c.validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// validateResponse decodes the ApiError of a non-2xx response.
+func (c *Client) validateResponse(resp *http.Response) error {
+	if resp.StatusCode < 300 && resp.StatusCode >= 200 {
+		return nil
+	}
+
+	apiError := new(ApiError)
+	err := json.NewDecoder(resp.Body).Decode(apiError)
+	if err != nil || apiError.Message == "" {
+		apiError.Message = fmt.Sprintf(
+			"Unexpected response code %d",
+			resp.StatusCode,
+		)
+	}
+	apiError.Status = resp.StatusCode
+	return apiError
+}
@@ -0,0 +1,265 @@
+package toxiproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+type Proxy struct {
+	Name     string `json:"name"`     // The name of the proxy
+	Listen   string `json:"listen"`   // The address the proxy listens on
+	Upstream string `json:"upstream"` // The upstream address to proxy to
+	Enabled  bool   `json:"enabled"`  // Whether the proxy is enabled
+
+	// Further upstreams besides Upstream, which is the primary one
+	Upstreams []string `json:"upstreams"`
+	// How client sessions are spread over the upstreams, one of round_robin,
+	// hash, failover or mirror
+	Policy string `json:"policy"`
+
+	// Time in milliseconds after which an inactive client session is evicted
+	IdleTimeout int64 `json:"idle_timeout"`
+	// Maximum number of client sessions, 0 means unlimited
+	MaxSessions int `json:"max_sessions"`
+	// Seed for the random decisions of the toxics, 0 picks a random seed
+	Seed int64 `json:"seed"`
+	// Whether the upstream sees the original client addresses
+	Transparent bool `json:"transparent"`
+	// Demultiplexer routing the replies when all clients share one upstream
+	// socket, one of dns, quic or aeron
+	Demultiplexer string `json:"demultiplexer"`
+	// Interface multicast groups are joined and published on
+	MulticastInterface string `json:"multicast_interface"`
+	// Path of the pcapng file on the server the datagrams are recorded to
+	Capture string `json:"capture,omitempty"`
+	// Path of the file on the server the client sessions are recorded to
+	Record string `json:"record,omitempty"`
+
+	// The toxics active on this proxy. Note: you cannot set this
+	// when passing Proxy into Populate()
+	ActiveToxics Toxics `json:"toxics"`
+
+	client  *Client
+	created bool // True if this proxy exists on the server
+}
+
+// Save saves changes to a proxy such as its enabled status or upstream port.
+func (proxy *Proxy) Save(ctx context.Context) error {
+	request, err := json.Marshal(proxy)
+	if err != nil {
+		return err
+	}
+	data := bytes.NewReader(request)
+
+	var resp []byte
+	if proxy.created {
+		resp, err = proxy.client.patch(ctx, "/proxies/"+proxy.Name, data)
+	} else {
+		resp, err = proxy.client.post(ctx, "/proxies", data)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(resp, proxy)
+	if err != nil {
+		return err
+	}
+
+	proxy.created = true
+
+	return nil
+}
+
+// Enable a proxy again after it has been disabled.
+func (proxy *Proxy) Enable(ctx context.Context) error {
+	proxy.Enabled = true
+	return proxy.Save(ctx)
+}
+
+// Disable a proxy so that no datagrams can pass through. This will drop all
+// client sessions.
+func (proxy *Proxy) Disable(ctx context.Context) error {
+	proxy.Enabled = false
+	return proxy.Save(ctx)
+}
+
+// Delete a proxy completely and close all its client sessions. All information
+// about the proxy such as listen port and active toxics will be deleted as well.
+// If you just wish to stop and later enable a proxy, use `Enable()` and
+// `Disable()`.
+func (proxy *Proxy) Delete(ctx context.Context) error {
+	err := proxy.client.delete(ctx, "/proxies/"+proxy.Name)
+	if err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+	return nil
+}
+
+// StartCapture records every datagram of the proxy to a pcapng file at path on
+// the server, before the toxics on the ingress and after them on the egress
+// interface.
+func (proxy *Proxy) StartCapture(ctx context.Context, path string) error {
+	request, err := json.Marshal(map[string]string{"path": path})
+	if err != nil {
+		return err
+	}
+
+	resp, err := proxy.client.post(ctx, "/proxies/"+proxy.Name+"/capture", bytes.NewReader(request))
+	if err != nil {
+		return fmt.Errorf("StartCapture: %w", err)
+	}
+	return json.Unmarshal(resp, proxy)
+}
+
+// StopCapture stops recording datagrams and closes the capture file.
+func (proxy *Proxy) StopCapture(ctx context.Context) error {
+	err := proxy.client.delete(ctx, "/proxies/"+proxy.Name+"/capture")
+	if err != nil {
+		return fmt.Errorf("StopCapture: %w", err)
+	}
+	proxy.Capture = ""
+	return nil
+}
+
+// StartRecording records the datagrams of every client session to a file at
+// path on the server, as they are read by the proxy before the toxics.
+func (proxy *Proxy) StartRecording(ctx context.Context, path string) error {
+	request, err := json.Marshal(map[string]string{"path": path})
+	if err != nil {
+		return err
+	}
+
+	resp, err := proxy.client.post(ctx, "/proxies/"+proxy.Name+"/record", bytes.NewReader(request))
+	if err != nil {
+		return fmt.Errorf("StartRecording: %w", err)
+	}
+	return json.Unmarshal(resp, proxy)
+}
+
+// StopRecording stops recording datagrams and closes the recording file.
+func (proxy *Proxy) StopRecording(ctx context.Context) error {
+	err := proxy.client.delete(ctx, "/proxies/"+proxy.Name+"/record")
+	if err != nil {
+		return fmt.Errorf("StopRecording: %w", err)
+	}
+	proxy.Record = ""
+	return nil
+}
+
+// Toxics returns all the active toxics and their attributes.
+func (proxy *Proxy) Toxics(ctx context.Context) (Toxics, error) {
+	resp, err := proxy.client.get(ctx, "/proxies/"+proxy.Name+"/toxics")
+	if err != nil {
+		return nil, err
+	}
+
+	toxics := make(Toxics, 0)
+	err = json.Unmarshal(resp, &toxics)
+	if err != nil {
+		return nil, err
+	}
+
+	return toxics, nil
+}
+
+// AddToxic adds a toxic to the given stream direction.
+// If a name is not specified, it will default to <type>_<stream>.
+// If a stream is not specified, it will default to downstream.
+// If toxicity is set to -1, the default toxicity of 1 will be used.
+func (proxy *Proxy) AddToxic(
+	ctx context.Context,
+	name, typeName, stream string,
+	toxicity float32,
+	attrs Attributes,
+) (*Toxic, error) {
+	return proxy.addToxic(ctx, &Toxic{
+		Name:       name,
+		Type:       typeName,
+		Stream:     stream,
+		Toxicity:   toxicity,
+		Attributes: attrs,
+	})
+}
+
+func (proxy *Proxy) addToxic(ctx context.Context, toxic *Toxic) (*Toxic, error) {
+	if toxic.Toxicity == -1 {
+		toxic.Toxicity = 1
+	}
+
+	request, err := json.Marshal(toxic)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := proxy.client.post(
+		ctx,
+		"/proxies/"+proxy.Name+"/toxics",
+		bytes.NewReader(request),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("AddToxic: %w", err)
+	}
+
+	result := &Toxic{}
+	err = json.Unmarshal(resp, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// UpdateToxic sets the parameters for an existing toxic with the given name.
+// If toxicity is set to -1, the current value will be used.
+func (proxy *Proxy) UpdateToxic(
+	ctx context.Context,
+	name string,
+	toxicity float32,
+	attrs Attributes,
+) (*Toxic, error) {
+	toxic := map[string]interface{}{
+		"attributes": attrs,
+	}
+	if toxicity != -1 {
+		toxic["toxicity"] = toxicity
+	}
+	return proxy.updateToxic(ctx, name, toxic)
+}
+
+// updateToxic sends the fields of the toxic to change, fields that are left
+// out keep their current value.
+func (proxy *Proxy) updateToxic(
+	ctx context.Context,
+	name string,
+	toxic map[string]interface{},
+) (*Toxic, error) {
+	request, err := json.Marshal(&toxic)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := proxy.client.patch(
+		ctx,
+		"/proxies/"+proxy.Name+"/toxics/"+name,
+		bytes.NewReader(request),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Toxic{}
+	err = json.Unmarshal(resp, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// RemoveToxic removes the toxic with the given name.
+func (proxy *Proxy) RemoveToxic(ctx context.Context, name string) error {
+	return proxy.client.delete(ctx, "/proxies/"+proxy.Name+"/toxics/"+name)
+}
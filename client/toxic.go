@@ -0,0 +1,82 @@
+package toxiproxy
+
+// Attributes are the toxic type specific settings, e.g. latency and jitter for
+// a latency toxic.
+type Attributes map[string]interface{}
+
+type Toxic struct {
+	Name     string  `json:"name"`
+	Type     string  `json:"type"`
+	Stream   string  `json:"stream,omitempty"`
+	Toxicity float32 `json:"toxicity"`
+	// Whether toxicity applies per datagram ("packet", the default) or per
+	// client session ("link")
+	ToxicityMode string     `json:"toxicity_mode,omitempty"`
+	Attributes   Attributes `json:"attributes"`
+	// Changes the attributes over time
+	Profile *Profile `json:"profile,omitempty"`
+	// Scopes the toxic to some clients of the proxy
+	Selector *Selector `json:"selector,omitempty"`
+	// Restricts the toxic to matching datagrams
+	Match *Match `json:"match,omitempty"`
+}
+
+// A Selector scopes a toxic to the clients matching all of its set fields: a
+// source IP or CIDR, a source port or port range like "40000-40100", and the
+// session name, which is the client address as ip:port.
+type Selector struct {
+	Source  string `json:"source,omitempty"`
+	Ports   string `json:"ports,omitempty"`
+	Session string `json:"session,omitempty"`
+}
+
+// A Profile changes numeric attributes of a toxic over time, either linearly
+// between points ("ramp") or holding each point until the next ("step"). The
+// points can be loaded from a CSV trace file on the server instead.
+type Profile struct {
+	Mode     string         `json:"mode,omitempty"`
+	Points   []ProfilePoint `json:"points,omitempty"`
+	Trace    string         `json:"trace,omitempty"`
+	Interval int64          `json:"interval,omitempty"`
+	Loop     bool           `json:"loop,omitempty"`
+}
+
+// A ProfilePoint sets attributes at milliseconds since the toxic was added.
+type ProfilePoint struct {
+	Time       int64              `json:"time"`
+	Attributes map[string]float64 `json:"attributes"`
+}
+
+// A Match restricts a toxic to the datagrams matching all of its set
+// conditions: hex Bytes at Offset, compared after ANDing the payload with the
+// hex Mask, a payload length range and a regular expression over the payload.
+type Match struct {
+	Bytes     string `json:"bytes,omitempty"`
+	Mask      string `json:"mask,omitempty"`
+	Offset    int    `json:"offset,omitempty"`
+	MinLength int    `json:"min_length,omitempty"`
+	MaxLength int    `json:"max_length,omitempty"`
+	Regex     string `json:"regex,omitempty"`
+}
+
+type Toxics []Toxic
+
+type ToxicOptions struct {
+	ProxyName,
+	ToxicName,
+	ToxicType,
+	Stream,
+	ToxicityMode string
+	Toxicity   float32
+	Attributes Attributes
+	Profile    *Profile
+	Selector   *Selector
+	Match      *Match
+
+	// Remove the profile of a toxic when updating it, a nil Profile keeps it
+	RemoveProfile bool
+	// Remove the selector of a toxic when updating it, a nil Selector keeps it
+	RemoveSelector bool
+	// Remove the match of a toxic when updating it, a nil Match keeps it
+	RemoveMatch bool
+}
@@ -11,8 +11,8 @@ import (
 	"github.com/urfave/cli/v2"
 	terminal "golang.org/x/term"
 
-	toxiproxyServer "github.com/Shopify/toxiproxy/v2"
-	toxiproxy "github.com/Shopify/toxiproxy/v2/client"
+	toxiproxyServer "github.com/badrootd/udpcrusher"
+	toxiproxy "github.com/badrootd/udpcrusher/client"
 )
 
 const (
@@ -34,12 +34,18 @@ func color(color string) string {
 
 var toxicDescription = `
   Default Toxics:
-  latency:    delay all data +/- jitter
-              latency=<ms>,jitter=<ms>
+  latency:    delay every datagram independently by latency +/- jitter, optionally reordering them
+              latency=<ms>,jitter=<ms>,reorder=<bool>,limit=<count>,seed=<int>
 
   bandwidth:  limit to max kb/s
               rate=<KB/s>
 
+  token_bucket:
+              shape like a bottleneck link with a bounded queue, tail-drop and optional RED
+              rate=<KB/s>,burst=<bytes>,packet_rate=<pps>,packet_burst=<count>,
+              queue_packets=<count>,queue_bytes=<bytes>,
+              red_min=<float>,red_max=<float>,red_probability=<float>,seed=<int>
+
   slow_close: delay from closing
               delay=<ms>
 
@@ -51,11 +57,57 @@ var toxicDescription = `
               timeout=<ms>
 
   slicer:     slice data into bits with optional delay
-              average_size=<bytes>,size_variation=<bytes>,delay=<microseconds>
+              average_size=<bytes>,size_variation=<bytes>,delay=<microseconds>,seed=<int>
+
+  loss:       drop individual datagrams using a uniform, burst or periodic loss model
+              model=uniform,probability=<float>,seed=<int>
+              model=gilbert_elliott,good_to_bad=<float>,bad_to_good=<float>,
+                good_loss=<float>,bad_loss=<float>,seed=<int>
+              model=nth,nth=<count>
+
+  duplicate:  send extra copies of datagrams with optional delay between copies
+              probability=<float>,copies=<count>,delay=<ms>,seed=<int>
+
+  reorder:    hold back datagrams until gap subsequent datagrams passed or max_hold expired
+              probability=<float>,gap=<count>,max_hold=<ms>,seed=<int>
+
+  corrupt:    flip random bits, overwrite random bytes or zero a byte range of datagrams
+              mode=<bit_flip|overwrite>,probability=<float>,count=<count>,seed=<int>
+              mode=zero,probability=<float>,offset=<bytes>,length=<bytes>,seed=<int>
+
+  mtu:        drop or truncate datagrams larger than the MTU, counting them as exceeded
+              mtu=<bytes>,action=<drop|truncate>
+
+  rebind:     move client sessions to a new source port towards the upstream, like a NAT rebinding
+              interval=<ms>,trigger=<int>
+
+  blackhole:  silently drop all datagrams, always or for down ms of every period with random jitter
+              period=<ms>,down=<ms>,jitter=<ms>,seed=<int>
+
+  dns_drop:   drop DNS messages whose question matches qname and qtype, *.<name> matches subdomains
+              qname=<name>,qtype=<type>
+
+  dns_rcode:  answer matching DNS responses with an error code and no records
+              qname=<name>,qtype=<type>,rcode=<SERVFAIL|NXDOMAIN|REFUSED>
+
+  dns_truncate:
+              set the TC bit on matching DNS responses, removing all records or those beyond size
+              qname=<name>,qtype=<type>,size=<bytes>
+
+  dns_rewrite:
+              rewrite the A or AAAA addresses and TTLs of the answers of matching DNS responses
+              qname=<name>,qtype=<type>,ip=<ip>,ttl=<seconds>
+
+  dns_swap_id:
+              swap the transaction IDs of consecutive matching DNS responses, or randomize them
+              qname=<name>,qtype=<type>,mode=<swap|random>,wait=<ms>,seed=<int>
 
   toxic add:
     usage: toxiproxy-cli toxic add --type <toxicType> [--downstream|--upstream] \
-            --toxicName <toxicName> [--toxicity <float>] \
+            --toxicName <toxicName> [--toxicity <float>] [--toxicity-mode <packet|link>] \
+            [--source <ip|cidr>] [--ports <port|low-high>] [--session <ip:port>] \
+            [--match-bytes <hex> [--match-mask <hex>] [--match-offset <int>]] \
+            [--match-min-length <int>] [--match-max-length <int>] [--match-regex <regex>] \
             --attribute <key=value> [--attribute <key2=value2>] <proxyName>
 
 
@@ -63,7 +115,7 @@ var toxicDescription = `
 
   toxic update:
     usage: toxiproxy-cli toxic update --toxicName <toxicName> [--toxicity <float>] \
-            --attribute <key1=value1> [--attribute <key2=value2>] <proxyName>
+            [--toxicity-mode <packet|link>] --attribute <key1=value1> [--attribute <key2=value2>] <proxyName>
 
     example: toxiproxy-cli toxic update -n myToxic -a jitter=25 myProxy
 
@@ -134,6 +186,38 @@ func cliCommands() []*cli.Command {
 					Aliases: []string{"u"},
 					Usage:   "proxy will forward to this address",
 				},
+				&cli.StringSliceFlag{
+					Name:  "upstreams",
+					Usage: "further upstreams besides the primary --upstream",
+				},
+				&cli.StringFlag{
+					Name:  "policy",
+					Usage: "spread client sessions over the upstreams by round_robin, hash, failover or mirror",
+				},
+				&cli.Int64Flag{
+					Name:  "idle-timeout",
+					Usage: "evict client sessions idle for this many milliseconds",
+				},
+				&cli.IntFlag{
+					Name:  "max-sessions",
+					Usage: "maximum number of client sessions, evicting the least recently used",
+				},
+				&cli.Int64Flag{
+					Name:  "seed",
+					Usage: "seed for the random decisions of the toxics",
+				},
+				&cli.BoolFlag{
+					Name:  "transparent",
+					Usage: "preserve client addresses towards the upstream (Linux, needs CAP_NET_ADMIN)",
+				},
+				&cli.StringFlag{
+					Name:  "demultiplexer",
+					Usage: "share one upstream socket between clients, routing replies by dns, quic or aeron",
+				},
+				&cli.StringFlag{
+					Name:  "multicast-interface",
+					Usage: "interface to join a multicast listen group and publish to a multicast upstream on",
+				},
 			},
 			Action: withToxi(createProxy),
 		},
@@ -150,6 +234,40 @@ func cliCommands() []*cli.Command {
 			Aliases: []string{"d"},
 			Action:  withToxi(deleteProxy),
 		},
+		{
+			Name: "capture",
+			Usage: "\tstart or stop recording the datagrams of a proxy to a pcapng file\n" +
+				"\t\tusage: 'toxiproxy-cli capture --path <file> <proxyName>'\n" +
+				"\t\t       'toxiproxy-cli capture --stop <proxyName>'\n",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "path",
+					Usage: "pcapng file on the server to record to",
+				},
+				&cli.BoolFlag{
+					Name:  "stop",
+					Usage: "stop recording",
+				},
+			},
+			Action: withToxi(captureProxy),
+		},
+		{
+			Name: "record",
+			Usage: "\tstart or stop recording the client sessions of a proxy for replaying them\n" +
+				"\t\tusage: 'toxiproxy-cli record --path <file> <proxyName>'\n" +
+				"\t\t       'toxiproxy-cli record --stop <proxyName>'\n",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "path",
+					Usage: "recording file on the server to record to",
+				},
+				&cli.BoolFlag{
+					Name:  "stop",
+					Usage: "stop recording",
+				},
+			},
+			Action: withToxi(recordProxy),
+		},
 		{
 			Name:        "toxic",
 			Aliases:     []string{"t"},
@@ -191,6 +309,11 @@ func cliToxiAddSubCommand() *cli.Command {
 				Usage:       "toxicity of toxic should be a float between 0 and 1",
 				DefaultText: "1.0",
 			},
+			&cli.StringFlag{
+				Name:        "toxicity-mode",
+				Usage:       "apply toxicity per datagram (packet) or per client session (link)",
+				DefaultText: "packet",
+			},
 			&cli.StringSliceFlag{
 				Name:    "attribute",
 				Aliases: []string{"a"},
@@ -208,6 +331,42 @@ func cliToxiAddSubCommand() *cli.Command {
 				Usage:       "add toxic to downstream",
 				DefaultText: "true",
 			},
+			&cli.StringFlag{
+				Name:  "source",
+				Usage: "only apply the toxic to clients with this IP or CIDR",
+			},
+			&cli.StringFlag{
+				Name:  "ports",
+				Usage: "only apply the toxic to clients with a source port in this range, e.g. 40000-40100",
+			},
+			&cli.StringFlag{
+				Name:  "session",
+				Usage: "only apply the toxic to the client session with this ip:port",
+			},
+			&cli.StringFlag{
+				Name:  "match-bytes",
+				Usage: "only apply the toxic to datagrams with these hex bytes at --match-offset",
+			},
+			&cli.StringFlag{
+				Name:  "match-mask",
+				Usage: "hex mask ANDed with the payload before comparing it with --match-bytes",
+			},
+			&cli.IntFlag{
+				Name:  "match-offset",
+				Usage: "offset of --match-bytes into the payload, negative counts from the end",
+			},
+			&cli.IntFlag{
+				Name:  "match-min-length",
+				Usage: "only apply the toxic to datagrams of at least this many bytes",
+			},
+			&cli.IntFlag{
+				Name:  "match-max-length",
+				Usage: "only apply the toxic to datagrams of at most this many bytes",
+			},
+			&cli.StringFlag{
+				Name:  "match-regex",
+				Usage: "only apply the toxic to datagrams matching this regular expression",
+			},
 		},
 		Action: withToxi(addToxic),
 	}
@@ -231,6 +390,10 @@ func cliToxiUpdateSubCommand() *cli.Command {
 				Usage:       "toxicity of toxic should be a float between 0 and 1",
 				DefaultText: "1.0",
 			},
+			&cli.StringFlag{
+				Name:  "toxicity-mode",
+				Usage: "apply toxicity per datagram (packet) or per client session (link), empty keeps it",
+			},
 			&cli.StringSliceFlag{
 				Name:    "attribute",
 				Aliases: []string{"a"},
@@ -274,7 +437,7 @@ func withToxi(f toxiAction) func(*cli.Context) error {
 }
 
 func list(c *cli.Context, t *toxiproxy.Client) error {
-	proxies, err := t.Proxies()
+	proxies, err := t.Proxies(c.Context)
 	if err != nil {
 		return errorf("Failed to retrieve proxies: %s", err)
 	}
@@ -330,7 +493,7 @@ func inspectProxy(c *cli.Context, t *toxiproxy.Client) error {
 		return errorf("Proxy name is required as the first argument.\n")
 	}
 
-	proxy, err := t.Proxy(proxyName)
+	proxy, err := t.Proxy(c.Context, proxyName)
 	if err != nil {
 		return errorf("Failed to retrieve proxy %s: %s\n", proxyName, err.Error())
 	}
@@ -380,14 +543,14 @@ func toggleProxy(c *cli.Context, t *toxiproxy.Client) error {
 		return errorf("Proxy name is required as the first argument.\n")
 	}
 
-	proxy, err := t.Proxy(proxyName)
+	proxy, err := t.Proxy(c.Context, proxyName)
 	if err != nil {
 		return errorf("Failed to retrieve proxy %s: %s\n", proxyName, err.Error())
 	}
 
 	proxy.Enabled = !proxy.Enabled
 
-	err = proxy.Save()
+	err = proxy.Save(c.Context)
 	if err != nil {
 		return errorf("Failed to toggle proxy %s: %s\n", proxyName, err.Error())
 	}
@@ -418,7 +581,20 @@ func createProxy(c *cli.Context, t *toxiproxy.Client) error {
 	if err != nil {
 		return err
 	}
-	_, err = t.CreateProxy(proxyName, listen, upstream)
+	proxy := t.NewProxy()
+	proxy.Name = proxyName
+	proxy.Listen = listen
+	proxy.Upstream = upstream
+	proxy.Enabled = true
+	proxy.IdleTimeout = c.Int64("idle-timeout")
+	proxy.MaxSessions = c.Int("max-sessions")
+	proxy.Seed = c.Int64("seed")
+	proxy.Transparent = c.Bool("transparent")
+	proxy.Demultiplexer = c.String("demultiplexer")
+	proxy.MulticastInterface = c.String("multicast-interface")
+	proxy.Upstreams = c.StringSlice("upstreams")
+	proxy.Policy = c.String("policy")
+	err = proxy.Save(c.Context)
 	if err != nil {
 		return errorf("Failed to create proxy: %s\n", err.Error())
 	}
@@ -432,12 +608,12 @@ func deleteProxy(c *cli.Context, t *toxiproxy.Client) error {
 		cli.ShowSubcommandHelp(c)
 		return errorf("Proxy name is required as the first argument.\n")
 	}
-	p, err := t.Proxy(proxyName)
+	p, err := t.Proxy(c.Context, proxyName)
 	if err != nil {
 		return errorf("Failed to retrieve proxy %s: %s\n", proxyName, err.Error())
 	}
 
-	err = p.Delete()
+	err = p.Delete(c.Context)
 	if err != nil {
 		return errorf("Failed to delete proxy: %s\n", err.Error())
 	}
@@ -445,6 +621,70 @@ func deleteProxy(c *cli.Context, t *toxiproxy.Client) error {
 	return nil
 }
 
+func captureProxy(c *cli.Context, t *toxiproxy.Client) error {
+	proxyName := c.Args().First()
+	if proxyName == "" {
+		cli.ShowSubcommandHelp(c)
+		return errorf("Proxy name is required as the first argument.\n")
+	}
+	p, err := t.Proxy(c.Context, proxyName)
+	if err != nil {
+		return errorf("Failed to retrieve proxy %s: %s\n", proxyName, err.Error())
+	}
+
+	if c.Bool("stop") {
+		err = p.StopCapture(c.Context)
+		if err != nil {
+			return errorf("Failed to stop capture: %s\n", err.Error())
+		}
+		fmt.Printf("Stopped capture of proxy %s\n", proxyName)
+		return nil
+	}
+
+	path, err := getArgOrFail(c, "path")
+	if err != nil {
+		return err
+	}
+	err = p.StartCapture(c.Context, path)
+	if err != nil {
+		return errorf("Failed to start capture: %s\n", err.Error())
+	}
+	fmt.Printf("Capturing proxy %s to %s\n", proxyName, path)
+	return nil
+}
+
+func recordProxy(c *cli.Context, t *toxiproxy.Client) error {
+	proxyName := c.Args().First()
+	if proxyName == "" {
+		cli.ShowSubcommandHelp(c)
+		return errorf("Proxy name is required as the first argument.\n")
+	}
+	p, err := t.Proxy(c.Context, proxyName)
+	if err != nil {
+		return errorf("Failed to retrieve proxy %s: %s\n", proxyName, err.Error())
+	}
+
+	if c.Bool("stop") {
+		err = p.StopRecording(c.Context)
+		if err != nil {
+			return errorf("Failed to stop recording: %s\n", err.Error())
+		}
+		fmt.Printf("Stopped recording proxy %s\n", proxyName)
+		return nil
+	}
+
+	path, err := getArgOrFail(c, "path")
+	if err != nil {
+		return err
+	}
+	err = p.StartRecording(c.Context, path)
+	if err != nil {
+		return errorf("Failed to start recording: %s\n", err.Error())
+	}
+	fmt.Printf("Recording proxy %s to %s\n", proxyName, path)
+	return nil
+}
+
 func parseToxicity(c *cli.Context, defaultToxicity float32) (float32, error) {
 	toxicity := defaultToxicity
 	toxicityString := c.String("toxicity")
@@ -464,7 +704,7 @@ func addToxic(c *cli.Context, t *toxiproxy.Client) error {
 		return err
 	}
 
-	toxic, err := t.AddToxic(toxicParams)
+	toxic, err := t.AddToxic(c.Context, toxicParams)
 	if err != nil {
 		return errorf("Failed to add toxic: %v\n", err)
 	}
@@ -486,7 +726,7 @@ func updateToxic(c *cli.Context, t *toxiproxy.Client) error {
 		return err
 	}
 
-	toxic, err := t.UpdateToxic(toxicParams)
+	toxic, err := t.UpdateToxic(c.Context, toxicParams)
 	if err != nil {
 		return errorf("Failed to update toxic: %v\n", err)
 	}
@@ -505,7 +745,7 @@ func removeToxic(c *cli.Context, t *toxiproxy.Client) error {
 		return err
 	}
 
-	err = t.RemoveToxic(toxicParams)
+	err = t.RemoveToxic(c.Context, toxicParams)
 	if err != nil {
 		return errorf("Failed to remove toxic: %v\n", err)
 	}
@@ -539,6 +779,7 @@ func parseUpdateToxicParams(c *cli.Context) (*toxiproxy.ToxicOptions, error) {
 	if err != nil {
 		return nil, err
 	}
+	result.ToxicityMode = c.String("toxicity-mode")
 
 	result.Attributes = parseAttributes(c, "attribute")
 
@@ -572,9 +813,31 @@ func parseAddToxicParams(c *cli.Context) (*toxiproxy.ToxicOptions, error) {
 	if err != nil {
 		return nil, err
 	}
+	result.ToxicityMode = c.String("toxicity-mode")
 
 	result.Attributes = parseAttributes(c, "attribute")
 
+	selector := toxiproxy.Selector{
+		Source:  c.String("source"),
+		Ports:   c.String("ports"),
+		Session: c.String("session"),
+	}
+	if selector != (toxiproxy.Selector{}) {
+		result.Selector = &selector
+	}
+
+	match := toxiproxy.Match{
+		Bytes:     c.String("match-bytes"),
+		Mask:      c.String("match-mask"),
+		Offset:    c.Int("match-offset"),
+		MinLength: c.Int("match-min-length"),
+		MaxLength: c.Int("match-max-length"),
+		Regex:     c.String("match-regex"),
+	}
+	if match != (toxiproxy.Match{}) {
+		result.Match = &match
+	}
+
 	return result, nil
 }
 
@@ -589,6 +852,8 @@ func parseAttributes(c *cli.Context, name string) toxiproxy.Attributes {
 		}
 		if float, err := strconv.ParseFloat(kv[1], 64); err == nil {
 			parsed[kv[0]] = float
+		} else if kv[1] == "true" || kv[1] == "false" {
+			parsed[kv[0]] = kv[1] == "true"
 		} else {
 			parsed[kv[0]] = kv[1]
 		}
@@ -626,7 +891,7 @@ func (a attributeList) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func sortedAttributes(attrs toxiproxy.Attributes) attributeList {
 	li := make(attributeList, 0, len(attrs))
 	for k, v := range attrs {
-		li = append(li, attribute{k, v.(float64)})
+		li = append(li, attribute{k, v})
 	}
 	sort.Sort(li)
 	return li
@@ -649,6 +914,15 @@ func listToxics(toxics toxiproxy.Toxics, stream string) {
 		fmt.Printf("type=%s\t", t.Type)
 		fmt.Printf("stream=%s\t", t.Stream)
 		fmt.Printf("toxicity=%.2f\t", t.Toxicity)
+		if t.ToxicityMode != "" {
+			fmt.Printf("toxicity_mode=%s\t", t.ToxicityMode)
+		}
+		if t.Selector != nil {
+			fmt.Printf("selector=%+v\t", *t.Selector)
+		}
+		if t.Match != nil {
+			fmt.Printf("match=%+v\t", *t.Match)
+		}
 		fmt.Printf("attributes=[")
 		sorted := sortedAttributes(t.Attributes)
 		for _, a := range sorted {
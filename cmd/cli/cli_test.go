@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/urfave/cli/v2"
+
+	toxiproxy "github.com/badrootd/udpcrusher/client"
+)
+
+// parseToxicCommand runs a toxic subcommand with args and returns the options
+// it would send to the server.
+func parseToxicCommand(
+	t *testing.T,
+	command *cli.Command,
+	parse func(*cli.Context) (*toxiproxy.ToxicOptions, error),
+	args ...string,
+) *toxiproxy.ToxicOptions {
+	var options *toxiproxy.ToxicOptions
+	command.Action = func(c *cli.Context) error {
+		var err error
+		options, err = parse(c)
+		return err
+	}
+	app := &cli.App{Commands: []*cli.Command{command}}
+	if err := app.Run(append([]string{"toxiproxy-cli", command.Name}, args...)); err != nil {
+		t.Fatal("Unable to run command:", err)
+	}
+	return options
+}
+
+func TestToxicityModeFlag(t *testing.T) {
+	options := parseToxicCommand(t, cliToxiAddSubCommand(), parseAddToxicParams,
+		"--type", "latency", "--toxicity-mode", "link", "myProxy")
+	if options.ToxicityMode != "link" {
+		t.Fatalf("Expected toxic add to send toxicity mode link, got %q", options.ToxicityMode)
+	}
+
+	options = parseToxicCommand(t, cliToxiUpdateSubCommand(), parseUpdateToxicParams,
+		"--toxicName", "latency_downstream", "--toxicity-mode", "packet", "myProxy")
+	if options.ToxicityMode != "packet" {
+		t.Fatalf("Expected toxic update to send toxicity mode packet, got %q", options.ToxicityMode)
+	}
+}
+
+func TestAttributeBooleans(t *testing.T) {
+	options := parseToxicCommand(t, cliToxiAddSubCommand(), parseAddToxicParams,
+		"--type", "latency", "-a", "reorder=true", "-a", "loop=false", "-a", "mode=t", "-a", "name=F", "myProxy")
+	if options.Attributes["reorder"] != true || options.Attributes["loop"] != false {
+		t.Fatal("Expected true and false to be booleans, got:", options.Attributes)
+	}
+	if options.Attributes["mode"] != "t" || options.Attributes["name"] != "F" {
+		t.Fatal("Expected other values to stay strings, got:", options.Attributes)
+	}
+}
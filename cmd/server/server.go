@@ -16,13 +16,19 @@ import (
 	"github.com/rs/zerolog/log"
 
 	toxiproxy "github.com/badrootd/udpcrusher"
+	"github.com/badrootd/udpcrusher/capture"
 	"github.com/badrootd/udpcrusher/collectors"
+	"github.com/badrootd/udpcrusher/replay"
+	"github.com/badrootd/udpcrusher/toxics"
 )
 
 type cliArguments struct {
 	host           string
 	port           string
 	config         string
+	traceDir       string
+	captureDir     string
+	recordDir      string
 	seed           int64
 	printVersion   bool
 	proxyMetrics   bool
@@ -37,6 +43,12 @@ func parseArguments() cliArguments {
 		"Port for toxiproxy's API to listen on")
 	flag.StringVar(&result.config, "config", "",
 		"JSON file containing proxies to create on startup")
+	flag.StringVar(&result.traceDir, "trace-dir", "",
+		"Directory trace files of toxic profiles are read from, empty allows any path")
+	flag.StringVar(&result.captureDir, "capture-dir", "",
+		"Directory capture files are written to, empty allows any path")
+	flag.StringVar(&result.recordDir, "record-dir", "",
+		"Directory recording files are written to, empty allows any path")
 	flag.Int64Var(&result.seed, "seed", time.Now().UTC().UnixNano(),
 		"Seed for randomizing toxics with")
 	flag.BoolVar(&result.runtimeMetrics, "runtime-metrics", false,
@@ -68,6 +80,9 @@ func run() error {
 	}
 
 	rand.Seed(cli.seed)
+	toxics.TraceDir = cli.traceDir
+	capture.Dir = cli.captureDir
+	replay.Dir = cli.recordDir
 
 	logger := setupLogger()
 	log.Logger = logger
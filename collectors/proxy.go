@@ -8,8 +8,13 @@ type ProxyMetricCollectors struct {
 	collectors  []prometheus.Collector
 	proxyLabels []string
 
-	ReceivedBytesTotal *prometheus.CounterVec
-	SentBytesTotal     *prometheus.CounterVec
+	ReceivedBytesTotal     *prometheus.CounterVec
+	SentBytesTotal         *prometheus.CounterVec
+	ReceivedDatagramsTotal *prometheus.CounterVec
+	SentDatagramsTotal     *prometheus.CounterVec
+	DroppedDatagramsTotal  *prometheus.CounterVec
+	ActiveSessions         *prometheus.GaugeVec
+	PacketDelaySeconds     *prometheus.HistogramVec
 }
 
 func (c *ProxyMetricCollectors) Collectors() []prometheus.Collector {
@@ -42,5 +47,51 @@ func NewProxyMetricCollectors() *ProxyMetricCollectors {
 		m.proxyLabels)
 	m.collectors = append(m.collectors, m.SentBytesTotal)
 
+	m.ReceivedDatagramsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "received_datagrams_total",
+		},
+		m.proxyLabels)
+	m.collectors = append(m.collectors, m.ReceivedDatagramsTotal)
+
+	m.SentDatagramsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "sent_datagrams_total",
+		},
+		m.proxyLabels)
+	m.collectors = append(m.collectors, m.SentDatagramsTotal)
+
+	m.DroppedDatagramsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "dropped_datagrams_total",
+		},
+		append(m.proxyLabels, "toxic"))
+	m.collectors = append(m.collectors, m.DroppedDatagramsTotal)
+
+	m.ActiveSessions = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "active_sessions",
+		},
+		m.proxyLabels[1:])
+	m.collectors = append(m.collectors, m.ActiveSessions)
+
+	m.PacketDelaySeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "packet_delay_seconds",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+		},
+		append(m.proxyLabels, "toxic"))
+	m.collectors = append(m.collectors, m.PacketDelaySeconds)
+
 	return &m
 }
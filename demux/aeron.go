@@ -0,0 +1,57 @@
+package demux
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// Aeron frame types, see the Aeron transport protocol specification.
+const (
+	aeronPad   = 0x00
+	aeronData  = 0x01
+	aeronNak   = 0x02
+	aeronSm    = 0x03
+	aeronSetup = 0x05
+	aeronRttm  = 0x06
+)
+
+// The AeronDemultiplexer routes frames by the session ID of the publication,
+// which is carried by data and setup frames from the publisher as well as by
+// status messages, NAKs and RTT measurements from the subscriber. Only the
+// first frame of a datagram is inspected.
+type AeronDemultiplexer struct{}
+
+func (d *AeronDemultiplexer) Upstream(data []byte) (string, bool) {
+	return d.sessionID(data)
+}
+
+func (d *AeronDemultiplexer) Downstream(data []byte) (string, bool) {
+	return d.sessionID(data)
+}
+
+// sessionID reads the session ID of a frame, all fields are little endian:
+// frame_length(4) version(1) flags(1) type(2) followed by the type specific
+// fields.
+func (d *AeronDemultiplexer) sessionID(data []byte) (string, bool) {
+	if len(data) < 8 {
+		return "", false
+	}
+
+	var offset int
+	switch binary.LittleEndian.Uint16(data[6:]) {
+	case aeronPad, aeronData, aeronSetup:
+		offset = 12 // After term_offset
+	case aeronNak, aeronSm, aeronRttm:
+		offset = 8
+	default:
+		return "", false
+	}
+	if len(data) < offset+4 {
+		return "", false
+	}
+	return strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(data[offset:]))), 10), true
+}
+
+func init() {
+	Register("aeron", func() Demultiplexer { return new(AeronDemultiplexer) })
+}
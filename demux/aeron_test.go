@@ -0,0 +1,42 @@
+package demux_test
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/badrootd/udpcrusher/demux"
+)
+
+func aeronFrame(frameType uint16, length int, sessionOffset int, sessionID int32) []byte {
+	frame := make([]byte, length)
+	binary.LittleEndian.PutUint32(frame, uint32(length))
+	binary.LittleEndian.PutUint16(frame[6:], frameType)
+	binary.LittleEndian.PutUint32(frame[sessionOffset:], uint32(sessionID))
+	return frame
+}
+
+func TestAeronDemultiplexer(t *testing.T) {
+	d := demux.New("aeron")
+
+	data, ok := d.Upstream(aeronFrame(0x01, 32, 12, -42))
+	if !ok {
+		t.Fatal("Expected a flow key for a data frame")
+	}
+	setup, ok := d.Upstream(aeronFrame(0x05, 40, 12, -42))
+	if !ok || setup != data {
+		t.Fatalf("Expected setup frame to match %q, got %q", data, setup)
+	}
+
+	sm, ok := d.Downstream(aeronFrame(0x03, 36, 8, -42))
+	if !ok || sm != data {
+		t.Fatalf("Expected status message to match %q, got %q", data, sm)
+	}
+	nak, ok := d.Downstream(aeronFrame(0x02, 28, 8, -42))
+	if !ok || nak != data {
+		t.Fatalf("Expected NAK to match %q, got %q", data, nak)
+	}
+
+	if _, ok := d.Downstream(aeronFrame(0x04, 16, 8, -42)); ok {
+		t.Fatal("Expected no flow key for an error frame")
+	}
+}
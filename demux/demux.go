@@ -0,0 +1,49 @@
+// Package demux provides demultiplexers that route the datagrams received on an
+// upstream socket shared by many clients back to the client they belong to.
+//
+// A demultiplexer extracts a flow key from every datagram. Keys seen on
+// datagrams sent by a client are remembered for that client, and datagrams from
+// the upstream are delivered to the client that sent the same key.
+package demux
+
+import (
+	"sync"
+)
+
+type Demultiplexer interface {
+	// Upstream returns the flow key of a datagram sent by a client, or false
+	// if the datagram doesn't carry one.
+	Upstream(data []byte) (string, bool)
+	// Downstream returns the flow key of a datagram received from the
+	// upstream, or false if it can't be routed.
+	Downstream(data []byte) (string, bool)
+}
+
+var (
+	registry      map[string]func() Demultiplexer
+	registryMutex sync.RWMutex
+)
+
+// Register makes a demultiplexer available by name. The factory is called once
+// for every proxy, so demultiplexers may keep state.
+func Register(name string, factory func() Demultiplexer) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if registry == nil {
+		registry = make(map[string]func() Demultiplexer)
+	}
+	registry[name] = factory
+}
+
+// New returns a new demultiplexer of the given name, or nil if there is none.
+func New(name string) Demultiplexer {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	factory, ok := registry[name]
+	if !ok {
+		return nil
+	}
+	return factory()
+}
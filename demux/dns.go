@@ -0,0 +1,29 @@
+package demux
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// The DNSDemultiplexer routes responses by the 16 bit transaction ID that every
+// DNS message starts with.
+type DNSDemultiplexer struct{}
+
+func (d *DNSDemultiplexer) Upstream(data []byte) (string, bool) {
+	return d.transactionID(data)
+}
+
+func (d *DNSDemultiplexer) Downstream(data []byte) (string, bool) {
+	return d.transactionID(data)
+}
+
+func (d *DNSDemultiplexer) transactionID(data []byte) (string, bool) {
+	if len(data) < 2 {
+		return "", false
+	}
+	return strconv.Itoa(int(binary.BigEndian.Uint16(data))), true
+}
+
+func init() {
+	Register("dns", func() Demultiplexer { return new(DNSDemultiplexer) })
+}
@@ -0,0 +1,24 @@
+package demux_test
+
+import (
+	"testing"
+
+	"github.com/badrootd/udpcrusher/demux"
+)
+
+func TestDNSDemultiplexer(t *testing.T) {
+	d := demux.New("dns")
+
+	query, ok := d.Upstream([]byte{0x12, 0x34, 0x01, 0x00})
+	if !ok {
+		t.Fatal("Expected a flow key for a query")
+	}
+	response, ok := d.Downstream([]byte{0x12, 0x34, 0x81, 0x80})
+	if !ok || response != query {
+		t.Fatalf("Expected response to match query key %q, got %q", query, response)
+	}
+
+	if _, ok := d.Downstream([]byte{0x12}); ok {
+		t.Fatal("Expected no flow key for a truncated message")
+	}
+}
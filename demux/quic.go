@@ -0,0 +1,77 @@
+package demux
+
+import (
+	"sync"
+)
+
+// The QUICDemultiplexer routes packets by connection ID. The source connection
+// ID of long header packets sent by a client becomes the destination connection
+// ID of the packets the server sends back. Short header packets don't encode the
+// connection ID length, so the length seen on the last long header is used.
+// Connection IDs issued later in encrypted frames can't be followed.
+type QUICDemultiplexer struct {
+	sync.Mutex
+	length int
+}
+
+func (d *QUICDemultiplexer) Upstream(data []byte) (string, bool) {
+	if !quicLongHeader(data) {
+		return "", false
+	}
+	_, scid, ok := quicConnectionIDs(data)
+	if !ok {
+		return "", false
+	}
+
+	d.Lock()
+	d.length = len(scid)
+	d.Unlock()
+	return string(scid), true
+}
+
+func (d *QUICDemultiplexer) Downstream(data []byte) (string, bool) {
+	if quicLongHeader(data) {
+		dcid, _, ok := quicConnectionIDs(data)
+		return string(dcid), ok
+	}
+
+	d.Lock()
+	length := d.length
+	d.Unlock()
+	if len(data) < 1+length {
+		return "", false
+	}
+	return string(data[1 : 1+length]), true
+}
+
+func quicLongHeader(data []byte) bool {
+	return len(data) > 0 && data[0]&0x80 != 0
+}
+
+// quicConnectionIDs parses the destination and source connection IDs of a long
+// header packet: flags(1) version(4) dcid_len(1) dcid scid_len(1) scid.
+func quicConnectionIDs(data []byte) (dcid, scid []byte, ok bool) {
+	offset := 5
+	if len(data) <= offset {
+		return nil, nil, false
+	}
+	length := int(data[offset])
+	offset++
+	if len(data) <= offset+length {
+		return nil, nil, false
+	}
+	dcid = data[offset : offset+length]
+	offset += length
+
+	length = int(data[offset])
+	offset++
+	if len(data) < offset+length {
+		return nil, nil, false
+	}
+	scid = data[offset : offset+length]
+	return dcid, scid, true
+}
+
+func init() {
+	Register("quic", func() Demultiplexer { return new(QUICDemultiplexer) })
+}
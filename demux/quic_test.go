@@ -0,0 +1,42 @@
+package demux_test
+
+import (
+	"testing"
+
+	"github.com/badrootd/udpcrusher/demux"
+)
+
+func quicLongHeader(dcid, scid []byte) []byte {
+	packet := []byte{0xc0, 0, 0, 0, 1}
+	packet = append(packet, byte(len(dcid)))
+	packet = append(packet, dcid...)
+	packet = append(packet, byte(len(scid)))
+	packet = append(packet, scid...)
+	return append(packet, "payload"...)
+}
+
+func TestQUICDemultiplexer(t *testing.T) {
+	d := demux.New("quic")
+	client := []byte{1, 2, 3, 4}
+	server := []byte{9, 8, 7, 6, 5, 4, 3, 2}
+
+	initial, ok := d.Upstream(quicLongHeader(server, client))
+	if !ok || initial != string(client) {
+		t.Fatalf("Expected the client's source connection ID, got %v", []byte(initial))
+	}
+
+	// Short header packets from the client carry the server's connection ID
+	if _, ok := d.Upstream(append([]byte{0x40}, server...)); ok {
+		t.Fatal("Expected no flow key for a short header packet from the client")
+	}
+
+	handshake, ok := d.Downstream(quicLongHeader(client, server))
+	if !ok || handshake != initial {
+		t.Fatalf("Expected long header reply to match %v, got %v", client, []byte(handshake))
+	}
+
+	short, ok := d.Downstream(append(append([]byte{0x40}, client...), "payload"...))
+	if !ok || short != initial {
+		t.Fatalf("Expected short header reply to match %v, got %v", client, []byte(short))
+	}
+}
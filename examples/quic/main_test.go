@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	toxiproxy "github.com/badrootd/udpcrusher"
+	"github.com/badrootd/udpcrusher/stream"
+	"github.com/badrootd/udpcrusher/toxics"
+)
+
+// pathTap sits between the proxy and the QUIC server. It records every source
+// address the proxy sends from and relays the server's replies to the latest
+// one, like a server that validates the new path of a rebound client. The
+// quic-go server used here doesn't support connection migration yet, so on its
+// own it would keep replying to the old source port.
+type pathTap struct {
+	conn   net.PacketConn
+	server *net.UDPConn
+
+	sync.Mutex
+	sources []string
+	latest  net.Addr
+	// Replies relayed to every source but the first one
+	rebound int
+}
+
+func newPathTap(t *testing.T, server string) *pathTap {
+	conn, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	serverAddr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		t.Fatalf("Failed to resolve server: %v", err)
+	}
+	serverConn, err := net.DialUDP("udp", nil, serverAddr)
+	if err != nil {
+		t.Fatalf("Failed to dial server: %v", err)
+	}
+
+	tap := &pathTap{conn: conn, server: serverConn}
+	go tap.relayUpstream()
+	go tap.relayDownstream()
+	return tap
+}
+
+func (tap *pathTap) Addr() string {
+	return tap.conn.LocalAddr().String()
+}
+
+func (tap *pathTap) Close() {
+	tap.conn.Close()
+	tap.server.Close()
+}
+
+func (tap *pathTap) relayUpstream() {
+	buf := make([]byte, stream.MaxDatagramSize)
+	for {
+		n, source, err := tap.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		tap.Lock()
+		if tap.latest == nil || tap.latest.String() != source.String() {
+			tap.sources = append(tap.sources, source.String())
+			tap.latest = source
+		}
+		tap.Unlock()
+		if _, err := tap.server.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+func (tap *pathTap) relayDownstream() {
+	buf := make([]byte, stream.MaxDatagramSize)
+	for {
+		n, err := tap.server.Read(buf)
+		if err != nil {
+			return
+		}
+		tap.Lock()
+		latest := tap.latest
+		if len(tap.sources) > 1 {
+			tap.rebound++
+		}
+		tap.Unlock()
+		if _, err := tap.conn.WriteTo(buf[:n], latest); err != nil {
+			return
+		}
+	}
+}
+
+// Sources returns the distinct source addresses the proxy sent from, in order.
+func (tap *pathTap) Sources() ([]string, int) {
+	tap.Lock()
+	defer tap.Unlock()
+	return append([]string(nil), tap.sources...), tap.rebound
+}
+
+// The proxy moves the QUIC client to a new source port mid-flow, as a NAT
+// rebinding would. The upstream has to see the datagrams arrive from the new
+// port, and the ping pong has to go on over the new path.
+func TestPingPongWithRebinding(t *testing.T) {
+	srv := NewServer()
+	go srv.Start()
+	if !<-srv.started {
+		t.Fatal("Failed to start Echo server")
+	}
+
+	tap := newPathTap(t, addr)
+	defer tap.Close()
+
+	proxy := toxiproxy.NewProxy(nil, "quic-rebind", "localhost:0", tap.Addr())
+	if err := proxy.Start(); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer proxy.Stop()
+
+	rebind := &toxics.RebindToxic{Interval: 500}
+	err := proxy.Toxics.AddToxic(&toxics.ToxicWrapper{
+		Toxic:     rebind,
+		Type:      "rebind",
+		Direction: stream.Upstream,
+	})
+	if err != nil {
+		t.Fatalf("AddToxic returned error: %v", err)
+	}
+
+	cl, err := NewClient(proxy.Listen)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer cl.Close()
+
+	count := cl.PingPong("Hello world", time.Second)
+	if count == 0 {
+		t.Fatal("Expected messages to be echoed")
+	}
+	if rebind.Rebinds.Load() == 0 {
+		t.Fatal("Expected the client session to be rebound")
+	}
+
+	sources, rebound := tap.Sources()
+	if len(sources) < 2 {
+		t.Fatalf("Expected datagrams from a new source port after the rebind, got %v", sources)
+	}
+	if rebound == 0 {
+		t.Fatal("Expected the server to keep replying over the new path")
+	}
+}
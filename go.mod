@@ -4,7 +4,6 @@ go 1.21.0
 
 require (
 	github.com/HdrHistogram/hdrhistogram-go v1.1.2
-	github.com/Shopify/toxiproxy/v2 v2.6.0
 	github.com/gorilla/mux v1.8.0
 	github.com/lirm/aeron-go v0.0.0-20230913020202-fe9f4a2f5dc3
 	github.com/miekg/dns v1.1.55
@@ -12,6 +11,8 @@ require (
 	github.com/quic-go/quic-go v0.38.0
 	github.com/rs/zerolog v1.30.0
 	github.com/urfave/cli/v2 v2.23.0
+	golang.org/x/net v0.10.0
+	golang.org/x/sys v0.11.0
 	golang.org/x/term v0.11.0
 	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7
 )
@@ -48,8 +49,6 @@ require (
 	golang.org/x/crypto v0.4.0 // indirect
 	golang.org/x/exp v0.0.0-20221205204356-47842c84f3db // indirect
 	golang.org/x/mod v0.10.0 // indirect
-	golang.org/x/net v0.10.0 // indirect
-	golang.org/x/sys v0.11.0 // indirect
 	golang.org/x/tools v0.9.1 // indirect
 	google.golang.org/protobuf v1.30.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/badrootd/udpcrusher/capture"
 	"github.com/badrootd/udpcrusher/stream"
 	"github.com/badrootd/udpcrusher/toxics"
 
 	"io"
+	"net"
+	"strconv"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -25,9 +28,16 @@ type ToxicLink struct {
 	stubs     []*toxics.ToxicStub
 	proxy     *Proxy
 	toxics    *ToxicCollection
-	input     *stream.ChanWriter
-	output    *stream.ChanReader
+	input     *stream.PacketWriter
+	output    *stream.PacketReader
 	direction stream.Direction
+	name      string
+	session   uint64 // Ordinal of the link's session, seeds its toxics
+	seed      int64
+	reporter  toxics.Reporter
+	rebinder  toxics.Rebinder
+	client    *net.UDPAddr
+	upstream  *net.UDPAddr
 	Logger    *zerolog.Logger
 }
 
@@ -46,11 +56,12 @@ func NewToxicLink(
 		proxy:     proxy,
 		toxics:    collection,
 		direction: direction,
+		seed:      collection.seed,
 		Logger:    &logger,
 	}
 	// Initialize the link with ToxicStubs
 	last := make(chan *stream.StreamChunk) // The first toxic is always a noop
-	link.input = stream.NewChanWriter(last)
+	link.input = stream.NewPacketWriter(last)
 	for i := 0; i < len(link.stubs); i++ {
 		var next chan *stream.StreamChunk
 		if i+1 < len(link.stubs) {
@@ -62,7 +73,7 @@ func NewToxicLink(
 		link.stubs[i] = toxics.NewToxicStub(last, next)
 		last = next
 	}
-	link.output = stream.NewChanReader(last)
+	link.output = stream.NewPacketReader(last)
 	return link
 }
 
@@ -75,6 +86,7 @@ func (link *ToxicLink) Start(
 ) {
 	logger := link.Logger
 	logger.Debug().Str("direction", link.Direction()).Msg("Setup connection")
+	link.name = name
 
 	labels := []string{
 		link.Direction(),
@@ -82,12 +94,20 @@ func (link *ToxicLink) Start(
 		link.proxy.Listen,
 		link.proxy.Upstream}
 
+	if server != nil && server.Metrics.proxyMetricsEnabled() {
+		link.reporter = newToxicReporter(server.Metrics.ProxyMetrics, labels)
+	}
+
 	go link.read(labels, server, source)
 
 	for i, toxic := range link.toxics.chain[link.direction] {
+		link.stubs[i].Reporter = link.reporter
+		link.stubs[i].Rebinder = link.rebinder
+		link.stubs[i].Client = link.client
 		if stateful, ok := toxic.Toxic.(toxics.StatefulToxic); ok {
 			link.stubs[i].State = stateful.NewState()
 		}
+		link.stubs[i].Reseed(toxic, link.seed, link.session)
 
 		//if _, ok := toxic.Toxic.(*toxics.ResetToxic); ok {
 		//	if err := source.(*net.TCPConn).SetLinger(0); err != nil {
@@ -109,21 +129,37 @@ func (link *ToxicLink) Start(
 	go link.write(labels, name, server, dest)
 }
 
-// read copies bytes from a source to the link's input channel.
+// read copies datagrams from a source to the link's input channel, one
+// StreamChunk per datagram.
 func (link *ToxicLink) read(metricLabels []string, server *ApiServer, source io.Reader) {
 	logger := link.Logger
-	bytes, err := io.Copy(link.input, source)
+	var input io.Writer = link.input
+	if server != nil && server.Metrics.proxyMetricsEnabled() {
+		input = &countingWriter{
+			Writer: link.input,
+			datagrams: server.Metrics.ProxyMetrics.ReceivedDatagramsTotal.
+				WithLabelValues(metricLabels...),
+			bytes: server.Metrics.ProxyMetrics.ReceivedBytesTotal.
+				WithLabelValues(metricLabels...),
+		}
+	}
+	input = link.captureWriter(input, capture.Ingress)
+	input = &recordWriter{
+		Writer:    input,
+		proxy:     link.proxy,
+		session:   strconv.FormatUint(link.session, 10),
+		client:    link.client.String(),
+		direction: link.direction,
+	}
+	_, bytes, err := stream.CopyPackets(input, source)
 	if err != nil {
 		logger.Warn().Int64("bytes", bytes).Err(err).Msg("Source terminated")
 	}
-	if server != nil && server.Metrics.proxyMetricsEnabled() {
-		server.Metrics.ProxyMetrics.ReceivedBytesTotal.
-			WithLabelValues(metricLabels...).Add(float64(bytes))
-	}
 	link.input.Close()
 }
 
-// write copies bytes from the link's output channel to a destination.
+// write copies datagrams from the link's output channel to a destination, one
+// Write per StreamChunk.
 func (link *ToxicLink) write(metricLabels []string, name string, server *ApiServer, dest io.WriteCloser) {
 	logger := link.Logger.
 		With().
@@ -134,30 +170,52 @@ func (link *ToxicLink) write(metricLabels []string, name string, server *ApiServ
 		Str("link_addr", fmt.Sprintf("%p", link)).
 		Logger()
 
-	bytes, err := io.Copy(dest, link.output)
+	var output io.Writer = dest
+	if server != nil && server.Metrics.proxyMetricsEnabled() {
+		output = &countingWriter{
+			Writer: dest,
+			datagrams: server.Metrics.ProxyMetrics.SentDatagramsTotal.
+				WithLabelValues(metricLabels...),
+			bytes: server.Metrics.ProxyMetrics.SentBytesTotal.
+				WithLabelValues(metricLabels...),
+		}
+	}
+	output = link.captureWriter(output, capture.Egress)
+	_, bytes, err := stream.CopyPackets(output, link.output)
 	if err != nil {
 		logger.Warn().
 			Int64("bytes", bytes).
 			Err(err).
 			Msg("Could not write to destination")
-	} else if server != nil && server.Metrics.proxyMetricsEnabled() {
-		server.Metrics.ProxyMetrics.SentBytesTotal.
-			WithLabelValues(metricLabels...).Add(float64(bytes))
 	}
 
 	dest.Close()
 	logger.Trace().Msgf("Remove link %s from ToxicCollection", name)
-	link.toxics.RemoveLink(name)
+	link.toxics.RemoveLink(name, link)
 	logger.Trace().Msgf("RemoveConnection %s from Proxy %s", name, link.proxy.Name)
 	link.proxy.RemoveConnection(name)
 }
 
+// captureWriter records the datagrams written to w on the proxy's capture. The
+// flow always goes from client to upstream or back, whichever interface it is
+// recorded on.
+func (link *ToxicLink) captureWriter(w io.Writer, iface capture.Interface) io.Writer {
+	src, dst := link.client, link.upstream
+	if link.direction == stream.Downstream {
+		src, dst = dst, src
+	}
+	return &captureWriter{Writer: w, proxy: link.proxy, iface: iface, src: src, dst: dst}
+}
+
 // Add a toxic to the end of the chain.
 func (link *ToxicLink) AddToxic(toxic *toxics.ToxicWrapper) {
 	i := len(link.stubs)
 
 	newin := make(chan *stream.StreamChunk, toxic.BufferSize)
 	link.stubs = append(link.stubs, toxics.NewToxicStub(newin, link.stubs[i-1].Output))
+	link.stubs[i].Reporter = link.reporter
+	link.stubs[i].Rebinder = link.rebinder
+	link.stubs[i].Client = link.client
 
 	// Interrupt the last toxic so that we don't have a race when moving channels
 	if link.stubs[i-1].InterruptToxic() {
@@ -166,6 +224,7 @@ func (link *ToxicLink) AddToxic(toxic *toxics.ToxicWrapper) {
 		if stateful, ok := toxic.Toxic.(toxics.StatefulToxic); ok {
 			link.stubs[i].State = stateful.NewState()
 		}
+		link.stubs[i].Reseed(toxic, link.seed, link.session)
 
 		go link.stubs[i].Run(toxic)
 		go link.stubs[i-1].Run(link.toxics.chain[link.direction][i-1])
@@ -176,9 +235,13 @@ func (link *ToxicLink) AddToxic(toxic *toxics.ToxicWrapper) {
 	}
 }
 
-// Update an existing toxic in the chain.
-func (link *ToxicLink) UpdateToxic(toxic *toxics.ToxicWrapper) {
+// Update an existing toxic in the chain. With reseed set, e.g. for a changed
+// seed attribute, the random sequence of the toxic starts over.
+func (link *ToxicLink) UpdateToxic(toxic *toxics.ToxicWrapper, reseed bool) {
 	if link.stubs[toxic.Index].InterruptToxic() {
+		if reseed {
+			link.stubs[toxic.Index].Reseed(toxic, link.seed, link.session)
+		}
 		go link.stubs[toxic.Index].Run(toxic)
 	}
 }
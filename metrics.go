@@ -1,9 +1,12 @@
 package toxiproxy
 
 import (
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/badrootd/udpcrusher/collectors"
+	"github.com/badrootd/udpcrusher/toxics"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -50,3 +53,40 @@ func (m *metricsContainer) handler() http.Handler {
 	return promhttp.HandlerFor(
 		m.registry, promhttp.HandlerOpts{Registry: m.registry})
 }
+
+// countingWriter counts every datagram and byte written through it.
+type countingWriter struct {
+	io.Writer
+	datagrams prometheus.Counter
+	bytes     prometheus.Counter
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	if err == nil {
+		w.datagrams.Inc()
+		w.bytes.Add(float64(n))
+	}
+	return n, err
+}
+
+// toxicReporter exports the drops and delays of a link's toxics.
+type toxicReporter struct {
+	metrics *collectors.ProxyMetricCollectors
+	labels  []string
+}
+
+func newToxicReporter(metrics *collectors.ProxyMetricCollectors, labels []string) *toxicReporter {
+	// Limit the capacity so appending the toxic label always copies
+	return &toxicReporter{metrics, labels[:len(labels):len(labels)]}
+}
+
+func (r *toxicReporter) Dropped(toxic *toxics.ToxicWrapper) {
+	r.metrics.DroppedDatagramsTotal.
+		WithLabelValues(append(r.labels, toxic.Name)...).Inc()
+}
+
+func (r *toxicReporter) Delayed(toxic *toxics.ToxicWrapper, delay time.Duration) {
+	r.metrics.PacketDelaySeconds.
+		WithLabelValues(append(r.labels, toxic.Name)...).Observe(delay.Seconds())
+}
@@ -0,0 +1,83 @@
+package toxiproxy_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+
+	toxiproxy "github.com/badrootd/udpcrusher"
+	"github.com/badrootd/udpcrusher/collectors"
+	"github.com/badrootd/udpcrusher/testhelper"
+)
+
+// AssertMetric waits for a metric to reach the expected value, as datagrams
+// are counted asynchronously by the links.
+func AssertMetric(t *testing.T, name string, c prometheus.Collector, expected float64) {
+	var actual float64
+	for start := time.Now(); time.Since(start) < time.Second; {
+		actual = testutil.ToFloat64(c)
+		if actual == expected {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Expected %s to be %v, got %v", name, expected, actual)
+}
+
+func TestProxyMetricsCountDatagrams(t *testing.T) {
+	upstream := testhelper.NewUpstream(t, false)
+	defer upstream.Close()
+
+	srv := toxiproxy.NewServer(
+		toxiproxy.NewMetricsContainer(prometheus.NewRegistry()),
+		zerolog.Nop(),
+	)
+	metrics := collectors.NewProxyMetricCollectors()
+	srv.Metrics.ProxyMetrics = metrics
+
+	proxy := toxiproxy.NewProxy(srv, "test", "localhost:0", upstream.Addr())
+	proxy.Start()
+	defer proxy.Stop()
+
+	_, err := proxy.Toxics.AddToxicJson(strings.NewReader(
+		`{"name":"every_second","type":"loss","stream":"upstream",` +
+			`"attributes":{"model":"nth","nth":2}}`))
+	if err != nil {
+		t.Fatalf("Unable to add toxic: %v", err)
+	}
+
+	client, err := net.Dial("udp", proxy.Listen)
+	if err != nil {
+		t.Fatalf("Unable to dial UDP server: %v", err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 4; i++ {
+		_, err = client.Write([]byte("hello"))
+		if err != nil {
+			t.Fatalf("Unable to send UDP msg: %v", err)
+		}
+	}
+	AssertNewUpstreamClient(t, upstream)
+
+	labels := []string{"upstream", "test", proxy.Listen, upstream.Addr()}
+	AssertMetric(t, "received datagrams",
+		metrics.ReceivedDatagramsTotal.WithLabelValues(labels...), 4)
+	AssertMetric(t, "received bytes",
+		metrics.ReceivedBytesTotal.WithLabelValues(labels...), 20)
+	AssertMetric(t, "sent datagrams",
+		metrics.SentDatagramsTotal.WithLabelValues(labels...), 2)
+	AssertMetric(t, "dropped datagrams",
+		metrics.DroppedDatagramsTotal.WithLabelValues(append(labels, "every_second")...), 2)
+	AssertMetric(t, "active sessions",
+		metrics.ActiveSessions.WithLabelValues("test", proxy.Listen, upstream.Addr()), 1)
+
+	proxy.Stop()
+	AssertMetric(t, "active sessions",
+		metrics.ActiveSessions.WithLabelValues("test", proxy.Listen, upstream.Addr()), 0)
+}
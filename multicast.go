@@ -0,0 +1,93 @@
+package toxiproxy
+
+import (
+	"net"
+	"strconv"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// multicastGroup returns the group of a multicast address, or nil for any
+// other address.
+func multicastGroup(address string) *net.UDPAddr {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil || !addr.IP.IsMulticast() {
+		return nil
+	}
+	return addr
+}
+
+// multicastInterface returns the interface groups are joined and published on,
+// nil lets the system pick one.
+func (proxy *Proxy) multicastInterface() (*net.Interface, error) {
+	if proxy.MulticastInterface == "" {
+		return nil, nil
+	}
+	return net.InterfaceByName(proxy.MulticastInterface)
+}
+
+// listenPacket opens the socket the proxy receives client datagrams on. For a
+// multicast listen address it joins the group, every publisher to the group
+// becomes a client, and replies are sent to the publisher by unicast.
+func (proxy *Proxy) listenPacket() (net.PacketConn, error) {
+	group := multicastGroup(proxy.Listen)
+	if group == nil {
+		listener, err := net.ListenPacket("udp", proxy.Listen)
+		if err != nil {
+			return nil, err
+		}
+		proxy.Listen = listener.LocalAddr().String()
+		return listener, nil
+	}
+
+	ifi, err := proxy.multicastInterface()
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.ListenMulticastUDP("udp", ifi, group)
+	if err != nil {
+		return nil, err
+	}
+	// The socket is bound to the wildcard address, keep the group
+	port := listener.LocalAddr().(*net.UDPAddr).Port
+	proxy.Listen = net.JoinHostPort(group.IP.String(), strconv.Itoa(port))
+	return listener, nil
+}
+
+// dialMulticast opens an upstream socket re-publishing datagrams onto a
+// multicast group. It is not connected, so the unicast replies of the group's
+// receivers, like NAKs and status messages, arrive on it.
+func dialMulticast(group *net.UDPAddr, ifi *net.Interface) (*net.UDPConn, error) {
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := publishMulticast(conn, group, ifi); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// publishMulticast sends the datagrams of conn to group on ifi. They are looped
+// back, so receivers on this host get them too.
+func publishMulticast(conn *net.UDPConn, group *net.UDPAddr, ifi *net.Interface) error {
+	if group.IP.To4() != nil {
+		p := ipv4.NewPacketConn(conn)
+		if ifi != nil {
+			if err := p.SetMulticastInterface(ifi); err != nil {
+				return err
+			}
+		}
+		return p.SetMulticastLoopback(true)
+	}
+
+	p := ipv6.NewPacketConn(conn)
+	if ifi != nil {
+		if err := p.SetMulticastInterface(ifi); err != nil {
+			return err
+		}
+	}
+	return p.SetMulticastLoopback(true)
+}
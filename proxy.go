@@ -2,12 +2,18 @@ package toxiproxy
 
 import (
 	"errors"
+	"io"
 	"net"
 	"os"
+	"slices"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/badrootd/udpcrusher/capture"
+	"github.com/badrootd/udpcrusher/demux"
+	"github.com/badrootd/udpcrusher/replay"
 	"github.com/badrootd/udpcrusher/stream"
 
 	"github.com/rs/zerolog"
@@ -27,6 +33,47 @@ type Proxy struct {
 	Upstream string `json:"upstream"`
 	Enabled  bool   `json:"enabled"`
 
+	// Further upstreams besides Upstream, which is the primary one. They are
+	// ignored for a shared or multicast upstream
+	Upstreams []string `json:"upstreams"`
+	// How client sessions are spread over the upstreams: round_robin (default),
+	// hash of the client IP, failover to the next upstream once one refuses
+	// datagrams, or mirror every datagram to all upstreams and only return the
+	// replies of the primary one
+	Policy string `json:"policy"`
+
+	// Time in milliseconds after which an inactive client session is evicted,
+	// 0 keeps sessions until the proxy is stopped
+	IdleTimeout int64 `json:"idle_timeout"`
+	// Maximum number of client sessions, the least recently used session is
+	// evicted to make room for a new one. 0 means unlimited
+	MaxSessions int `json:"max_sessions"`
+	// Seed for the random decisions of toxics without their own seed attribute,
+	// 0 picks a random seed for every link. A run replays with the same seed as
+	// long as its clients arrive in the same order, whatever their ports
+	Seed int64 `json:"seed"`
+	// Bind the upstream socket of every client session to the client's address,
+	// so the upstream sees and replies to the original client. Linux only,
+	// requires CAP_NET_ADMIN and routing that delivers the replies back to the
+	// proxy, e.g. an ip rule for a TPROXY mark in a network namespace
+	Transparent bool `json:"transparent"`
+	// Multiplex all clients over a single upstream socket and route the replies
+	// with the named demultiplexer (dns, quic or aeron). Empty dials one
+	// upstream socket per client. Transparent is ignored for a shared socket
+	Demultiplexer string `json:"demultiplexer"`
+	// Name of the interface multicast groups are joined and published on, empty
+	// lets the system pick one. A multicast listen address joins that group and
+	// every publisher becomes a client, a multicast upstream re-publishes onto
+	// that group. Unicast replies are routed back to the publisher either way.
+	// Transparent is ignored for a multicast upstream
+	MulticastInterface string `json:"multicast_interface"`
+	// Path of a pcapng file every datagram is recorded to, before the toxics on
+	// the ingress and after them on the egress interface. Empty disables it
+	Capture string `json:"capture"`
+	// Path of a file the datagrams of every client session are recorded to,
+	// before the toxics, for replaying them later. Empty disables it
+	Record string `json:"record"`
+
 	//listener net.Listener
 	listener net.PacketConn
 	started  chan error
@@ -37,19 +84,38 @@ type Proxy struct {
 	apiServer   *ApiServer
 	Logger      *zerolog.Logger
 
-	portToClient map[string]UDPReader
+	sessions  *sessionTable
+	shared    *sharedUpstream
+	upstreams *upstreamPool
+	capturer  atomic.Pointer[capture.Writer]
+	recorder  atomic.Pointer[replay.Recorder]
 }
 
+// UDPReader delivers the datagrams the proxy received from a single client.
+// Every Read returns exactly one datagram.
 type UDPReader struct {
 	incoming chan []byte
+	done     chan struct{}
+}
+
+func newUDPReader() UDPReader {
+	return UDPReader{
+		incoming: make(chan []byte, 1000),
+		done:     make(chan struct{}),
+	}
 }
 
+// Read returns io.EOF once the client session has been closed.
 func (u UDPReader) Read(p []byte) (n int, err error) {
-	v := <-u.incoming
-	cc := copy(p, v)
-	return cc, nil
+	select {
+	case v := <-u.incoming:
+		return copy(p, v), nil
+	case <-u.done:
+		return 0, io.EOF
+	}
 }
 
+// UDPWriter sends every Write as a single datagram to rAddr.
 type UDPWriter struct {
 	outgoing net.PacketConn
 	rAddr    net.Addr
@@ -81,7 +147,12 @@ func (c *ConnectionList) Unlock() {
 	c.lock.Unlock()
 }
 
-var ErrProxyAlreadyStarted = errors.New("Proxy already started")
+var (
+	ErrProxyAlreadyStarted    = errors.New("Proxy already started")
+	ErrTransparentUnsupported = errors.New("Transparent mode is only supported on Linux")
+	ErrUnknownDemultiplexer   = errors.New("Unknown demultiplexer")
+	ErrUnknownPolicy          = errors.New("Unknown upstream policy")
+)
 
 func NewProxy(server *ApiServer, name, listen, upstream string) *Proxy {
 	l := setupLogger()
@@ -94,9 +165,9 @@ func NewProxy(server *ApiServer, name, listen, upstream string) *Proxy {
 		connections: ConnectionList{list: make(map[string]net.PacketConn)},
 		apiServer:   server,
 		Logger:      &l,
+		sessions:    newSessionTable(),
 	}
 	proxy.Toxics = NewToxicCollection(proxy)
-	proxy.portToClient = make(map[string]UDPReader)
 	return proxy
 }
 
@@ -111,10 +182,54 @@ func (proxy *Proxy) Update(input *Proxy) error {
 	proxy.Lock()
 	defer proxy.Unlock()
 
-	if input.Listen != proxy.Listen || input.Upstream != proxy.Upstream {
+	if input.Listen != proxy.Listen ||
+		input.Upstream != proxy.Upstream ||
+		!slices.Equal(input.Upstreams, proxy.Upstreams) ||
+		input.Policy != proxy.Policy ||
+		input.Transparent != proxy.Transparent ||
+		input.Demultiplexer != proxy.Demultiplexer ||
+		input.MulticastInterface != proxy.MulticastInterface {
 		stop(proxy)
 		proxy.Listen = input.Listen
 		proxy.Upstream = input.Upstream
+		proxy.Upstreams = input.Upstreams
+		proxy.Policy = input.Policy
+		proxy.Transparent = input.Transparent
+		proxy.Demultiplexer = input.Demultiplexer
+		proxy.MulticastInterface = input.MulticastInterface
+	}
+
+	if input.IdleTimeout != proxy.IdleTimeout || input.MaxSessions != proxy.MaxSessions {
+		proxy.IdleTimeout = input.IdleTimeout
+		proxy.MaxSessions = input.MaxSessions
+		proxy.configureSessions()
+	}
+
+	if input.Seed != proxy.Seed {
+		proxy.Seed = input.Seed
+		proxy.Toxics.SetSeed(proxy.Seed)
+	}
+
+	if input.Capture != proxy.Capture {
+		proxy.closeCapture()
+		proxy.Capture = input.Capture
+		if proxy.Enabled && proxy.Capture != "" {
+			if err := proxy.openCapture(); err != nil {
+				proxy.Capture = ""
+				return err
+			}
+		}
+	}
+
+	if input.Record != proxy.Record {
+		proxy.closeRecording()
+		proxy.Record = input.Record
+		if proxy.Enabled && proxy.Record != "" {
+			if err := proxy.openRecording(); err != nil {
+				proxy.Record = ""
+				return err
+			}
+		}
 	}
 
 	if input.Enabled != proxy.Enabled {
@@ -135,12 +250,11 @@ func (proxy *Proxy) Stop() {
 
 func (proxy *Proxy) listen() error {
 	var err error
-	proxy.listener, err = net.ListenPacket("udp", proxy.Listen)
+	proxy.listener, err = proxy.listenPacket()
 	if err != nil {
 		proxy.started <- err
 		return err
 	}
-	proxy.Listen = proxy.listener.LocalAddr().String()
 	proxy.started <- nil
 
 	proxy.Logger.Info().Str("addr", proxy.listener.LocalAddr().String()).Msg("Started proxy")
@@ -171,6 +285,59 @@ func (proxy *Proxy) freeBlocker(acceptTomb *tomb.Tomb) {
 	proxy.tomb.Done()
 }
 
+// evictIdleSessions periodically closes client sessions that have been idle for
+// longer than the proxy's IdleTimeout.
+func (proxy *Proxy) evictIdleSessions(acceptTomb *tomb.Tomb) {
+	for {
+		select {
+		case <-acceptTomb.Dying():
+			return
+		case <-time.After(proxy.sessions.sweepInterval()):
+			proxy.closeSessions(proxy.sessions.sweep(), "idle")
+		}
+	}
+}
+
+// configureSessions applies IdleTimeout and MaxSessions to the session table,
+// assumes the lock has already been taken.
+func (proxy *Proxy) configureSessions() {
+	evicted := proxy.sessions.configure(
+		time.Duration(proxy.IdleTimeout)*time.Millisecond,
+		proxy.MaxSessions,
+	)
+	proxy.closeSessions(evicted, "limits changed")
+}
+
+func (proxy *Proxy) closeSessions(sessions []*session, reason string) {
+	for _, s := range sessions {
+		proxy.Logger.Debug().
+			Str("client", s.name).
+			Str("reason", reason).
+			Msg("Evicted client session")
+		s.close()
+	}
+	if len(sessions) > 0 {
+		proxy.updateSessionMetrics()
+	}
+}
+
+// updateSessionMetrics exports the number of active sessions.
+func (proxy *Proxy) updateSessionMetrics() {
+	server := proxy.apiServer
+	if server == nil || !server.Metrics.proxyMetricsEnabled() {
+		return
+	}
+	server.Metrics.ProxyMetrics.ActiveSessions.
+		WithLabelValues(proxy.Name, proxy.Listen, proxy.Upstream).
+		Set(float64(proxy.sessions.Len()))
+}
+
+// ActiveSessions returns the number of clients that currently have a session
+// with the proxy.
+func (proxy *Proxy) ActiveSessions() int {
+	return proxy.sessions.Len()
+}
+
 // server runs the Proxy server, accepting new clients and creating Links to
 // connect them to upstreams.
 func (proxy *Proxy) server() {
@@ -185,12 +352,17 @@ func (proxy *Proxy) server() {
 	// This channel is to kill the blocking Accept() call below by closing the
 	// net.Listener.
 	go proxy.freeBlocker(acceptTomb)
+	go proxy.evictIdleSessions(acceptTomb)
+	if proxy.shared != nil {
+		go proxy.shared.run()
+	}
 
+	var ordinal uint64
 	for {
 		//client, err := proxy.listener.Accept()
 
 		// Create a buffer to hold incoming packets
-		buffer := make([]byte, 65535)
+		buffer := make([]byte, stream.MaxDatagramSize)
 		n, clientAddr, err := proxy.listener.ReadFrom(buffer)
 		if err != nil {
 			// This is to confirm we're being shut down in a legit way. Unfortunately,
@@ -206,56 +378,129 @@ func (proxy *Proxy) server() {
 			return
 		}
 
-		clientReader, ok := proxy.portToClient[clientAddr.String()]
 		dst := make([]byte, n)
 		copy(dst, buffer[:n])
-		if ok {
-			clientReader.incoming <- dst
+		if s := proxy.sessions.get(clientAddr.String()); s != nil {
+			if proxy.shared != nil {
+				proxy.shared.learn(s, dst)
+			}
+			s.deliver(dst)
 			continue
 		}
 
-		// add new client
-		readerCh := make(chan []byte, 1000)
-		clientReader = UDPReader{
-			incoming: readerCh,
+		s, upstreamWriter, err := proxy.newSession(clientAddr)
+		if err != nil {
+			proxy.Logger.Err(err).Str("client", proxy.Upstream).Msg("Unable to open connection to upstream")
+			continue
 		}
+		ordinal++
+		s.ordinal = ordinal
 
-		clientReader.incoming <- dst
-		proxy.portToClient[clientAddr.String()] = clientReader
-
-		// create new UDP downstream client
-		//downClient, err := net.Dial("udp", clientAddr.String())
-		//if err != nil {
-		//	proxy.Logger.Err(err).Str("client", downClient.RemoteAddr().String()).Msg("Failed to create UDP connection")
-		//	return
-		//}
-		//proxy.Logger.Info().Str("client", downClient.RemoteAddr().String()).Msg("Accepted client")
+		// add new client
+		if proxy.shared != nil {
+			proxy.shared.learn(s, dst)
+		}
+		s.reader.incoming <- dst
+		proxy.closeSessions(proxy.sessions.add(s), "max sessions")
+		proxy.updateSessionMetrics()
 
 		clientWriter := UDPWriter{
 			outgoing: proxy.listener,
 			rAddr:    clientAddr,
 		}
 
-		// create new UDP upstream client
-		upstreamAddress, err := net.ResolveUDPAddr("udp", proxy.Upstream)
+		name := s.name
+		proxy.connections.Lock()
+		if s.shared == nil {
+			proxy.connections.list[name+"upstream"] = s.conn()
+		}
+		proxy.connections.list[name+"downstream"] = proxy.listener
+		proxy.connections.Unlock()
+		proxy.Toxics.StartLink(proxy.apiServer, name+"upstream", s.reader, upstreamWriter, stream.Upstream, s)
+		proxy.Toxics.StartLink(proxy.apiServer, name+"downstream", s, clientWriter, stream.Downstream, s)
+	}
+}
+
+// newSession creates the session of a new client and the destination of its
+// upstream link, either a socket of its own or the shared upstream socket.
+func (proxy *Proxy) newSession(client net.Addr) (*session, io.WriteCloser, error) {
+	if proxy.shared != nil {
+		return newSharedSession(proxy.sessions, client, proxy.shared), proxy.shared.writer(), nil
+	}
+
+	if group := multicastGroup(proxy.Upstream); group != nil {
+		ifi, err := proxy.multicastInterface()
+		if err != nil {
+			return nil, nil, err
+		}
+		s, err := newSession(proxy.sessions, client, func() (*net.UDPConn, error) {
+			return dialMulticast(group, ifi)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		s.group = group
+		return s, upstreamWriter{s}, nil
+	}
+
+	pool := proxy.upstreams
+	var index atomic.Int64
+	index.Store(int64(pool.pick(client)))
+	s, err := newSession(proxy.sessions, client, func() (*net.UDPConn, error) {
+		upstreamAddress, err := net.ResolveUDPAddr("udp", pool.addresses[index.Load()])
 		if err != nil {
-			proxy.Logger.Err(err).Str("client", proxy.Upstream).Msg("Unable to resolved upstream")
-			continue
+			return nil, err
+		}
+		return proxy.dialUpstream(client, upstreamAddress)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if pool.policy == PolicyFailover && len(pool.addresses) > 1 {
+		s.failover = func() error {
+			pool.markDown(int(index.Load()))
+			index.Store(int64(pool.pick(client)))
+			return s.Rebind()
 		}
-		upstream, err := net.DialUDP("udp", nil, upstreamAddress)
+	}
+	for _, mirror := range pool.mirrors() {
+		conn, err := proxy.dialMirror(client, mirror)
 		if err != nil {
-			proxy.Logger.Err(err).Str("client", proxy.Upstream).Msg("Unable to open connection to upstream")
-			continue
+			s.close()
+			return nil, nil, err
 		}
+		s.mirrors = append(s.mirrors, conn)
+	}
+	return s, upstreamWriter{s}, nil
+}
 
-		name := clientAddr.String()
-		proxy.connections.Lock()
-		proxy.connections.list[name+"upstream"] = upstream
-		proxy.connections.list[name+"downstream"] = proxy.listener
-		proxy.connections.Unlock()
-		proxy.Toxics.StartLink(proxy.apiServer, name+"upstream", clientReader, upstream, stream.Upstream)
-		proxy.Toxics.StartLink(proxy.apiServer, name+"downstream", upstream, clientWriter, stream.Downstream)
+// dialUpstream opens the upstream socket of a new client session. In transparent
+// mode it is bound to the client's address instead of an ephemeral port.
+func (proxy *Proxy) dialUpstream(client net.Addr, upstream *net.UDPAddr) (*net.UDPConn, error) {
+	if !proxy.Transparent {
+		return net.DialUDP("udp", nil, upstream)
+	}
+
+	dialer := net.Dialer{
+		LocalAddr: client,
+		Control:   transparentControl,
+	}
+	conn, err := dialer.Dial("udp", upstream.String())
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// dialMirror opens a socket of a client session to an upstream that gets copies
+// of the session's datagrams.
+func (proxy *Proxy) dialMirror(client net.Addr, mirror string) (*net.UDPConn, error) {
+	upstream, err := net.ResolveUDPAddr("udp", mirror)
+	if err != nil {
+		return nil, err
 	}
+	return proxy.dialUpstream(client, upstream)
 }
 
 func (proxy *Proxy) RemoveConnection(name string) {
@@ -269,12 +514,71 @@ func start(proxy *Proxy) error {
 	if proxy.Enabled {
 		return ErrProxyAlreadyStarted
 	}
+	proxy.shared = nil
+	upstreams, err := newUpstreamPool(proxy.Policy, append([]string{proxy.Upstream}, proxy.Upstreams...))
+	if err != nil {
+		return err
+	}
+	proxy.upstreams = upstreams
+	if proxy.Demultiplexer != "" {
+		demultiplexer := demux.New(proxy.Demultiplexer)
+		if demultiplexer == nil {
+			return ErrUnknownDemultiplexer
+		}
+		shared, err := newSharedUpstream(proxy.Upstream, demultiplexer, proxy.Logger)
+		if err != nil {
+			return err
+		}
+		if shared.addr.IP.IsMulticast() {
+			ifi, err := proxy.multicastInterface()
+			if err == nil {
+				err = publishMulticast(shared.conn, shared.addr, ifi)
+			}
+			if err != nil {
+				shared.close()
+				return err
+			}
+		}
+		proxy.shared = shared
+	} else if proxy.Transparent && !transparentSupported {
+		return ErrTransparentUnsupported
+	}
+
+	if proxy.Capture != "" {
+		if err := proxy.openCapture(); err != nil {
+			if proxy.shared != nil {
+				proxy.shared.close()
+				proxy.shared = nil
+			}
+			return err
+		}
+	}
+	if proxy.Record != "" {
+		if err := proxy.openRecording(); err != nil {
+			if proxy.shared != nil {
+				proxy.shared.close()
+				proxy.shared = nil
+			}
+			proxy.closeCapture()
+			return err
+		}
+	}
 
 	proxy.tomb = tomb.Tomb{} // Reset tomb, from previous starts/stops
+	proxy.configureSessions()
+	proxy.Toxics.SetSeed(proxy.Seed)
 	go proxy.server()
-	err := <-proxy.started
+	err = <-proxy.started
 	// Only enable the proxy if it successfully started
 	proxy.Enabled = err == nil
+	if err != nil {
+		if proxy.shared != nil {
+			proxy.shared.close()
+			proxy.shared = nil
+		}
+		proxy.closeCapture()
+		proxy.closeRecording()
+	}
 	return err
 }
 
@@ -288,11 +592,21 @@ func stop(proxy *Proxy) {
 	proxy.tomb.Killf("Shutting down from stop()")
 	proxy.tomb.Wait() // Wait until we stop accepting new connections
 
+	for _, s := range proxy.sessions.clear() {
+		s.close()
+	}
+	if proxy.shared != nil {
+		proxy.shared.close()
+	}
+	proxy.updateSessionMetrics()
+
 	proxy.connections.Lock()
 	defer proxy.connections.Unlock()
 	for _, conn := range proxy.connections.list {
 		conn.Close()
 	}
+	proxy.closeCapture()
+	proxy.closeRecording()
 
 	proxy.Logger.Info().Msg("Terminated proxy")
 }
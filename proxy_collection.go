@@ -98,6 +98,16 @@ func (collection *ProxyCollection) PopulateJson(
 
 	for i := range input {
 		proxy := NewProxy(server, input[i].Name, input[i].Listen, input[i].Upstream)
+		proxy.Upstreams = input[i].Upstreams
+		proxy.Policy = input[i].Policy
+		proxy.IdleTimeout = input[i].IdleTimeout
+		proxy.MaxSessions = input[i].MaxSessions
+		proxy.Seed = input[i].Seed
+		proxy.Transparent = input[i].Transparent
+		proxy.Demultiplexer = input[i].Demultiplexer
+		proxy.MulticastInterface = input[i].MulticastInterface
+		proxy.Capture = input[i].Capture
+		proxy.Record = input[i].Record
 		err = collection.AddOrReplace(proxy, *input[i].Enabled)
 		if err != nil {
 			return proxies, err
@@ -136,6 +146,7 @@ func (collection *ProxyCollection) Remove(name string) error {
 		return err
 	}
 	proxy.Stop()
+	proxy.Toxics.stopProfiles()
 
 	delete(collection.proxies, proxy.Name)
 	return nil
@@ -0,0 +1,942 @@
+package toxiproxy_test
+
+import (
+	"context"
+	"encoding/binary"
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"golang.org/x/net/ipv4"
+
+	toxiproxy "github.com/badrootd/udpcrusher"
+	"github.com/badrootd/udpcrusher/capture"
+	"github.com/badrootd/udpcrusher/replay"
+	"github.com/badrootd/udpcrusher/stream"
+	"github.com/badrootd/udpcrusher/testhelper"
+	"github.com/badrootd/udpcrusher/toxics"
+)
+
+func NewTestProxy(name, upstream string) *toxiproxy.Proxy {
+	log := zerolog.Nop()
+	if flag.Lookup("test.v").DefValue == "true" {
+		log = zerolog.New(os.Stdout).With().Caller().Timestamp().Logger()
+	}
+	srv := toxiproxy.NewServer(
+		toxiproxy.NewMetricsContainer(prometheus.NewRegistry()),
+		log,
+	)
+	proxy := toxiproxy.NewProxy(srv, name, "localhost:0", upstream)
+
+	return proxy
+}
+
+// AssertProxyUp checks whether a proxy is bound to addr. UDP has no handshake to
+// probe with, so a proxy is considered up when the address can't be bound.
+func AssertProxyUp(t *testing.T, addr string, up bool) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err == nil {
+		conn.Close()
+	}
+	if up && err == nil {
+		t.Error("Expected proxy to be up:", addr)
+	} else if !up && err != nil {
+		t.Error("Expected proxy to be down:", addr, err)
+	}
+}
+
+// AssertNewUpstreamClient waits for the upstream to see a datagram from a
+// source address it has not seen before.
+func AssertNewUpstreamClient(t *testing.T, upstream *testhelper.Upstream) net.Addr {
+	select {
+	case addr := <-upstream.Connections:
+		return addr
+	case <-time.After(time.Second):
+		t.Fatal("Upstream did not see a new client")
+	}
+	return nil
+}
+
+func TestProxyEvictsIdleSessions(t *testing.T) {
+	upstream := testhelper.NewUpstream(t, false)
+	defer upstream.Close()
+
+	proxy := NewTestProxy("test", upstream.Addr())
+	proxy.IdleTimeout = 100
+	proxy.Start()
+	defer proxy.Stop()
+
+	client, err := net.Dial("udp", proxy.Listen)
+	if err != nil {
+		t.Fatalf("Unable to dial UDP server: %v", err)
+	}
+	defer client.Close()
+
+	_, err = client.Write([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Unable to send UDP msg: %v", err)
+	}
+	first := AssertNewUpstreamClient(t, upstream)
+
+	if n := proxy.ActiveSessions(); n != 1 {
+		t.Fatalf("Expected 1 active session, got %d", n)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	if n := proxy.ActiveSessions(); n != 0 {
+		t.Fatalf("Expected idle session to be evicted, got %d active sessions", n)
+	}
+
+	// The client gets a fresh session with a new upstream socket
+	_, err = client.Write([]byte("hello again"))
+	if err != nil {
+		t.Fatalf("Unable to send UDP msg: %v", err)
+	}
+	second := AssertNewUpstreamClient(t, upstream)
+	if first.String() == second.String() {
+		t.Fatalf("Expected a new upstream socket, got %s again", second)
+	}
+}
+
+func TestProxyEvictsLeastRecentlyUsedSession(t *testing.T) {
+	upstream := testhelper.NewUpstream(t, false)
+	defer upstream.Close()
+
+	proxy := NewTestProxy("test", upstream.Addr())
+	proxy.MaxSessions = 2
+	proxy.Start()
+	defer proxy.Stop()
+
+	clients := make([]net.Conn, 3)
+	for i := range clients {
+		client, err := net.Dial("udp", proxy.Listen)
+		if err != nil {
+			t.Fatalf("Unable to dial UDP server: %v", err)
+		}
+		defer client.Close()
+		clients[i] = client
+
+		_, err = client.Write([]byte("hello"))
+		if err != nil {
+			t.Fatalf("Unable to send UDP msg: %v", err)
+		}
+		AssertNewUpstreamClient(t, upstream)
+	}
+
+	if n := proxy.ActiveSessions(); n != 2 {
+		t.Fatalf("Expected 2 active sessions, got %d", n)
+	}
+
+	// The first client was evicted, so it needs a new upstream socket
+	_, err := clients[0].Write([]byte("hello again"))
+	if err != nil {
+		t.Fatalf("Unable to send UDP msg: %v", err)
+	}
+	AssertNewUpstreamClient(t, upstream)
+
+	if n := proxy.ActiveSessions(); n != 2 {
+		t.Fatalf("Expected 2 active sessions, got %d", n)
+	}
+}
+
+func TestProxySharedUpstreamRoutesReplies(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer upstream.Close()
+
+	proxy := NewTestProxy("test", upstream.LocalAddr().String())
+	proxy.Demultiplexer = "dns"
+	if err := proxy.Start(); err != nil {
+		t.Fatalf("Unable to start proxy: %v", err)
+	}
+	defer proxy.Stop()
+
+	clients := make([]net.Conn, 2)
+	for i := range clients {
+		client, err := net.Dial("udp", proxy.Listen)
+		if err != nil {
+			t.Fatalf("Unable to dial UDP server: %v", err)
+		}
+		defer client.Close()
+		clients[i] = client
+
+		_, err = client.Write([]byte{0, byte(i), 'q'})
+		if err != nil {
+			t.Fatalf("Unable to send UDP msg: %v", err)
+		}
+	}
+
+	// Both clients arrive from the same upstream socket
+	var source net.Addr
+	buf := make([]byte, 16)
+	upstream.SetReadDeadline(time.Now().Add(time.Second))
+	for range clients {
+		_, addr, err := upstream.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Upstream did not receive: %v", err)
+		}
+		if source != nil && source.String() != addr.String() {
+			t.Fatalf("Expected a single upstream peer, got %s and %s", source, addr)
+		}
+		source = addr
+	}
+
+	// Reply in reverse order, every reply reaches the client that asked
+	for i := len(clients) - 1; i >= 0; i-- {
+		_, err = upstream.WriteTo([]byte{0, byte(i), 'r'}, source)
+		if err != nil {
+			t.Fatalf("Unable to reply: %v", err)
+		}
+	}
+	for i, client := range clients {
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatalf("Client %d got no reply: %v", i, err)
+		}
+		if n != 3 || buf[1] != byte(i) || buf[2] != 'r' {
+			t.Fatalf("Client %d got the wrong reply: %v", i, buf[:n])
+		}
+	}
+}
+
+func TestProxyBlackholeScheduleRestartsOnUpdate(t *testing.T) {
+	upstream := testhelper.NewUpstream(t, false)
+	defer upstream.Close()
+
+	proxy := NewTestProxy("test", upstream.Addr())
+	proxy.Start()
+	defer proxy.Stop()
+
+	_, err := proxy.Toxics.AddToxicJson(strings.NewReader(
+		`{"name":"blackhole","type":"blackhole","stream":"downstream","attributes":{"period":400,"down":200}}`))
+	if err != nil {
+		t.Fatalf("Unable to add toxic: %v", err)
+	}
+
+	client := DialAndSend(t, proxy.Listen, "hello")
+	defer client.Close()
+	upstreamClient := AssertNewUpstreamClient(t, upstream)
+
+	passes := func(msg string) bool {
+		_, err := upstream.Write([]byte(msg), upstreamClient)
+		if err != nil {
+			t.Fatalf("Unable to send UDP msg: %v", err)
+		}
+		buf := make([]byte, 100)
+		client.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		_, err = client.Read(buf)
+		return err == nil
+	}
+
+	// The first reply starts the schedule with a down window
+	start := time.Now()
+	if passes("down") {
+		t.Fatal("Expected the reply in the down window to be dropped")
+	}
+	time.Sleep(time.Until(start.Add(250 * time.Millisecond)))
+	if !passes("up") {
+		t.Fatal("Expected the reply in the up window to pass")
+	}
+
+	_, err = proxy.Toxics.UpdateToxicJson("blackhole", strings.NewReader(`{"attributes":{"down":300}}`))
+	if err != nil {
+		t.Fatalf("Unable to update toxic: %v", err)
+	}
+	if passes("restarted") {
+		t.Fatal("Expected the update to start the schedule over with a down window")
+	}
+}
+
+func TestProxyRebindsSession(t *testing.T) {
+	upstream := testhelper.NewUpstream(t, false)
+	defer upstream.Close()
+
+	proxy := NewTestProxy("test", upstream.Addr())
+	proxy.Start()
+	defer proxy.Stop()
+
+	rebind := &toxics.RebindToxic{}
+	err := proxy.Toxics.AddToxic(&toxics.ToxicWrapper{
+		Toxic:     rebind,
+		Name:      "rebind",
+		Type:      "rebind",
+		Direction: stream.Upstream,
+	})
+	if err != nil {
+		t.Fatalf("AddToxic returned error: %v", err)
+	}
+
+	client, err := net.Dial("udp", proxy.Listen)
+	if err != nil {
+		t.Fatalf("Unable to dial UDP server: %v", err)
+	}
+	defer client.Close()
+
+	_, err = client.Write([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Unable to send UDP msg: %v", err)
+	}
+	first := AssertNewUpstreamClient(t, upstream)
+
+	updated, err := proxy.Toxics.UpdateToxicJson("rebind", strings.NewReader(`{"attributes":{"trigger":1}}`))
+	if err != nil {
+		t.Fatalf("UpdateToxicJson returned error: %v", err)
+	}
+	// The update replaces the toxic, which restarts asynchronously
+	rebind = updated.Toxic.(*toxics.RebindToxic)
+	for start := time.Now(); rebind.Rebinds.Load() == 0; {
+		if time.Since(start) > time.Second {
+			t.Fatal("Expected the session to be rebound")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The same client now reaches the upstream from a new source port
+	_, err = client.Write([]byte("hello again"))
+	if err != nil {
+		t.Fatalf("Unable to send UDP msg: %v", err)
+	}
+	second := AssertNewUpstreamClient(t, upstream)
+	if first.String() == second.String() {
+		t.Fatalf("Expected a new source port, got %s again", second)
+	}
+	if n := proxy.ActiveSessions(); n != 1 {
+		t.Fatalf("Expected 1 active session, got %d", n)
+	}
+
+	// Replies to the new source port reach the client
+	_, err = upstream.Write([]byte("world"), second)
+	if err != nil {
+		t.Fatalf("Unable to reply: %v", err)
+	}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 16)
+	n, err := client.Read(buf)
+	if err != nil || string(buf[:n]) != "world" {
+		t.Fatalf("Expected reply world, got %q: %v", buf[:n], err)
+	}
+}
+
+// CountCapturedPackets counts the enhanced packet blocks of a pcapng file per
+// interface.
+// SeededLossPattern runs a seeded proxy with the given loss toxic on the replies
+// of a new client and returns which of the replies arrived. A non-empty update
+// is applied to the toxic halfway through.
+func SeededLossPattern(t *testing.T, seed int64, toxic, update string) (net.Addr, []string) {
+	upstream := testhelper.NewUpstream(t, false)
+	defer upstream.Close()
+
+	proxy := NewTestProxy("test", upstream.Addr())
+	proxy.Seed = seed
+	proxy.Start()
+	defer proxy.Stop()
+
+	_, err := proxy.Toxics.AddToxicJson(strings.NewReader(toxic))
+	if err != nil {
+		t.Fatalf("Unable to add toxic: %v", err)
+	}
+
+	client := DialAndSend(t, proxy.Listen, "hello")
+	defer client.Close()
+	upstreamClient := AssertNewUpstreamClient(t, upstream)
+
+	var received []string
+	buf := make([]byte, 100)
+	for half := 0; half < 2; half++ {
+		if half == 1 && update != "" {
+			_, err = proxy.Toxics.UpdateToxicJson("loss_downstream", strings.NewReader(update))
+			if err != nil {
+				t.Fatalf("Unable to update toxic: %v", err)
+			}
+		}
+		for i := half * 25; i < (half+1)*25; i++ {
+			_, err = upstream.Write([]byte(strconv.Itoa(i)), upstreamClient)
+			if err != nil {
+				t.Fatalf("Unable to send UDP msg: %v", err)
+			}
+			time.Sleep(2 * time.Millisecond)
+		}
+		for {
+			client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			n, err := client.Read(buf)
+			if err != nil {
+				break
+			}
+			received = append(received, string(buf[:n]))
+		}
+	}
+	return client.LocalAddr(), received
+}
+
+const seededLossToxic = `{"type":"loss","stream":"downstream","attributes":{"probability":0.5}}`
+
+func TestProxySeedReplaysAcrossClientPorts(t *testing.T) {
+	firstClient, first := SeededLossPattern(t, 42, seededLossToxic, "")
+	secondClient, second := SeededLossPattern(t, 42, seededLossToxic, "")
+
+	if len(first) == 0 || len(first) == 50 {
+		t.Fatalf("Expected some replies to be dropped, got %d of 50", len(first))
+	}
+	if strings.Join(first, ",") != strings.Join(second, ",") {
+		t.Fatalf("Expected clients %s and %s to see the same drops, got\n%v\n%v",
+			firstClient, secondClient, first, second)
+	}
+}
+
+// Updates that keep the seed, from the API or a profile, continue the random
+// sequence of a toxic instead of starting it over.
+func TestProxySeedSurvivesToxicUpdates(t *testing.T) {
+	_, expected := SeededLossPattern(t, 42, seededLossToxic, "")
+
+	_, updated := SeededLossPattern(t, 42, seededLossToxic, `{"attributes":{"probability":0.5}}`)
+	if strings.Join(updated, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected an update to keep the drops, got\n%v\n%v", expected, updated)
+	}
+
+	_, profiled := SeededLossPattern(t, 42, `{"type":"loss","stream":"downstream",
+		"attributes":{"probability":0.5},
+		"profile":{"interval":10,"points":[
+			{"time":0,"attributes":{"probability":0.5}},
+			{"time":10000,"attributes":{"probability":0.50001}}]}}`, "")
+	if strings.Join(profiled, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected profile ticks to keep the drops, got\n%v\n%v", expected, profiled)
+	}
+
+	_, reseeded := SeededLossPattern(t, 42, seededLossToxic, `{"attributes":{"seed":7}}`)
+	if strings.Join(reseeded, ",") == strings.Join(expected, ",") {
+		t.Fatal("Expected a new seed attribute to change the drops")
+	}
+}
+
+func CountCapturedPackets(t *testing.T, path string) map[capture.Interface]int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unable to read capture: %v", err)
+	}
+	counts := make(map[capture.Interface]int)
+	for len(data) >= 12 {
+		length := binary.LittleEndian.Uint32(data[4:])
+		if binary.LittleEndian.Uint32(data) == 6 {
+			counts[capture.Interface(binary.LittleEndian.Uint32(data[8:]))]++
+		}
+		data = data[length:]
+	}
+	return counts
+}
+
+func DialAndSend(t *testing.T, addr, msg string) net.Conn {
+	client, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("Unable to dial UDP server: %v", err)
+	}
+	_, err = client.Write([]byte(msg))
+	if err != nil {
+		t.Fatalf("Unable to send UDP msg: %v", err)
+	}
+	return client
+}
+
+func AssertNoNewUpstreamClient(t *testing.T, upstream *testhelper.Upstream) {
+	select {
+	case addr := <-upstream.Connections:
+		t.Fatalf("Expected no new client on upstream, got %s", addr)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestProxyRoundRobinUpstreams(t *testing.T) {
+	first := testhelper.NewUpstream(t, false)
+	defer first.Close()
+	second := testhelper.NewUpstream(t, false)
+	defer second.Close()
+
+	proxy := NewTestProxy("test", first.Addr())
+	proxy.Upstreams = []string{second.Addr()}
+	proxy.Start()
+	defer proxy.Stop()
+
+	for _, upstream := range []*testhelper.Upstream{first, second, first} {
+		client := DialAndSend(t, proxy.Listen, "hello")
+		defer client.Close()
+		AssertNewUpstreamClient(t, upstream)
+	}
+	AssertNoNewUpstreamClient(t, second)
+}
+
+func TestProxyHashUpstreams(t *testing.T) {
+	first := testhelper.NewUpstream(t, false)
+	defer first.Close()
+	second := testhelper.NewUpstream(t, false)
+	defer second.Close()
+
+	proxy := NewTestProxy("test", first.Addr())
+	proxy.Upstreams = []string{second.Addr()}
+	proxy.Policy = toxiproxy.PolicyHash
+	proxy.Start()
+	defer proxy.Stop()
+
+	// All sessions of the same client IP stick to one upstream
+	connections := make(chan net.Addr, 3)
+	for i := 0; i < 3; i++ {
+		client := DialAndSend(t, proxy.Listen, "hello")
+		defer client.Close()
+		select {
+		case addr := <-first.Connections:
+			connections <- addr
+		case <-second.Connections:
+			if len(connections) > 0 {
+				t.Fatal("Expected sessions of one client IP on the same upstream")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Upstreams did not see a new client")
+		}
+	}
+	if n := len(connections); n != 0 && n != 3 {
+		t.Fatalf("Expected sessions of one client IP on the same upstream, got %d on the first", n)
+	}
+}
+
+func TestProxyFailoverUpstreams(t *testing.T) {
+	// Nothing listens on the primary, it refuses datagrams
+	conn, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Unable to reserve a port: %v", err)
+	}
+	primary := conn.LocalAddr().String()
+	conn.Close()
+
+	standby := testhelper.NewUpstream(t, false)
+	defer standby.Close()
+
+	proxy := NewTestProxy("test", primary)
+	proxy.Upstreams = []string{standby.Addr()}
+	proxy.Policy = toxiproxy.PolicyFailover
+	proxy.Start()
+	defer proxy.Stop()
+
+	client := DialAndSend(t, proxy.Listen, "lost")
+	defer client.Close()
+
+	// The first datagram is refused by the primary, the session moves over
+	var upstreamClient net.Addr
+	for start := time.Now(); upstreamClient == nil; {
+		_, err = client.Write([]byte("hello"))
+		if err != nil {
+			t.Fatalf("Unable to send UDP msg: %v", err)
+		}
+		select {
+		case upstreamClient = <-standby.Connections:
+		case <-time.After(10 * time.Millisecond):
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("Session did not fail over to the standby upstream")
+		}
+	}
+
+	_, err = standby.Write([]byte("reply"), upstreamClient)
+	if err != nil {
+		t.Fatalf("Unable to send UDP msg: %v", err)
+	}
+	buf := make([]byte, 100)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := client.Read(buf)
+	if err != nil || string(buf[:n]) != "reply" {
+		t.Fatalf("Expected reply of the standby upstream, got %q, %v", buf[:n], err)
+	}
+
+	// New sessions skip the primary right away
+	other := DialAndSend(t, proxy.Listen, "hello")
+	defer other.Close()
+	AssertNewUpstreamClient(t, standby)
+}
+
+func TestProxyMirrorUpstreams(t *testing.T) {
+	primary := testhelper.NewUpstream(t, false)
+	defer primary.Close()
+	shadow := testhelper.NewUpstream(t, false)
+	defer shadow.Close()
+
+	proxy := NewTestProxy("test", primary.Addr())
+	proxy.Upstreams = []string{shadow.Addr()}
+	proxy.Policy = toxiproxy.PolicyMirror
+	proxy.Start()
+	defer proxy.Stop()
+
+	client := DialAndSend(t, proxy.Listen, "query")
+	defer client.Close()
+	primaryClient := AssertNewUpstreamClient(t, primary)
+	shadowClient := AssertNewUpstreamClient(t, shadow)
+
+	buf := make([]byte, 100)
+	for _, upstream := range []struct {
+		*testhelper.Upstream
+		client net.Addr
+	}{{primary, primaryClient}, {shadow, shadowClient}} {
+		query := make([]byte, 5)
+		upstream.ReadAtLeast(upstream.client, query, len(query))
+		if string(query) != "query" {
+			t.Fatalf("Expected query on every upstream, got %q", query)
+		}
+	}
+
+	// Only the primary's replies return
+	_, err := shadow.Write([]byte("shadow reply"), shadowClient)
+	if err != nil {
+		t.Fatalf("Unable to send UDP msg: %v", err)
+	}
+	_, err = primary.Write([]byte("reply"), primaryClient)
+	if err != nil {
+		t.Fatalf("Unable to send UDP msg: %v", err)
+	}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := client.Read(buf)
+	if err != nil || string(buf[:n]) != "reply" {
+		t.Fatalf("Expected reply of the primary upstream, got %q, %v", buf[:n], err)
+	}
+	client.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, err := client.Read(buf); err == nil {
+		t.Fatalf("Expected no further replies, got %q", buf[:n])
+	}
+}
+
+func TestToxicSelector(t *testing.T) {
+	upstream := testhelper.NewUpstream(t, false)
+	defer upstream.Close()
+
+	proxy := NewTestProxy("test", upstream.Addr())
+	proxy.Start()
+	defer proxy.Stop()
+
+	impaired := DialAndSend(t, proxy.Listen, "hello")
+	defer impaired.Close()
+	impairedUpstream := AssertNewUpstreamClient(t, upstream)
+	healthy := DialAndSend(t, proxy.Listen, "hello")
+	defer healthy.Close()
+	healthyUpstream := AssertNewUpstreamClient(t, upstream)
+
+	_, err := proxy.Toxics.AddToxicJson(strings.NewReader(
+		`{"type":"blackhole","stream":"downstream","selector":{"session":"` +
+			impaired.LocalAddr().String() + `"}}`))
+	if err != nil {
+		t.Fatalf("Unable to add toxic: %v", err)
+	}
+
+	// Links of new sessions are scoped as well
+	late := DialAndSend(t, proxy.Listen, "hello")
+	defer late.Close()
+	lateUpstream := AssertNewUpstreamClient(t, upstream)
+
+	buf := make([]byte, 100)
+	for _, client := range []struct {
+		conn     net.Conn
+		upstream net.Addr
+		affected bool
+	}{
+		{impaired, impairedUpstream, true},
+		{healthy, healthyUpstream, false},
+		{late, lateUpstream, false},
+	} {
+		_, err = upstream.Write([]byte("reply"), client.upstream)
+		if err != nil {
+			t.Fatalf("Unable to send UDP msg: %v", err)
+		}
+		client.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, err := client.conn.Read(buf)
+		if client.affected && err == nil {
+			t.Fatalf("Expected reply to %s to be dropped", client.conn.LocalAddr())
+		}
+		if !client.affected && err != nil {
+			t.Fatalf("Expected reply to %s, got %v", client.conn.LocalAddr(), err)
+		}
+	}
+
+	// Without a selector the toxic applies to every client
+	_, err = proxy.Toxics.UpdateToxicJson("blackhole_downstream", strings.NewReader(`{"selector":null}`))
+	if err != nil {
+		t.Fatalf("Unable to update toxic: %v", err)
+	}
+	_, err = upstream.Write([]byte("reply"), healthyUpstream)
+	if err != nil {
+		t.Fatalf("Unable to send UDP msg: %v", err)
+	}
+	healthy.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := healthy.Read(buf); err == nil {
+		t.Fatal("Expected reply to be dropped once the selector was removed")
+	}
+
+	_, err = proxy.Toxics.AddToxicJson(strings.NewReader(
+		`{"type":"blackhole","name":"invalid","selector":{"source":"10.0.0.0/33"}}`))
+	if err == nil || !strings.Contains(err.Error(), "invalid toxic selector") {
+		t.Fatalf("Expected an invalid toxic selector error, got %v", err)
+	}
+}
+
+func TestProxyCapture(t *testing.T) {
+	upstream := testhelper.NewUpstream(t, false)
+	defer upstream.Close()
+
+	proxy := NewTestProxy("test", upstream.Addr())
+	proxy.Start()
+	defer proxy.Stop()
+
+	path := filepath.Join(t.TempDir(), "test.pcapng")
+	err := proxy.StartCapture(path)
+	if err != nil {
+		t.Fatalf("Unable to start capture: %v", err)
+	}
+	if err := proxy.StartCapture(path); err != toxiproxy.ErrCaptureAlreadyStarted {
+		t.Fatalf("Expected capture to be started already, got %v", err)
+	}
+
+	_, err = proxy.Toxics.AddToxicJson(strings.NewReader(
+		`{"type":"loss","stream":"upstream","attributes":{"model":"nth","nth":2}}`))
+	if err != nil {
+		t.Fatalf("Unable to add toxic: %v", err)
+	}
+
+	client, err := net.Dial("udp", proxy.Listen)
+	if err != nil {
+		t.Fatalf("Unable to dial UDP server: %v", err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 4; i++ {
+		_, err = client.Write([]byte("hello"))
+		if err != nil {
+			t.Fatalf("Unable to send UDP msg: %v", err)
+		}
+	}
+	AssertNewUpstreamClient(t, upstream)
+
+	// Dropped datagrams are only seen on ingress
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		counts := CountCapturedPackets(t, path)
+		if counts[capture.Ingress] == 4 && counts[capture.Egress] == 2 {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatalf("Expected 4 ingress and 2 egress packets, got %v", counts)
+		}
+	}
+
+	err = proxy.StopCapture()
+	if err != nil {
+		t.Fatalf("Unable to stop capture: %v", err)
+	}
+	_, err = client.Write([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Unable to send UDP msg: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if counts := CountCapturedPackets(t, path); counts[capture.Ingress] != 4 {
+		t.Fatalf("Expected no packets after stopping the capture, got %v", counts)
+	}
+}
+
+// loopbackMulticast returns the loopback interface and a free port, or skips
+// the test if multicast doesn't work on it.
+func loopbackMulticast(t *testing.T) (*net.Interface, int) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		t.Skip("Unable to list interfaces:", err)
+	}
+	for i := range interfaces {
+		if interfaces[i].Flags&net.FlagLoopback == 0 || interfaces[i].Flags&net.FlagUp == 0 {
+			continue
+		}
+		conn, err := net.ListenMulticastUDP("udp", &interfaces[i], &net.UDPAddr{IP: net.IPv4(239, 0, 0, 1)})
+		if err != nil {
+			t.Skip("Multicast is not supported on the loopback interface:", err)
+		}
+		port := conn.LocalAddr().(*net.UDPAddr).Port
+		conn.Close()
+		return &interfaces[i], port
+	}
+	t.Skip("No loopback interface")
+	return nil, 0
+}
+
+func TestProxyMulticast(t *testing.T) {
+	lo, listenPort := loopbackMulticast(t)
+	_, upstreamPort := loopbackMulticast(t)
+	listenGroup := &net.UDPAddr{IP: net.IPv4(239, 0, 0, 1), Port: listenPort}
+	upstreamGroup := &net.UDPAddr{IP: net.IPv4(239, 0, 0, 2), Port: upstreamPort}
+
+	receiver, err := net.ListenMulticastUDP("udp", lo, upstreamGroup)
+	if err != nil {
+		t.Fatalf("Unable to join upstream group: %v", err)
+	}
+	defer receiver.Close()
+
+	proxy := NewTestProxy("test", upstreamGroup.String())
+	proxy.Listen = listenGroup.String()
+	proxy.MulticastInterface = lo.Name
+	err = proxy.Start()
+	if err != nil {
+		t.Fatalf("Unable to start proxy: %v", err)
+	}
+	defer proxy.Stop()
+	if proxy.Listen != listenGroup.String() {
+		t.Fatalf("Expected proxy to listen on %s, got %s", listenGroup, proxy.Listen)
+	}
+
+	_, err = proxy.Toxics.AddToxicJson(strings.NewReader(
+		`{"type":"loss","stream":"upstream","attributes":{"model":"nth","nth":2}}`))
+	if err != nil {
+		t.Fatalf("Unable to add toxic: %v", err)
+	}
+
+	publisher, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		t.Fatalf("Unable to open publisher: %v", err)
+	}
+	defer publisher.Close()
+	p := ipv4.NewPacketConn(publisher)
+	if err := p.SetMulticastInterface(lo); err != nil {
+		t.Fatalf("Unable to set multicast interface: %v", err)
+	}
+	if err := p.SetMulticastLoopback(true); err != nil {
+		t.Fatalf("Unable to enable multicast loopback: %v", err)
+	}
+
+	for _, msg := range []string{"data 1", "data 2", "data 3"} {
+		_, err = publisher.WriteTo([]byte(msg), listenGroup)
+		if err != nil {
+			t.Fatalf("Unable to publish: %v", err)
+		}
+	}
+
+	// Every second datagram is lost on the way to the upstream group
+	buf := make([]byte, 100)
+	var source net.Addr
+	for _, expected := range []string{"data 1", "data 3"} {
+		receiver.SetReadDeadline(time.Now().Add(time.Second))
+		n, addr, err := receiver.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Unable to receive from upstream group: %v", err)
+		}
+		if string(buf[:n]) != expected {
+			t.Fatalf("Expected %q, got %q", expected, buf[:n])
+		}
+		source = addr
+	}
+
+	// Unicast control traffic of the receiver is routed back to the publisher
+	_, err = receiver.WriteTo([]byte("nak"), source)
+	if err != nil {
+		t.Fatalf("Unable to send NAK: %v", err)
+	}
+	publisher.SetReadDeadline(time.Now().Add(time.Second))
+	n, addr, err := publisher.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Unable to receive NAK: %v", err)
+	}
+	if string(buf[:n]) != "nak" {
+		t.Fatalf("Expected NAK, got %q", buf[:n])
+	}
+	if addr.(*net.UDPAddr).Port != listenPort {
+		t.Fatalf("Expected NAK from the listen port %d, got %s", listenPort, addr)
+	}
+}
+
+func TestProxyRecord(t *testing.T) {
+	upstream := testhelper.NewUpstream(t, false)
+	defer upstream.Close()
+
+	proxy := NewTestProxy("test", upstream.Addr())
+	proxy.IdleTimeout = 100
+	proxy.Start()
+	defer proxy.Stop()
+
+	path := filepath.Join(t.TempDir(), "test.jsonl")
+	err := proxy.StartRecording(path)
+	if err != nil {
+		t.Fatalf("Unable to start recording: %v", err)
+	}
+	if err := proxy.StartRecording(path); err != toxiproxy.ErrRecordingAlreadyStarted {
+		t.Fatalf("Expected recording to be started already, got %v", err)
+	}
+
+	client, err := net.Dial("udp", proxy.Listen)
+	if err != nil {
+		t.Fatalf("Unable to dial UDP server: %v", err)
+	}
+	defer client.Close()
+
+	_, err = client.Write([]byte("query"))
+	if err != nil {
+		t.Fatalf("Unable to send UDP msg: %v", err)
+	}
+	clientAddr := AssertNewUpstreamClient(t, upstream)
+	time.Sleep(20 * time.Millisecond)
+	_, err = upstream.Write([]byte("reply"), clientAddr)
+	if err != nil {
+		t.Fatalf("Unable to send UDP msg: %v", err)
+	}
+	buf := make([]byte, 100)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(buf); err != nil {
+		t.Fatalf("Unable to read reply: %v", err)
+	}
+
+	// The client returns on the same port after its session was evicted
+	time.Sleep(300 * time.Millisecond)
+	_, err = client.Write([]byte("query again"))
+	if err != nil {
+		t.Fatalf("Unable to send UDP msg: %v", err)
+	}
+	AssertNewUpstreamClient(t, upstream)
+
+	err = proxy.StopRecording()
+	if err != nil {
+		t.Fatalf("Unable to stop recording: %v", err)
+	}
+
+	recording, err := replay.Load(path)
+	if err != nil {
+		t.Fatalf("Unable to load recording: %v", err)
+	}
+	if len(recording.Sessions) != 2 || len(recording.Sessions[0].Datagrams) != 2 ||
+		len(recording.Sessions[1].Datagrams) != 1 {
+		t.Fatalf("Expected sessions with 2 and 1 datagrams, got %+v", recording.Sessions)
+	}
+	session := recording.Sessions[0]
+	for _, s := range recording.Sessions {
+		if s.Client != client.LocalAddr().String() {
+			t.Fatalf("Expected session of %s, got %s", client.LocalAddr(), s.Client)
+		}
+	}
+	if session.ID == recording.Sessions[1].ID {
+		t.Fatalf("Expected the returning client to get a new session, got %s twice", session.ID)
+	}
+	reply := session.Datagrams[1]
+	if reply.Direction != stream.Downstream || string(reply.Data) != "reply" ||
+		reply.Offset < 20*time.Millisecond {
+		t.Fatalf("Expected the reply after 20ms, got %+v", reply)
+	}
+
+	// The recording stands in for the upstream
+	fake, err := replay.ListenUpstream(recording, "localhost:0")
+	if err != nil {
+		t.Fatalf("Unable to replay upstream: %v", err)
+	}
+	defer fake.Close()
+	received, err := replay.ReplayClient(context.Background(), session, fake.Addr(), 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Unable to replay client: %v", err)
+	}
+	if len(received) != 1 || string(received[0].Data) != "reply" {
+		t.Fatalf("Expected the recorded reply, got %+v", received)
+	}
+}
@@ -0,0 +1,91 @@
+//go:build linux
+
+package toxiproxy_test
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/badrootd/udpcrusher/testhelper"
+)
+
+// listenReusable binds a client socket that the transparent upstream socket of
+// its session can share.
+func listenReusable(t *testing.T) net.PacketConn {
+	config := net.ListenConfig{
+		Control: func(network, address string, conn syscall.RawConn) error {
+			var err error
+			conn.Control(func(fd uintptr) {
+				err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+			})
+			return err
+		},
+	}
+	conn, err := config.ListenPacket(context.Background(), "udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	return conn
+}
+
+func skipWithoutTransparent(t *testing.T) {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		t.Fatalf("Unable to create socket: %v", err)
+	}
+	defer unix.Close(fd)
+	if unix.SetsockoptInt(fd, unix.SOL_IP, unix.IP_TRANSPARENT, 1) != nil {
+		t.Skip("Transparent sockets require CAP_NET_ADMIN")
+	}
+}
+
+func TestTransparentProxyPreservesClientAddress(t *testing.T) {
+	skipWithoutTransparent(t)
+
+	upstream := testhelper.NewUpstream(t, false)
+	defer upstream.Close()
+
+	proxy := NewTestProxy("test", upstream.Addr())
+	proxy.Transparent = true
+	if err := proxy.Start(); err != nil {
+		t.Fatalf("Unable to start proxy: %v", err)
+	}
+	defer proxy.Stop()
+
+	client := listenReusable(t)
+	defer client.Close()
+
+	proxyAddr, err := net.ResolveUDPAddr("udp", proxy.Listen)
+	if err != nil {
+		t.Fatalf("Unable to resolve proxy: %v", err)
+	}
+	_, err = client.WriteTo([]byte("hello"), proxyAddr)
+	if err != nil {
+		t.Fatalf("Unable to send UDP msg: %v", err)
+	}
+
+	seen := AssertNewUpstreamClient(t, upstream)
+	if seen.String() != client.LocalAddr().String() {
+		t.Fatalf("Expected upstream to see %s, got %s", client.LocalAddr(), seen)
+	}
+
+	// The reply to the original client address makes it back through the proxy
+	_, err = upstream.Write([]byte("world"), seen)
+	if err != nil {
+		t.Fatalf("Unable to reply: %v", err)
+	}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 16)
+	n, from, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("No reply from proxy: %v", err)
+	}
+	if string(buf[:n]) != "world" || from.String() != proxyAddr.String() {
+		t.Fatalf("Expected world from %s, got %q from %s", proxyAddr, buf[:n], from)
+	}
+}
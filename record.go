@@ -0,0 +1,90 @@
+package toxiproxy
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/badrootd/udpcrusher/replay"
+	"github.com/badrootd/udpcrusher/stream"
+)
+
+// recordWriter records every datagram written through it while the proxy is
+// recording. It sits right in front of the link's input, so the timestamp is
+// the one the datagram's StreamChunk gets.
+type recordWriter struct {
+	io.Writer
+	proxy     *Proxy
+	session   string
+	client    string
+	direction stream.Direction
+}
+
+func (w *recordWriter) Write(p []byte) (int, error) {
+	if recorder := w.proxy.recorder.Load(); recorder != nil {
+		err := recorder.Record(w.session, w.client, w.direction, time.Now(), p)
+		if err != nil && err != replay.ErrClosed {
+			w.proxy.Logger.Warn().Err(err).Str("record", w.proxy.Record).Msg("Could not record datagram")
+		}
+	}
+	return w.Writer.Write(p)
+}
+
+// StartRecording records the datagrams of every client session to a file at
+// path, as they are read by the proxy before the toxics. The recording can be
+// replayed with the replay package.
+func (proxy *Proxy) StartRecording(path string) error {
+	proxy.Lock()
+	defer proxy.Unlock()
+
+	if proxy.Record != "" {
+		return ErrRecordingAlreadyStarted
+	}
+	proxy.Record = path
+	if proxy.Enabled {
+		if err := proxy.openRecording(); err != nil {
+			proxy.Record = ""
+			return err
+		}
+	}
+	return nil
+}
+
+// StopRecording stops recording datagrams and closes the recording file.
+func (proxy *Proxy) StopRecording() error {
+	proxy.Lock()
+	defer proxy.Unlock()
+
+	if proxy.Record == "" {
+		return ErrRecordingNotStarted
+	}
+	proxy.closeRecording()
+	proxy.Record = ""
+	return nil
+}
+
+// openRecording opens the recording file, assumes the lock has already been
+// taken.
+func (proxy *Proxy) openRecording() error {
+	recorder, err := replay.Create(proxy.Record)
+	if errors.Is(err, replay.ErrOutsideDir) {
+		return joinError(err, ErrInvalidRecordPath)
+	} else if err != nil {
+		return err
+	}
+	proxy.recorder.Store(recorder)
+	proxy.Logger.Info().Str("record", proxy.Record).Msg("Started recording")
+	return nil
+}
+
+// closeRecording closes the recording file, if any, assumes the lock has
+// already been taken.
+func (proxy *Proxy) closeRecording() {
+	recorder := proxy.recorder.Swap(nil)
+	if recorder == nil {
+		return
+	}
+	if err := recorder.Close(); err != nil {
+		proxy.Logger.Warn().Err(err).Str("record", proxy.Record).Msg("Could not close recording")
+	}
+}
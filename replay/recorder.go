@@ -0,0 +1,180 @@
+// Package replay records the datagrams of client sessions and replays them
+// later, acting as a fake upstream or a fake client with the original timing.
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/badrootd/udpcrusher/stream"
+)
+
+var (
+	ErrClosed     = errors.New("recorder is closed")
+	ErrOutsideDir = errors.New("outside of the recording directory")
+)
+
+// Dir restricts recording files to a directory, recording paths are relative
+// to it then. Empty allows any path on the server.
+var Dir string
+
+// A record is one line of a recording file.
+type record struct {
+	Session   string    `json:"session"`
+	Client    string    `json:"client"`
+	Direction string    `json:"direction"`
+	Time      time.Time `json:"time"`
+	Data      []byte    `json:"data"`
+}
+
+// A Recorder writes datagrams as JSON lines. It is safe for concurrent use.
+type Recorder struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+// Create opens a recording file for writing, resolved against Dir. An existing
+// file is truncated, so a file always holds a single recording.
+func Create(path string) (*Recorder, error) {
+	if Dir != "" {
+		if !filepath.IsLocal(path) {
+			return nil, fmt.Errorf("recording %s is %w", path, ErrOutsideDir)
+		}
+		path = filepath.Join(Dir, path)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	recorder := NewRecorder(file)
+	recorder.closer = file
+	return recorder, nil
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{encoder: json.NewEncoder(w)}
+}
+
+// Record stores a datagram of a session of client, received at timestamp and
+// travelling in direction. A client that returns after its session ended gets
+// a new session.
+func (r *Recorder) Record(session, client string, direction stream.Direction, timestamp time.Time, data []byte) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.encoder == nil {
+		return ErrClosed
+	}
+	return r.encoder.Encode(record{
+		Session:   session,
+		Client:    client,
+		Direction: direction.String(),
+		Time:      timestamp,
+		Data:      data,
+	})
+}
+
+// Close stops recording and closes the file opened by Create.
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.encoder = nil
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+	return nil
+}
+
+// A Datagram of a recorded session.
+type Datagram struct {
+	Direction stream.Direction
+	// Time since the first datagram of the session
+	Offset time.Duration
+	Data   []byte
+}
+
+// A Session holds the datagrams of one client in the order they were recorded.
+type Session struct {
+	ID        string
+	Client    string
+	Datagrams []Datagram
+}
+
+// A Recording holds the recorded sessions in the order they started.
+type Recording struct {
+	Sessions []*Session
+}
+
+// Load reads a recording file.
+func Load(path string) (*Recording, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	recording, err := Read(file)
+	if err != nil {
+		return nil, fmt.Errorf("recording %s: %w", path, err)
+	}
+	return recording, nil
+}
+
+// Read reads a recording from r.
+func Read(r io.Reader) (*Recording, error) {
+	recording := &Recording{}
+	sessions := make(map[string]*Session)
+	starts := make(map[*Session]time.Time)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*stream.MaxDatagramSize)
+	for scanner.Scan() {
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, err
+		}
+		direction, err := stream.ParseDirection(rec.Direction)
+		if err != nil {
+			return nil, err
+		}
+
+		session, ok := sessions[rec.Session]
+		if !ok {
+			session = &Session{ID: rec.Session, Client: rec.Client}
+			sessions[rec.Session] = session
+			starts[session] = rec.Time
+			recording.Sessions = append(recording.Sessions, session)
+		}
+		session.Datagrams = append(session.Datagrams, Datagram{
+			Direction: direction,
+			Offset:    rec.Time.Sub(starts[session]),
+			Data:      rec.Data,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Datagrams of both directions are recorded concurrently, so the lines
+	// are only roughly in order
+	for _, session := range recording.Sessions {
+		sort.SliceStable(session.Datagrams, func(i, j int) bool {
+			return session.Datagrams[i].Offset < session.Datagrams[j].Offset
+		})
+		start := session.Datagrams[0].Offset
+		for i := range session.Datagrams {
+			session.Datagrams[i].Offset -= start
+		}
+	}
+	return recording, nil
+}
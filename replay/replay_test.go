@@ -0,0 +1,245 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/badrootd/udpcrusher/stream"
+)
+
+func TestRecorderRoundTrip(t *testing.T) {
+	var buffer bytes.Buffer
+	recorder := NewRecorder(&buffer)
+
+	start := time.Unix(1700000000, 0)
+	records := []struct {
+		session   string
+		client    string
+		direction stream.Direction
+		offset    time.Duration
+		data      string
+	}{
+		{"1", "a", stream.Upstream, 0, "query a"},
+		{"2", "b", stream.Upstream, 5 * time.Millisecond, "query b"},
+		// Recorded out of order by the two links of a session
+		{"1", "a", stream.Downstream, 30 * time.Millisecond, "second reply a"},
+		{"1", "a", stream.Downstream, 20 * time.Millisecond, "reply a"},
+		{"2", "b", stream.Downstream, 15 * time.Millisecond, "reply b"},
+		// The same client returning after its session ended
+		{"3", "a", stream.Upstream, 40 * time.Millisecond, "query a again"},
+	}
+	for _, r := range records {
+		err := recorder.Record(r.session, r.client, r.direction, start.Add(r.offset), []byte(r.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	recorder.Close()
+	if err := recorder.Record("1", "a", stream.Upstream, start, nil); err != ErrClosed {
+		t.Fatal("Expected recorder to be closed, got", err)
+	}
+
+	recording, err := Read(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recording.Sessions) != 3 {
+		t.Fatalf("Expected 3 sessions, got %d", len(recording.Sessions))
+	}
+
+	a := recording.Sessions[0]
+	expected := []Datagram{
+		{stream.Upstream, 0, []byte("query a")},
+		{stream.Downstream, 20 * time.Millisecond, []byte("reply a")},
+		{stream.Downstream, 30 * time.Millisecond, []byte("second reply a")},
+	}
+	if a.ID != "1" || a.Client != "a" || len(a.Datagrams) != len(expected) {
+		t.Fatalf("Unexpected session %+v", a)
+	}
+	for i, d := range a.Datagrams {
+		if d.Direction != expected[i].Direction || d.Offset != expected[i].Offset ||
+			!bytes.Equal(d.Data, expected[i].Data) {
+			t.Fatalf("Expected datagram %d to be %+v, got %+v", i, expected[i], d)
+		}
+	}
+
+	b := recording.Sessions[1]
+	if b.Client != "b" || b.Datagrams[1].Offset != 10*time.Millisecond {
+		t.Fatalf("Expected offsets relative to the session start, got %+v", b)
+	}
+	if again := recording.Sessions[2]; again.Client != "a" || len(again.Datagrams) != 1 {
+		t.Fatalf("Expected the returning client in a session of its own, got %+v", again)
+	}
+}
+
+func TestCreateTruncates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.jsonl")
+	for _, client := range []string{"a", "b"} {
+		recorder, err := Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := recorder.Record("1", client, stream.Upstream, time.Now(), []byte("query")); err != nil {
+			t.Fatal(err)
+		}
+		recorder.Close()
+	}
+
+	recording, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recording.Sessions) != 1 || recording.Sessions[0].Client != "b" {
+		t.Fatalf("Expected only the session of the last recording, got %+v", recording.Sessions)
+	}
+}
+
+func TestCreateDir(t *testing.T) {
+	Dir = t.TempDir()
+	defer func() { Dir = "" }()
+
+	recorder, err := Create("test.jsonl")
+	if err != nil {
+		t.Fatal("Failed to create recording in the recording directory:", err)
+	}
+	recorder.Close()
+	if _, err := os.Stat(filepath.Join(Dir, "test.jsonl")); err != nil {
+		t.Fatal("Recording was not created in the recording directory:", err)
+	}
+
+	for _, path := range []string{"../test.jsonl", filepath.Join(Dir, "test.jsonl")} {
+		if _, err := Create(path); !errors.Is(err, ErrOutsideDir) {
+			t.Errorf("Expected recording %s outside of the recording directory to be rejected, got %v", path, err)
+		}
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	_, err := Load(filepath.Join(t.TempDir(), "missing.jsonl"))
+	if err == nil {
+		t.Fatal("Expected an error for a missing recording")
+	}
+}
+
+func testRecording() *Recording {
+	return &Recording{Sessions: []*Session{
+		{Client: "a", Datagrams: []Datagram{
+			{stream.Upstream, 0, []byte("query a")},
+			{stream.Downstream, 50 * time.Millisecond, []byte("reply a")},
+		}},
+		{Client: "b", Datagrams: []Datagram{
+			{stream.Upstream, 0, []byte("query b")},
+			{stream.Downstream, 10 * time.Millisecond, []byte("reply b")},
+			{stream.Downstream, 60 * time.Millisecond, []byte("second reply b")},
+		}},
+	}}
+}
+
+func TestUpstreamMatchesSessions(t *testing.T) {
+	upstream, err := ListenUpstream(testRecording(), "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+
+	conn, err := net.Dial("udp", upstream.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sent := time.Now()
+	if _, err := conn.Write([]byte("query b")); err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := make([]byte, 100)
+	for _, expected := range []struct {
+		data  string
+		delay time.Duration
+	}{
+		{"reply b", 10 * time.Millisecond},
+		{"second reply b", 60 * time.Millisecond},
+	} {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buffer[:n]) != expected.data {
+			t.Fatalf("Expected %q, got %q", expected.data, buffer[:n])
+		}
+		if delay := time.Since(sent); delay < expected.delay {
+			t.Fatalf("Expected %q after %s, got it after %s", expected.data, expected.delay, delay)
+		}
+	}
+}
+
+func TestUpstreamFallsBackToNextSession(t *testing.T) {
+	upstream, err := ListenUpstream(testRecording(), "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+
+	buffer := make([]byte, 100)
+	// Unknown queries get the sessions in order, and again once all are used
+	for _, expected := range []string{"reply a", "reply b", "reply a"} {
+		conn, err := net.Dial("udp", upstream.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write([]byte("unknown")); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buffer)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buffer[:n]) != expected {
+			t.Fatalf("Expected %q, got %q", expected, buffer[:n])
+		}
+	}
+}
+
+func TestReplayClient(t *testing.T) {
+	upstream, err := ListenUpstream(testRecording(), "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+
+	start := time.Now()
+	received, err := ReplayClient(context.Background(), testRecording().Sessions[1], upstream.Addr(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Fatalf("Expected the replay to last as long as the session, took %s", elapsed)
+	}
+	if len(received) < 1 || string(received[0].Data) != "reply b" ||
+		received[0].Direction != stream.Downstream || received[0].Offset < 10*time.Millisecond {
+		t.Fatalf("Expected the recorded reply, got %+v", received)
+	}
+}
+
+func TestReplayClientCanceled(t *testing.T) {
+	session := &Session{Datagrams: []Datagram{
+		{stream.Upstream, time.Hour, []byte("never")},
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := ReplayClient(ctx, session, "localhost:9", 0)
+	if err != context.DeadlineExceeded {
+		t.Fatal("Expected the replay to be canceled, got", err)
+	}
+}
@@ -0,0 +1,198 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/badrootd/udpcrusher/stream"
+)
+
+// An Upstream acts as the upstream of recorded sessions. Every new client is
+// assigned an unused session, preferably one whose first datagram matches
+// what the client sent. Each recorded reply is sent with its recorded delay
+// after the client datagram that preceded it.
+type Upstream struct {
+	conn      net.PacketConn
+	recording *Recording
+	done      chan struct{}
+
+	mutex   sync.Mutex
+	clients map[string]chan []byte
+	used    map[*Session]bool
+	next    int
+}
+
+// ListenUpstream serves the recording on addr.
+func ListenUpstream(recording *Recording, addr string) (*Upstream, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	u := &Upstream{
+		conn:      conn,
+		recording: recording,
+		done:      make(chan struct{}),
+		clients:   make(map[string]chan []byte),
+		used:      make(map[*Session]bool),
+	}
+	go u.run()
+	return u, nil
+}
+
+func (u *Upstream) Addr() string {
+	return u.conn.LocalAddr().String()
+}
+
+func (u *Upstream) Close() error {
+	close(u.done)
+	return u.conn.Close()
+}
+
+func (u *Upstream) run() {
+	buffer := make([]byte, stream.MaxDatagramSize)
+	for {
+		n, client, err := u.conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		data := make([]byte, n)
+		copy(data, buffer[:n])
+
+		u.mutex.Lock()
+		incoming, ok := u.clients[client.String()]
+		if !ok {
+			session := u.assign(data)
+			if session == nil {
+				u.mutex.Unlock()
+				continue
+			}
+			incoming = make(chan []byte, 1024)
+			u.clients[client.String()] = incoming
+			go u.serve(client, session, incoming)
+		}
+		u.mutex.Unlock()
+
+		select {
+		case incoming <- data:
+		default:
+			// The client is far ahead of the recording
+		}
+	}
+}
+
+// assign picks the session for a new client, assumes the lock is held. Once
+// all sessions are used they are handed out again.
+func (u *Upstream) assign(first []byte) *Session {
+	sessions := u.recording.Sessions
+	if len(sessions) == 0 {
+		return nil
+	}
+	if len(u.used) == len(sessions) {
+		u.used = make(map[*Session]bool)
+	}
+	for _, session := range sessions {
+		if !u.used[session] && len(session.Datagrams) > 0 &&
+			session.Datagrams[0].Direction == stream.Upstream &&
+			bytes.Equal(session.Datagrams[0].Data, first) {
+			u.used[session] = true
+			return session
+		}
+	}
+	for {
+		session := sessions[u.next%len(sessions)]
+		u.next++
+		if !u.used[session] {
+			u.used[session] = true
+			return session
+		}
+	}
+}
+
+func (u *Upstream) serve(client net.Addr, session *Session, incoming <-chan []byte) {
+	trigger := time.Now()
+	var triggerOffset time.Duration
+	for _, d := range session.Datagrams {
+		if d.Direction == stream.Upstream {
+			select {
+			case <-incoming:
+			case <-u.done:
+				return
+			}
+			trigger, triggerOffset = time.Now(), d.Offset
+			continue
+		}
+
+		select {
+		case <-time.After(time.Until(trigger.Add(d.Offset - triggerOffset))):
+		case <-u.done:
+			return
+		}
+		if _, err := u.conn.WriteTo(d.Data, client); err != nil {
+			return
+		}
+	}
+}
+
+// ReplayClient acts as the client of a recorded session: it sends the
+// session's upstream datagrams to addr with their original timing and returns
+// the datagrams received until linger after the last recorded reply was due.
+// The linger leaves room for replies delayed by the toxics under test.
+func ReplayClient(ctx context.Context, session *Session, addr string, linger time.Duration) ([]Datagram, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	var received []Datagram
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buffer := make([]byte, stream.MaxDatagramSize)
+		for {
+			n, err := conn.Read(buffer)
+			if err != nil {
+				return
+			}
+			data := make([]byte, n)
+			copy(data, buffer[:n])
+			received = append(received, Datagram{
+				Direction: stream.Downstream,
+				Offset:    time.Since(start),
+				Data:      data,
+			})
+		}
+	}()
+
+	var end time.Duration
+	for _, d := range session.Datagrams {
+		end = d.Offset
+		if d.Direction != stream.Upstream {
+			continue
+		}
+		select {
+		case <-time.After(time.Until(start.Add(d.Offset))):
+		case <-ctx.Done():
+			conn.Close()
+			<-done
+			return received, ctx.Err()
+		}
+		if _, err := conn.Write(d.Data); err != nil {
+			conn.Close()
+			<-done
+			return received, err
+		}
+	}
+
+	select {
+	case <-time.After(time.Until(start.Add(end + linger))):
+	case <-ctx.Done():
+	}
+	conn.Close()
+	<-done
+	return received, ctx.Err()
+}
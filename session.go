@@ -0,0 +1,371 @@
+package toxiproxy
+
+import (
+	"container/list"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var errSharedRebind = errors.New("session shares the upstream socket and can't be rebound")
+
+// session holds everything the proxy keeps for a single client: the reader
+// feeding the upstream link and the socket dialed to the upstream, or the
+// replies routed to the client when the upstream socket is shared.
+type session struct {
+	name string
+	// Number of the session among the sessions of the proxy since it started,
+	// counting from 1 in the order the clients arrived
+	ordinal uint64
+	client  net.Addr
+	reader  UDPReader
+	replies UDPReader
+	shared  *sharedUpstream
+
+	sync.Mutex // Guards upstream and closed during a rebind
+	upstream   *net.UDPConn
+	dial       func() (*net.UDPConn, error)
+	closed     bool
+
+	// Multicast group the upstream socket publishes to, it isn't connected then
+	group *net.UDPAddr
+	// Sockets every datagram is copied to, their replies are ignored
+	mirrors []*net.UDPConn
+	// Moves the session to another upstream once its upstream refused a
+	// datagram, nil ends the session then
+	failover func() error
+
+	table     *sessionTable
+	element   *list.Element
+	lastSeen  time.Time
+	closeOnce sync.Once
+}
+
+// newSession creates a session with an upstream socket of its own, opened by
+// dial.
+func newSession(
+	table *sessionTable,
+	client net.Addr,
+	dial func() (*net.UDPConn, error),
+) (*session, error) {
+	upstream, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	return &session{
+		name:     client.String(),
+		client:   client,
+		reader:   newUDPReader(),
+		upstream: upstream,
+		dial:     dial,
+		table:    table,
+	}, nil
+}
+
+// newSharedSession creates a session without an upstream socket of its own,
+// its replies are delivered by a sharedUpstream.
+func newSharedSession(table *sessionTable, client net.Addr, shared *sharedUpstream) *session {
+	return &session{
+		name:    client.String(),
+		client:  client,
+		reader:  newUDPReader(),
+		replies: newUDPReader(),
+		shared:  shared,
+		table:   table,
+	}
+}
+
+func (s *session) conn() *net.UDPConn {
+	s.Lock()
+	defer s.Unlock()
+	return s.upstream
+}
+
+// upstreamAddr is the address of the upstream the session talks to.
+func (s *session) upstreamAddr() *net.UDPAddr {
+	if s.shared != nil {
+		return s.shared.addr
+	}
+	if s.group != nil {
+		return s.group
+	}
+	addr, _ := s.conn().RemoteAddr().(*net.UDPAddr)
+	return addr
+}
+
+// Read implements io.Reader for the downstream link. Every datagram received
+// from the upstream counts as activity of the session.
+func (s *session) Read(p []byte) (n int, err error) {
+	if s.shared != nil {
+		n, err = s.replies.Read(p)
+	} else {
+		for {
+			conn := s.conn()
+			n, err = conn.Read(p)
+			if err != nil && s.conn() != conn {
+				continue // Rebound while reading, read from the new socket
+			}
+			if s.refused(err) {
+				continue
+			}
+			break
+		}
+	}
+	if err == nil {
+		s.table.touch(s)
+	}
+	return n, err
+}
+
+// Rebind replaces the upstream socket with a newly dialed one, so the upstream
+// sees the client's datagrams coming from a new source port.
+func (s *session) Rebind() error {
+	if s.shared != nil {
+		return errSharedRebind
+	}
+	upstream, err := s.dial()
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		return upstream.Close()
+	}
+	old := s.upstream
+	s.upstream = upstream
+	s.Unlock()
+
+	return old.Close()
+}
+
+// upstreamWriter sends the datagrams of the upstream link through the current
+// upstream socket of a session.
+type upstreamWriter struct {
+	*session
+}
+
+func (w upstreamWriter) Write(p []byte) (int, error) {
+	for {
+		conn := w.conn()
+		var n int
+		var err error
+		if w.group != nil {
+			n, err = conn.WriteTo(p, w.group)
+		} else {
+			n, err = conn.Write(p)
+		}
+		if err != nil && w.conn() != conn {
+			continue // Rebound while writing, send through the new socket
+		}
+		if w.refused(err) {
+			continue
+		}
+		for _, mirror := range w.mirrors {
+			mirror.Write(p)
+		}
+		return n, err
+	}
+}
+
+// refused fails the session over to another upstream if err says its upstream
+// refused a datagram. It returns whether the session failed over.
+func (s *session) refused(err error) bool {
+	if s.failover == nil || !errors.Is(err, syscall.ECONNREFUSED) {
+		return false
+	}
+	return s.failover() == nil
+}
+
+func (w upstreamWriter) Close() error {
+	w.close()
+	return nil
+}
+
+// deliver hands a datagram received from the client to the upstream link.
+func (s *session) deliver(data []byte) {
+	select {
+	case s.reader.incoming <- data:
+	case <-s.reader.done:
+	}
+}
+
+// reply hands a datagram from a shared upstream socket to the downstream link.
+// Like a full socket buffer, a session that can't keep up drops it.
+func (s *session) reply(data []byte) {
+	select {
+	case s.replies.incoming <- data:
+	default:
+	}
+}
+
+// close tears down both links of the session: the upstream link sees EOF from
+// its reader and the downstream link fails reading from the closed upstream
+// socket, or sees EOF from its replies.
+func (s *session) close() {
+	s.closeOnce.Do(func() {
+		close(s.reader.done)
+		if s.shared != nil {
+			close(s.replies.done)
+			s.shared.forget(s)
+			return
+		}
+
+		s.Lock()
+		s.closed = true
+		s.upstream.Close()
+		s.Unlock()
+		for _, mirror := range s.mirrors {
+			mirror.Close()
+		}
+	})
+}
+
+// sessionTable tracks the client sessions of a proxy. Sessions are evicted once
+// they have been idle for longer than idleTimeout, or in least recently used
+// order when the table would grow beyond maxSessions.
+type sessionTable struct {
+	sync.Mutex
+
+	sessions    map[string]*session
+	lru         *list.List // Most recently used session at the front
+	idleTimeout time.Duration
+	maxSessions int
+}
+
+func newSessionTable() *sessionTable {
+	return &sessionTable{
+		sessions: make(map[string]*session),
+		lru:      list.New(),
+	}
+}
+
+// configure sets the eviction limits, a zero value disables the limit. It
+// returns the sessions evicted by the new limits, which the caller must close.
+func (t *sessionTable) configure(idleTimeout time.Duration, maxSessions int) []*session {
+	t.Lock()
+	defer t.Unlock()
+
+	t.idleTimeout = idleTimeout
+	t.maxSessions = maxSessions
+	return append(t.evictOverflow(0), t.evictIdle(time.Now())...)
+}
+
+// get returns the session of a client and marks it as recently used.
+func (t *sessionTable) get(name string) *session {
+	t.Lock()
+	defer t.Unlock()
+
+	s, ok := t.sessions[name]
+	if !ok {
+		return nil
+	}
+	s.lastSeen = time.Now()
+	t.lru.MoveToFront(s.element)
+	return s
+}
+
+// add registers a new session. It returns the least recently used sessions that
+// had to be evicted to respect maxSessions, which the caller must close.
+func (t *sessionTable) add(s *session) []*session {
+	t.Lock()
+	defer t.Unlock()
+
+	evicted := t.evictOverflow(1)
+	s.lastSeen = time.Now()
+	s.element = t.lru.PushFront(s)
+	t.sessions[s.name] = s
+	return evicted
+}
+
+func (t *sessionTable) touch(s *session) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.sessions[s.name] != s {
+		return // Already evicted
+	}
+	s.lastSeen = time.Now()
+	t.lru.MoveToFront(s.element)
+}
+
+// sweep returns the sessions that have been idle for too long, which the caller
+// must close.
+func (t *sessionTable) sweep() []*session {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.evictIdle(time.Now())
+}
+
+// sweepInterval returns how often the table should be swept for idle sessions.
+func (t *sessionTable) sweepInterval() time.Duration {
+	t.Lock()
+	defer t.Unlock()
+
+	interval := t.idleTimeout / 2
+	if interval <= 0 || interval > time.Second {
+		return time.Second
+	}
+	if interval < 10*time.Millisecond {
+		return 10 * time.Millisecond
+	}
+	return interval
+}
+
+// clear removes all sessions, which the caller must close.
+func (t *sessionTable) clear() []*session {
+	t.Lock()
+	defer t.Unlock()
+
+	evicted := make([]*session, 0, len(t.sessions))
+	for t.lru.Len() > 0 {
+		evicted = append(evicted, t.remove(t.lru.Back()))
+	}
+	return evicted
+}
+
+func (t *sessionTable) Len() int {
+	t.Lock()
+	defer t.Unlock()
+
+	return len(t.sessions)
+}
+
+// All following functions assume the lock is already grabbed.
+func (t *sessionTable) remove(element *list.Element) *session {
+	s := t.lru.Remove(element).(*session)
+	delete(t.sessions, s.name)
+	return s
+}
+
+// evictOverflow evicts least recently used sessions until there is room for
+// reserve more sessions.
+func (t *sessionTable) evictOverflow(reserve int) []*session {
+	var evicted []*session
+	if t.maxSessions <= 0 {
+		return evicted
+	}
+	for t.lru.Len() > 0 && t.lru.Len()+reserve > t.maxSessions {
+		evicted = append(evicted, t.remove(t.lru.Back()))
+	}
+	return evicted
+}
+
+func (t *sessionTable) evictIdle(now time.Time) []*session {
+	var evicted []*session
+	if t.idleTimeout <= 0 {
+		return evicted
+	}
+	for t.lru.Len() > 0 {
+		oldest := t.lru.Back()
+		if now.Sub(oldest.Value.(*session).lastSeen) < t.idleTimeout {
+			break
+		}
+		evicted = append(evicted, t.remove(oldest))
+	}
+	return evicted
+}
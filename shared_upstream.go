@@ -0,0 +1,112 @@
+package toxiproxy
+
+import (
+	"net"
+	"sync"
+
+	"github.com/badrootd/udpcrusher/demux"
+	"github.com/badrootd/udpcrusher/stream"
+
+	"github.com/rs/zerolog"
+)
+
+// sharedUpstream is a single upstream socket multiplexed between all client
+// sessions of a proxy. Replies are routed back to the sessions by the flow keys
+// a demultiplexer extracts from the datagrams.
+type sharedUpstream struct {
+	sync.Mutex
+
+	conn   *net.UDPConn
+	addr   *net.UDPAddr
+	demux  demux.Demultiplexer
+	flows  map[string]*session
+	logger *zerolog.Logger
+}
+
+func newSharedUpstream(
+	upstream string,
+	demultiplexer demux.Demultiplexer,
+	logger *zerolog.Logger,
+) (*sharedUpstream, error) {
+	addr, err := net.ResolveUDPAddr("udp", upstream)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &sharedUpstream{
+		conn:   conn,
+		addr:   addr,
+		demux:  demultiplexer,
+		flows:  make(map[string]*session),
+		logger: logger,
+	}, nil
+}
+
+// writer returns the destination of a session's upstream link.
+func (u *sharedUpstream) writer() UDPWriter {
+	return UDPWriter{
+		outgoing: u.conn,
+		rAddr:    u.addr,
+	}
+}
+
+// learn remembers the flow key of a datagram sent by a client.
+func (u *sharedUpstream) learn(s *session, data []byte) {
+	key, ok := u.demux.Upstream(data)
+	if !ok {
+		return
+	}
+
+	u.Lock()
+	defer u.Unlock()
+	u.flows[key] = s
+}
+
+// forget removes all flows of a closed session.
+func (u *sharedUpstream) forget(s *session) {
+	u.Lock()
+	defer u.Unlock()
+
+	for key, owner := range u.flows {
+		if owner == s {
+			delete(u.flows, key)
+		}
+	}
+}
+
+func (u *sharedUpstream) route(data []byte) *session {
+	key, ok := u.demux.Downstream(data)
+	if !ok {
+		return nil
+	}
+
+	u.Lock()
+	defer u.Unlock()
+	return u.flows[key]
+}
+
+// run delivers datagrams from the upstream to their sessions until the socket
+// is closed.
+func (u *sharedUpstream) run() {
+	for {
+		buffer := make([]byte, stream.MaxDatagramSize)
+		n, _, err := u.conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+
+		s := u.route(buffer[:n])
+		if s == nil {
+			u.logger.Debug().Int("bytes", n).Msg("Dropped unroutable datagram from upstream")
+			continue
+		}
+		s.reply(buffer[:n])
+	}
+}
+
+func (u *sharedUpstream) close() {
+	u.conn.Close()
+}
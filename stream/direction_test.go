@@ -3,7 +3,7 @@ package stream_test
 import (
 	"testing"
 
-	"github.com/Shopify/toxiproxy/v2/stream"
+	"github.com/badrootd/udpcrusher/stream"
 )
 
 func TestDirection_String(t *testing.T) {
@@ -0,0 +1,108 @@
+package stream
+
+import (
+	"io"
+	"time"
+)
+
+// MaxDatagramSize is the largest payload a single UDP datagram can carry.
+const MaxDatagramSize = 65535
+
+// Implements the io.WriteCloser interface for a chan *StreamChunk, where every
+// call to `Write()` produces exactly one StreamChunk, so datagram boundaries are
+// preserved.
+type PacketWriter struct {
+	output chan<- *StreamChunk
+}
+
+func NewPacketWriter(output chan<- *StreamChunk) *PacketWriter {
+	return &PacketWriter{output}
+}
+
+// Write `buf` as a single StreamChunk to the channel. The full buffer is always
+// written, and error will always be nil. Calling `Write()` after closing the
+// channel will panic.
+func (p *PacketWriter) Write(buf []byte) (int, error) {
+	packet := &StreamChunk{make([]byte, len(buf)), time.Now()}
+	copy(packet.Data, buf) // Make a copy before sending it to the channel
+	p.output <- packet
+	return len(buf), nil
+}
+
+// Close the output channel.
+func (p *PacketWriter) Close() error {
+	close(p.output)
+	return nil
+}
+
+// Implements the io.Reader interface for a chan *StreamChunk, where every call
+// to `Read()` returns exactly one StreamChunk. Unlike ChanReader, the remainder
+// of a chunk is never merged with the next one.
+type PacketReader struct {
+	input     <-chan *StreamChunk
+	interrupt <-chan struct{}
+}
+
+func NewPacketReader(input <-chan *StreamChunk) *PacketReader {
+	return &PacketReader{input, make(chan struct{})}
+}
+
+// Specify a channel that can interrupt a read if it is blocking.
+func (p *PacketReader) SetInterrupt(interrupt <-chan struct{}) {
+	p.interrupt = interrupt
+}
+
+// ReadPacket returns the next StreamChunk from the channel. This will block until
+// a chunk is available, and can be interrupted with a channel using
+// `SetInterrupt()`. It returns io.EOF once the channel is closed.
+func (p *PacketReader) ReadPacket() (*StreamChunk, error) {
+	select {
+	case c := <-p.input:
+		if c == nil { // Stream was closed
+			return nil, io.EOF
+		}
+		return c, nil
+	case <-p.interrupt:
+		return nil, ErrInterrupted
+	}
+}
+
+// Read the next StreamChunk into `out`. Like a read from a UDP socket, a chunk
+// larger than `out` is truncated and the rest of it is discarded.
+func (p *PacketReader) Read(out []byte) (int, error) {
+	c, err := p.ReadPacket()
+	if err != nil {
+		return 0, err
+	}
+	return copy(out, c.Data), nil
+}
+
+// CopyPackets copies datagrams from src to dst until either EOF is reached on src
+// or an error occurs. Every `Read()` from src is passed to dst as a single
+// `Write()`, so src must return one datagram per read. It returns the number of
+// datagrams and bytes copied and the first error encountered while copying, if
+// any. A successful CopyPackets returns err == nil, not err == EOF.
+func CopyPackets(dst io.Writer, src io.Reader) (packets, bytes int64, err error) {
+	buf := make([]byte, MaxDatagramSize)
+	for {
+		n, rerr := src.Read(buf)
+		// Empty datagrams are valid in UDP, so forward them as well
+		if n > 0 || rerr == nil {
+			written, werr := dst.Write(buf[:n])
+			if werr != nil {
+				return packets, bytes, werr
+			}
+			if written != n {
+				return packets, bytes, io.ErrShortWrite
+			}
+			packets++
+			bytes += int64(written)
+		}
+		if rerr != nil {
+			if rerr == io.EOF {
+				return packets, bytes, nil
+			}
+			return packets, bytes, rerr
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package stream
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestPacketReadKeepsBoundaries(t *testing.T) {
+	c := make(chan *StreamChunk, 2)
+	writer := NewPacketWriter(c)
+	reader := NewPacketReader(c)
+	writer.Write([]byte("hello"))
+	writer.Write([]byte("world"))
+	writer.Close()
+
+	buf := make([]byte, 32)
+	for _, expected := range []string{"hello", "world"} {
+		n, err := reader.Read(buf)
+		if err != nil {
+			t.Fatal("Couldn't read from stream", err)
+		}
+		if !bytes.Equal(buf[:n], []byte(expected)) {
+			t.Fatalf("Got wrong datagram from stream: %q expected %q", buf[:n], expected)
+		}
+	}
+
+	n, err := reader.Read(buf)
+	if err != io.EOF {
+		t.Fatal("Read returned wrong error after close:", err)
+	}
+	if n != 0 {
+		t.Fatalf("Read still returned data after close: %d bytes", n)
+	}
+}
+
+func TestPacketReadTruncates(t *testing.T) {
+	c := make(chan *StreamChunk, 2)
+	writer := NewPacketWriter(c)
+	reader := NewPacketReader(c)
+	writer.Write([]byte("hello world"))
+	writer.Write([]byte("next"))
+	writer.Close()
+
+	buf := make([]byte, 5)
+	n, err := reader.Read(buf)
+	if err != nil {
+		t.Fatal("Couldn't read from stream", err)
+	}
+	if !bytes.Equal(buf[:n], []byte("hello")) {
+		t.Fatalf("Got wrong datagram from stream: %q", buf[:n])
+	}
+
+	// The rest of the first datagram must be discarded
+	n, err = reader.Read(buf)
+	if err != nil {
+		t.Fatal("Couldn't read from stream", err)
+	}
+	if !bytes.Equal(buf[:n], []byte("next")) {
+		t.Fatalf("Got wrong datagram from stream: %q", buf[:n])
+	}
+}
+
+func TestCopyPacketsKeepsBoundaries(t *testing.T) {
+	in := make(chan *StreamChunk, 3)
+	out := make(chan *StreamChunk, 3)
+	writer := NewPacketWriter(in)
+	writer.Write([]byte("first"))
+	writer.Write([]byte{})
+	writer.Write([]byte("third"))
+	writer.Close()
+
+	packets, n, err := CopyPackets(NewPacketWriter(out), NewPacketReader(in))
+	if err != nil {
+		t.Fatal("Couldn't copy packets", err)
+	}
+	if packets != 3 || n != 10 {
+		t.Fatalf("Copied wrong amount: %d packets, %d bytes", packets, n)
+	}
+
+	for _, expected := range []string{"first", "", "third"} {
+		c := <-out
+		if !bytes.Equal(c.Data, []byte(expected)) {
+			t.Fatalf("Got wrong datagram: %q expected %q", c.Data, expected)
+		}
+	}
+}
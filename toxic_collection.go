@@ -6,7 +6,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
+	"net"
 	"sync"
+	"time"
 
 	"github.com/badrootd/udpcrusher/stream"
 	"github.com/badrootd/udpcrusher/toxics"
@@ -25,6 +28,11 @@ type ToxicCollection struct {
 	proxy *Proxy
 	chain [][]*toxics.ToxicWrapper
 	links map[string]*ToxicLink
+	seed  int64
+
+	// Stops the goroutines changing toxics according to their profiles, by
+	// toxic name
+	profiles map[string]chan struct{}
 }
 
 func NewToxicCollection(proxy *Proxy) *ToxicCollection {
@@ -33,9 +41,10 @@ func NewToxicCollection(proxy *Proxy) *ToxicCollection {
 			Toxic: new(toxics.NoopToxic),
 			Type:  "noop",
 		},
-		proxy: proxy,
-		chain: make([][]*toxics.ToxicWrapper, stream.NumDirections),
-		links: make(map[string]*ToxicLink),
+		proxy:    proxy,
+		chain:    make([][]*toxics.ToxicWrapper, stream.NumDirections),
+		links:    make(map[string]*ToxicLink),
+		profiles: make(map[string]chan struct{}),
 	}
 	for dir := range collection.chain {
 		collection.chain[dir] = make([]*toxics.ToxicWrapper, 1, toxics.Count()+1)
@@ -56,6 +65,20 @@ func (c *ToxicCollection) ResetToxics(ctx context.Context) {
 	}
 }
 
+// SetSeed sets the proxy seed for the random decisions of toxics on links that
+// are started afterwards. Schedules shared by all links start over right away.
+func (c *ToxicCollection) SetSeed(seed int64) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.seed = seed
+	for dir := range c.chain {
+		for _, toxic := range c.chain[dir] {
+			c.schedule(toxic)
+		}
+	}
+}
+
 func (c *ToxicCollection) GetToxic(name string) *toxics.ToxicWrapper {
 	c.Lock()
 	defer c.Unlock()
@@ -80,11 +103,43 @@ func (c *ToxicCollection) GetToxicArray() []toxics.Toxic {
 	return result
 }
 
+// AddToxic adds a toxic to the end of its chain. Toxics built in Go code usually
+// leave Toxicity unset, so a zero toxicity defaults to 1.
 func (c *ToxicCollection) AddToxic(wrapper *toxics.ToxicWrapper) error {
+	if wrapper.Toxicity == 0 {
+		wrapper.Toxicity = 1.0
+	}
+	return c.addToxic(wrapper)
+}
+
+func (c *ToxicCollection) addToxic(wrapper *toxics.ToxicWrapper) error {
 	c.Lock()
 	defer c.Unlock()
 
-	wrapper.Toxicity = 1.0
+	if wrapper.ToxicityMode == "" {
+		wrapper.ToxicityMode = toxics.ToxicityPerPacket
+	}
+	if !validToxicityMode(wrapper.ToxicityMode) {
+		return ErrInvalidToxicityMode
+	}
+	if err := validateToxic(wrapper.Toxic); err != nil {
+		return joinError(err, ErrInvalidToxicAttributes)
+	}
+	if wrapper.Profile != nil {
+		if err := wrapper.Profile.Load(wrapper.Toxic); err != nil {
+			return joinError(err, ErrInvalidProfile)
+		}
+	}
+	if wrapper.Selector != nil {
+		if err := wrapper.Selector.Load(); err != nil {
+			return joinError(err, ErrInvalidSelector)
+		}
+	}
+	if wrapper.Match != nil {
+		if err := wrapper.Match.Load(); err != nil {
+			return joinError(err, ErrInvalidMatch)
+		}
+	}
 
 	if wrapper.Name == "" {
 		wrapper.Name = fmt.Sprintf("%s_%s", wrapper.Type, wrapper.Stream)
@@ -95,7 +150,9 @@ func (c *ToxicCollection) AddToxic(wrapper *toxics.ToxicWrapper) error {
 		return ErrToxicAlreadyExists
 	}
 
+	c.schedule(wrapper)
 	c.chainAddToxic(wrapper)
+	c.startProfile(wrapper)
 	return nil
 }
 
@@ -133,7 +190,7 @@ func (c *ToxicCollection) AddToxicJson(data io.Reader) (*toxics.ToxicWrapper, er
 		return nil, joinError(err, ErrBadRequestBody)
 	}
 
-	return wrapper, c.AddToxic(wrapper)
+	return wrapper, c.addToxic(wrapper)
 }
 
 func (c *ToxicCollection) UpdateToxicJson(
@@ -145,21 +202,94 @@ func (c *ToxicCollection) UpdateToxicJson(
 
 	toxic := c.findToxicByName(name)
 	if toxic != nil {
+		// The toxic is shared with its links and API responses, so the changes
+		// go into a copy that replaces it once all of them are valid
+		updated, err := toxic.Copy()
+		if err != nil {
+			return nil, err
+		}
 		attrs := &struct {
-			Attributes interface{} `json:"attributes"`
-			Toxicity   float32     `json:"toxicity"`
+			Attributes   interface{}     `json:"attributes"`
+			Toxicity     float32         `json:"toxicity"`
+			ToxicityMode string          `json:"toxicity_mode"`
+			Profile      json.RawMessage `json:"profile"`
+			Selector     json.RawMessage `json:"selector"`
+			Match        json.RawMessage `json:"match"`
 		}{
-			toxic.Toxic,
-			toxic.Toxicity,
+			updated.Toxic,
+			updated.Toxicity,
+			updated.ToxicityMode,
+			nil,
+			nil,
+			nil,
 		}
-		err := json.NewDecoder(data).Decode(attrs)
+		err = json.NewDecoder(data).Decode(attrs)
 		if err != nil {
 			return nil, joinError(err, ErrBadRequestBody)
 		}
-		toxic.Toxicity = attrs.Toxicity
+		if err := validateToxic(updated.Toxic); err != nil {
+			return nil, joinError(err, ErrInvalidToxicAttributes)
+		}
+		if !validToxicityMode(attrs.ToxicityMode) {
+			return nil, ErrInvalidToxicityMode
+		}
+
+		// A given profile replaces the running one, null removes it
+		profile := toxic.Profile
+		if len(attrs.Profile) > 0 {
+			profile = nil
+			err = json.Unmarshal(attrs.Profile, &profile)
+			if err != nil {
+				return nil, joinError(err, ErrBadRequestBody)
+			}
+			if profile != nil {
+				if err := profile.Load(updated.Toxic); err != nil {
+					return nil, joinError(err, ErrInvalidProfile)
+				}
+			}
+		}
 
-		c.chainUpdateToxic(toxic)
-		return toxic, nil
+		// Likewise a given selector or match replaces the current one, null
+		// removes it
+		selector := toxic.Selector
+		if len(attrs.Selector) > 0 {
+			selector = nil
+			err = json.Unmarshal(attrs.Selector, &selector)
+			if err != nil {
+				return nil, joinError(err, ErrBadRequestBody)
+			}
+			if selector != nil {
+				if err := selector.Load(); err != nil {
+					return nil, joinError(err, ErrInvalidSelector)
+				}
+			}
+		}
+		match := toxic.Match
+		if len(attrs.Match) > 0 {
+			match = nil
+			err = json.Unmarshal(attrs.Match, &match)
+			if err != nil {
+				return nil, joinError(err, ErrBadRequestBody)
+			}
+			if match != nil {
+				if err := match.Load(); err != nil {
+					return nil, joinError(err, ErrInvalidMatch)
+				}
+			}
+		}
+		updated.Toxicity = attrs.Toxicity
+		updated.ToxicityMode = attrs.ToxicityMode
+		updated.Profile = profile
+		updated.Selector = selector
+		updated.Match = match
+
+		c.schedule(updated)
+		c.chainUpdateToxic(updated, seedOf(updated) != seedOf(toxic))
+		if profile != toxic.Profile {
+			c.stopProfile(toxic)
+			c.startProfile(updated)
+		}
+		return updated, nil
 	}
 	return nil, ErrToxicNotFound
 }
@@ -194,6 +324,7 @@ func (c *ToxicCollection) StartLink(
 	input io.Reader,
 	output io.WriteCloser,
 	direction stream.Direction,
+	s *session,
 ) {
 	c.Lock()
 	defer c.Unlock()
@@ -206,14 +337,95 @@ func (c *ToxicCollection) StartLink(
 	}
 
 	link := NewToxicLink(c.proxy, c, direction, logger)
+	link.rebinder = s
+	link.session = s.ordinal
+	link.client, _ = s.client.(*net.UDPAddr)
+	link.upstream = s.upstreamAddr()
 	link.Start(server, name, input, output)
 	c.links[name] = link
 }
 
-func (c *ToxicCollection) RemoveLink(name string) {
+// RemoveLink removes a finished link from the collection. A newer link that was
+// started under the same name, e.g. when a client returns after its session was
+// evicted, is kept.
+func (c *ToxicCollection) RemoveLink(name string, link *ToxicLink) {
 	c.Lock()
 	defer c.Unlock()
-	delete(c.links, name)
+	if c.links[name] == link {
+		delete(c.links, name)
+	}
+}
+
+// stopProfiles stops changing the toxics of a removed proxy.
+func (c *ToxicCollection) stopProfiles() {
+	c.Lock()
+	defer c.Unlock()
+
+	for name, stop := range c.profiles {
+		close(stop)
+		delete(c.profiles, name)
+	}
+}
+
+// runProfile updates the attributes of a toxic according to its profile until
+// the profile reaches its final values or is stopped. Every update replaces the
+// toxic with a changed copy.
+func (c *ToxicCollection) runProfile(name string, profile *toxics.Profile, stop chan struct{}) {
+	ticker := time.NewTicker(profile.Tick())
+	defer ticker.Stop()
+
+	start := time.Now()
+	var applied map[string]float64
+	for {
+		attrs, done := profile.At(time.Since(start))
+		if !maps.Equal(attrs, applied) {
+			c.Lock()
+			select {
+			case <-stop:
+				// Removed or replaced while waiting for the lock
+				c.Unlock()
+				return
+			default:
+			}
+			toxic, err := c.findToxicByName(name).Copy()
+			if err == nil {
+				toxics.SetAttributes(toxic.Toxic, attrs)
+				// The random sequences go on, restarting them on every tick
+				// would repeat the same decisions
+				c.chainUpdateToxic(toxic, false)
+			}
+			c.Unlock()
+			applied = attrs
+		}
+		if done {
+			return
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func validToxicityMode(mode string) bool {
+	return mode == toxics.ToxicityPerPacket || mode == toxics.ToxicityPerLink
+}
+
+// seedOf returns the seed attribute of a toxic, 0 if it has none.
+func seedOf(toxic *toxics.ToxicWrapper) int64 {
+	if seeded, ok := toxic.Toxic.(toxics.SeededToxic); ok {
+		return seeded.GetSeed()
+	}
+	return 0
+}
+
+func validateToxic(toxic toxics.Toxic) error {
+	if validated, ok := toxic.(toxics.ValidatedToxic); ok {
+		return validated.Validate()
+	}
+	return nil
 }
 
 // All following functions assume the lock is already grabbed.
@@ -229,6 +441,30 @@ func (c *ToxicCollection) findToxicByName(name string) *toxics.ToxicWrapper {
 	return nil
 }
 
+func (c *ToxicCollection) startProfile(toxic *toxics.ToxicWrapper) {
+	if toxic.Profile == nil {
+		return
+	}
+	stop := make(chan struct{})
+	c.profiles[toxic.Name] = stop
+	go c.runProfile(toxic.Name, toxic.Profile, stop)
+}
+
+func (c *ToxicCollection) stopProfile(toxic *toxics.ToxicWrapper) {
+	if stop, ok := c.profiles[toxic.Name]; ok {
+		close(stop)
+		delete(c.profiles, toxic.Name)
+	}
+}
+
+// schedule starts the schedule a toxic shares between its links over, with a
+// random source derived from the seeds.
+func (c *ToxicCollection) schedule(toxic *toxics.ToxicWrapper) {
+	if scheduled, ok := toxic.Toxic.(toxics.ScheduledToxic); ok {
+		scheduled.Schedule(toxics.SharedRand(toxic, c.seed))
+	}
+}
+
 func (c *ToxicCollection) chainAddToxic(toxic *toxics.ToxicWrapper) {
 	dir := toxic.Direction
 	toxic.Index = len(c.chain[dir])
@@ -248,7 +484,9 @@ func (c *ToxicCollection) chainAddToxic(toxic *toxics.ToxicWrapper) {
 	wg.Wait()
 }
 
-func (c *ToxicCollection) chainUpdateToxic(toxic *toxics.ToxicWrapper) {
+// chainUpdateToxic replaces a toxic by its updated copy. With reseed set the
+// links restart the random sequence of the toxic.
+func (c *ToxicCollection) chainUpdateToxic(toxic *toxics.ToxicWrapper, reseed bool) {
 	c.chain[toxic.Direction][toxic.Index] = toxic
 
 	// Asynchronously update the toxic in each link
@@ -258,7 +496,7 @@ func (c *ToxicCollection) chainUpdateToxic(toxic *toxics.ToxicWrapper) {
 			group.Add(1)
 			go func(link *ToxicLink) {
 				defer group.Done()
-				link.UpdateToxic(toxic)
+				link.UpdateToxic(toxic, reseed)
 			}(link)
 		}
 	}
@@ -274,6 +512,8 @@ func (c *ToxicCollection) chainRemoveToxic(ctx context.Context, toxic *toxics.To
 		Str("direction", toxic.Direction.String()).
 		Logger()
 
+	c.stopProfile(toxic)
+
 	dir := toxic.Direction
 	c.chain[dir] = append(c.chain[dir][:toxic.Index], c.chain[dir][toxic.Index+1:]...)
 	for i := toxic.Index; i < len(c.chain[dir]); i++ {
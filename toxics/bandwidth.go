@@ -32,6 +32,10 @@ func (t *BandwidthToxic) Pipe(stub *ToxicStub) {
 				stub.Close()
 				return
 			}
+			if !stub.Affected(p) {
+				stub.Output <- p
+				continue
+			}
 			if t.Rate <= 0 {
 				sleep = 0
 			} else {
@@ -61,7 +65,9 @@ func (t *BandwidthToxic) Pipe(stub *ToxicStub) {
 			select {
 			case <-time.After(sleep):
 				// time.After only seems to have ~1ms prevision, so offset the next sleep by the error
-				sleep -= time.Since(start)
+				waited := time.Since(start)
+				sleep -= waited
+				stub.Delay(waited)
 				stub.Output <- p
 			case <-stub.Interrupt:
 				logger.Trace().Msg("BandwidthToxic was interrupted during writing data")
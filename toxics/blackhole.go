@@ -0,0 +1,116 @@
+package toxics
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// The BlackholeToxic silently drops all datagrams of its stream while it is
+// down. Without a period it is down for as long as the toxic exists, otherwise
+// it is down for the first Down milliseconds of every period, e.g. 2s down every
+// 10s. Jitter randomizes the length of every down and up window, making the
+// link flap. All links share the schedule, so a partition hits every client at
+// the same time. The schedule starts with the first datagram, and starts over
+// when the toxic is changed.
+type BlackholeToxic struct {
+	// Milliseconds of a schedule cycle, 0 is always down
+	Period int64 `json:"period"`
+	// Milliseconds the blackhole is down at the start of every period
+	Down int64 `json:"down"`
+	// Maximum milliseconds +/- added to every down and up window
+	Jitter int64 `json:"jitter"`
+	Seeded
+
+	schedule blackholeSchedule
+}
+
+// blackholeSchedule is the state of the down and up windows shared by all links.
+type blackholeSchedule struct {
+	sync.Mutex
+	// Random source of the jitter, independent of the links so a seeded
+	// schedule replays no matter which link reaches it first
+	rand  *rand.Rand
+	down  bool
+	until time.Time
+}
+
+// Schedule starts the schedule over with the random source, the first window
+// begins with the next datagram.
+func (t *BlackholeToxic) Schedule(r *rand.Rand) {
+	t.schedule.Lock()
+	defer t.schedule.Unlock()
+	t.schedule.rand = r
+	t.schedule.down = false
+	t.schedule.until = time.Time{}
+}
+
+// Carry continues the schedule of the previous version of the toxic.
+func (t *BlackholeToxic) Carry(previous Toxic) {
+	prev := previous.(*BlackholeToxic)
+	prev.schedule.Lock()
+	defer prev.schedule.Unlock()
+	t.schedule.rand = prev.schedule.rand
+	t.schedule.down = prev.schedule.down
+	t.schedule.until = prev.schedule.until
+}
+
+// isDown advances the schedule up to now and reports whether datagrams are
+// dropped.
+func (t *BlackholeToxic) isDown(now time.Time) bool {
+	if t.Period <= 0 || t.Down >= t.Period {
+		return true
+	}
+	if t.Down <= 0 {
+		return false
+	}
+
+	s := &t.schedule
+	s.Lock()
+	defer s.Unlock()
+
+	if s.rand == nil {
+		s.rand = newRand(0)
+	}
+	if s.until.IsZero() {
+		s.until = now
+	}
+	for !now.Before(s.until) {
+		s.down = !s.down
+		window := t.Period - t.Down
+		if s.down {
+			window = t.Down
+		}
+		if t.Jitter > 0 {
+			window += s.rand.Int63n(t.Jitter*2) - t.Jitter
+		}
+		if window < 1 {
+			window = 1
+		}
+		s.until = s.until.Add(time.Duration(window) * time.Millisecond)
+	}
+	return s.down
+}
+
+func (t *BlackholeToxic) Pipe(stub *ToxicStub) {
+	for {
+		select {
+		case <-stub.Interrupt:
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				stub.Close()
+				return
+			}
+			if stub.Affected(c) && t.isDown(time.Now()) {
+				stub.Drop()
+				continue
+			}
+			stub.Output <- c
+		}
+	}
+}
+
+func init() {
+	Register("blackhole", new(BlackholeToxic))
+}
@@ -0,0 +1,92 @@
+package toxics_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/badrootd/udpcrusher/stream"
+	"github.com/badrootd/udpcrusher/toxics"
+)
+
+func TestBlackholeToxicAlwaysDown(t *testing.T) {
+	blackhole := &toxics.BlackholeToxic{}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 10)
+	stub := toxics.NewToxicStub(input, output)
+
+	done := make(chan bool)
+	go func() {
+		blackhole.Pipe(stub)
+		done <- true
+	}()
+
+	for i := 0; i < 10; i++ {
+		input <- &stream.StreamChunk{Data: []byte("hello")}
+	}
+	close(input)
+	<-done
+
+	if len(output) != 0 {
+		t.Fatalf("Expected all datagrams to be dropped, got %d", len(output))
+	}
+}
+
+func TestBlackholeToxicSchedule(t *testing.T) {
+	blackhole := &toxics.BlackholeToxic{Period: 200, Down: 100}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk)
+	stub := toxics.NewToxicStub(input, output)
+
+	go blackhole.Pipe(stub)
+	defer close(input)
+
+	// The first datagram starts the schedule, the following ones sample the
+	// middle of the windows: down, up, down, up
+	start := time.Now()
+	input <- &stream.StreamChunk{Data: []byte("start")}
+	for i, expected := range []bool{false, true, false, true} {
+		time.Sleep(time.Until(start.Add(time.Duration(i*100+50) * time.Millisecond)))
+		input <- &stream.StreamChunk{Data: []byte{byte(i)}}
+
+		select {
+		case <-output:
+			if !expected {
+				t.Fatalf("Expected datagram %d to be dropped", i)
+			}
+		case <-time.After(20 * time.Millisecond):
+			if expected {
+				t.Fatalf("Expected datagram %d to pass", i)
+			}
+		}
+	}
+}
+
+func TestBlackholeToxicScheduleStartsOver(t *testing.T) {
+	blackhole := &toxics.BlackholeToxic{Period: 200, Down: 100}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk)
+	stub := toxics.NewToxicStub(input, output)
+
+	go blackhole.Pipe(stub)
+	defer close(input)
+
+	// The first datagram starts the schedule with a down window, the second one
+	// falls into the up window until the schedule starts over
+	start := time.Now()
+	input <- &stream.StreamChunk{Data: []byte("start")}
+	time.Sleep(time.Until(start.Add(150 * time.Millisecond)))
+	input <- &stream.StreamChunk{Data: []byte("up")}
+	<-output
+
+	blackhole.Schedule(rand.New(rand.NewSource(1)))
+	input <- &stream.StreamChunk{Data: []byte("restarted")}
+	select {
+	case <-output:
+		t.Fatal("Expected the restarted schedule to begin with a down window")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
@@ -0,0 +1,92 @@
+package toxics
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+const (
+	CorruptModeBitFlip   = "bit_flip"
+	CorruptModeOverwrite = "overwrite"
+	CorruptModeZero      = "zero"
+)
+
+// The CorruptToxic mutates datagram payloads in flight. It flips random bits,
+// overwrites random bytes with random values, or zeroes a fixed byte range.
+type CorruptToxic struct {
+	Seeded
+	// Corruption mode, one of bit_flip (default), overwrite or zero
+	Mode string `json:"mode"`
+	// Probability to corrupt a datagram
+	Probability float64 `json:"probability"`
+	// Number of bits to flip or bytes to overwrite, at least 1
+	Count int `json:"count"`
+	// Byte range to zero, truncated to the datagram length
+	Offset int `json:"offset"`
+	Length int `json:"length"`
+}
+
+func (t *CorruptToxic) Validate() error {
+	switch t.Mode {
+	case "", CorruptModeBitFlip, CorruptModeOverwrite, CorruptModeZero:
+		return nil
+	}
+	return fmt.Errorf("unknown corrupt mode %q", t.Mode)
+}
+
+// corrupt mutates the payload in place.
+func (t *CorruptToxic) corrupt(r *rand.Rand, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	count := t.Count
+	if count < 1 {
+		count = 1
+	}
+
+	switch t.Mode {
+	case "", CorruptModeBitFlip:
+		for i := 0; i < count; i++ {
+			bit := r.Intn(len(data) * 8)
+			data[bit/8] ^= 1 << (bit % 8)
+		}
+	case CorruptModeOverwrite:
+		for i := 0; i < count; i++ {
+			data[r.Intn(len(data))] = byte(r.Intn(256))
+		}
+	case CorruptModeZero:
+		if t.Offset < 0 || t.Offset >= len(data) {
+			return
+		}
+		end := len(data)
+		if t.Length > 0 && t.Offset+t.Length < end {
+			end = t.Offset + t.Length
+		}
+		for i := t.Offset; i < end; i++ {
+			data[i] = 0
+		}
+	}
+}
+
+func (t *CorruptToxic) Pipe(stub *ToxicStub) {
+	for {
+		select {
+		case <-stub.Interrupt:
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				stub.Close()
+				return
+			}
+			if stub.Affected(c) && stub.Rand.Float64() < t.Probability {
+				t.corrupt(stub.Rand, c.Data)
+			}
+			stub.Output <- c
+		}
+	}
+}
+
+func init() {
+	Register("corrupt", new(CorruptToxic))
+}
@@ -0,0 +1,79 @@
+package toxics_test
+
+import (
+	"bytes"
+	"math/bits"
+	"testing"
+
+	"github.com/badrootd/udpcrusher/stream"
+	"github.com/badrootd/udpcrusher/toxics"
+)
+
+func DoCorruptTest(t *testing.T, corrupt *toxics.CorruptToxic, data []byte) []byte {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 1)
+	stub := toxics.NewToxicStub(input, output)
+
+	done := make(chan bool)
+	go func() {
+		corrupt.Pipe(stub)
+		done <- true
+	}()
+
+	payload := make([]byte, len(data))
+	copy(payload, data)
+	input <- &stream.StreamChunk{Data: payload}
+	close(input)
+	<-done
+
+	c := <-output
+	if len(c.Data) != len(data) {
+		t.Fatalf("Corruption changed the datagram length: %d != %d", len(c.Data), len(data))
+	}
+	return c.Data
+}
+
+func TestCorruptToxicBitFlip(t *testing.T) {
+	data := []byte("hello world")
+	corrupted := DoCorruptTest(t, &toxics.CorruptToxic{Probability: 1, Count: 1}, data)
+
+	flipped := 0
+	for i := range data {
+		flipped += bits.OnesCount8(data[i] ^ corrupted[i])
+	}
+	if flipped != 1 {
+		t.Fatalf("Expected exactly one flipped bit, got %d", flipped)
+	}
+}
+
+func TestCorruptToxicZero(t *testing.T) {
+	corrupted := DoCorruptTest(t, &toxics.CorruptToxic{
+		Mode:        "zero",
+		Probability: 1,
+		Offset:      2,
+		Length:      3,
+	}, []byte("hello world"))
+
+	expected := []byte("he\x00\x00\x00 world")
+	if !bytes.Equal(corrupted, expected) {
+		t.Fatalf("Expected %q, got %q", expected, corrupted)
+	}
+}
+
+func TestCorruptToxicNoProbability(t *testing.T) {
+	data := []byte("hello world")
+	corrupted := DoCorruptTest(t, &toxics.CorruptToxic{Mode: "overwrite", Count: 5}, data)
+
+	if !bytes.Equal(corrupted, data) {
+		t.Fatalf("Expected datagram to be untouched, got %q", corrupted)
+	}
+}
+
+func TestCorruptToxicValidate(t *testing.T) {
+	if err := (&toxics.CorruptToxic{Mode: "zero"}).Validate(); err != nil {
+		t.Fatal("Expected the zero mode to be valid, got:", err)
+	}
+	if err := (&toxics.CorruptToxic{Mode: "bitflip"}).Validate(); err == nil {
+		t.Fatal("Expected an unknown mode to be invalid")
+	}
+}
@@ -0,0 +1,34 @@
+package toxics
+
+import (
+	"encoding/json"
+	"sync/atomic"
+)
+
+// Counter is a statistic that toxics report in their attributes. It can be
+// incremented by every link running the toxic while the API reads it, and it
+// ignores values written by the API.
+type Counter struct {
+	value atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) Load() uint64 {
+	return c.value.Load()
+}
+
+// carry continues counting from the value of previous.
+func (c *Counter) carry(previous *Counter) {
+	c.value.Store(previous.Load())
+}
+
+func (c *Counter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.value.Load())
+}
+
+func (c *Counter) UnmarshalJSON(data []byte) error {
+	return nil
+}
@@ -0,0 +1,73 @@
+package toxics
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/badrootd/udpcrusher/stream"
+)
+
+// delayQueue holds chunks until their scheduled release time. Chunks scheduled
+// for the same time are released in the order they were pushed.
+type delayQueue struct {
+	items delayItems
+	seq   uint64
+}
+
+type delayItem struct {
+	chunk *stream.StreamChunk
+	at    time.Time
+	seq   uint64
+}
+
+type delayItems []delayItem
+
+func (d delayItems) Len() int { return len(d) }
+func (d delayItems) Less(i, j int) bool {
+	if d[i].at.Equal(d[j].at) {
+		return d[i].seq < d[j].seq
+	}
+	return d[i].at.Before(d[j].at)
+}
+func (d delayItems) Swap(i, j int)       { d[i], d[j] = d[j], d[i] }
+func (d *delayItems) Push(x interface{}) { *d = append(*d, x.(delayItem)) }
+func (d *delayItems) Pop() interface{} {
+	old := *d
+	item := old[len(old)-1]
+	*d = old[:len(old)-1]
+	return item
+}
+
+// Push schedules a chunk to be released at the given time.
+func (q *delayQueue) Push(c *stream.StreamChunk, at time.Time) {
+	q.seq++
+	heap.Push(&q.items, delayItem{c, at, q.seq})
+}
+
+func (q *delayQueue) Len() int {
+	return len(q.items)
+}
+
+// Peek returns the chunk with the earliest release time without removing it.
+func (q *delayQueue) Peek() *stream.StreamChunk {
+	return q.items[0].chunk
+}
+
+// Pop removes and returns the chunk with the earliest release time.
+func (q *delayQueue) Pop() *stream.StreamChunk {
+	return heap.Pop(&q.items).(delayItem).chunk
+}
+
+// Due reports whether the earliest chunk is ready to be released.
+func (q *delayQueue) Due(now time.Time) bool {
+	return len(q.items) > 0 && !q.items[0].at.After(now)
+}
+
+// Timer returns a channel that fires when the earliest chunk is due, or nil if
+// the queue is empty so that selecting on it blocks forever.
+func (q *delayQueue) Timer() <-chan time.Time {
+	if len(q.items) == 0 {
+		return nil
+	}
+	return time.After(time.Until(q.items[0].at))
+}
@@ -0,0 +1,366 @@
+package toxics
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/badrootd/udpcrusher/stream"
+)
+
+// The DNS toxics parse the datagrams of a proxy as DNS messages to fake the bad
+// answers resolvers have to cope with. Datagrams that aren't DNS messages, or
+// whose question doesn't match the toxic's qname and qtype, pass unchanged.
+// Apart from dns_drop they only touch responses, so they are meant for the
+// downstream stream.
+
+// DnsQuestion selects the DNS messages a toxic acts on by their question.
+type DnsQuestion struct {
+	// Query name, e.g. example.com, *.example.com matches its subdomains. Empty
+	// matches every name
+	Qname string `json:"qname"`
+	// Query type, e.g. A or AAAA. Empty matches every type
+	Qtype string `json:"qtype"`
+}
+
+func (q *DnsQuestion) Validate() error {
+	if _, ok := dns.StringToType[strings.ToUpper(q.Qtype)]; q.Qtype != "" && !ok {
+		return fmt.Errorf("unknown qtype %q", q.Qtype)
+	}
+	return nil
+}
+
+// matches reports whether the question of msg is selected.
+func (q *DnsQuestion) matches(msg *dns.Msg) bool {
+	if len(msg.Question) == 0 {
+		return q.Qname == "" && q.Qtype == ""
+	}
+	question := msg.Question[0]
+
+	if q.Qtype != "" && dns.StringToType[strings.ToUpper(q.Qtype)] != question.Qtype {
+		return false
+	}
+	if q.Qname == "" {
+		return true
+	}
+	if parent, ok := strings.CutPrefix(q.Qname, "*."); ok {
+		parent = dns.Fqdn(parent)
+		return !strings.EqualFold(parent, question.Name) && dns.IsSubDomain(parent, question.Name)
+	}
+	return strings.EqualFold(dns.Fqdn(q.Qname), question.Name)
+}
+
+// parse returns the DNS message of c if it's selected by the question. With
+// responses set only responses are returned.
+func (q *DnsQuestion) parse(c *stream.StreamChunk, responses bool) *dns.Msg {
+	msg := new(dns.Msg)
+	if msg.Unpack(c.Data) != nil {
+		return nil
+	}
+	if responses && !msg.Response {
+		return nil
+	}
+	if !q.matches(msg) {
+		return nil
+	}
+	return msg
+}
+
+// repack replaces the payload of c with msg, c is left untouched if msg can't be
+// packed.
+func repack(c *stream.StreamChunk, msg *dns.Msg) {
+	msg.Compress = true
+	if data, err := msg.Pack(); err == nil {
+		c.Data = data
+	}
+}
+
+// clearRecords removes all records of msg but the EDNS0 OPT record.
+func clearRecords(msg *dns.Msg) {
+	opt := msg.IsEdns0()
+	msg.Answer, msg.Ns, msg.Extra = nil, nil, nil
+	if opt != nil {
+		msg.Extra = []dns.RR{opt}
+	}
+}
+
+// The DnsDropToxic drops the DNS messages matching its question, e.g. queries
+// for a name so the resolver times out and retries.
+type DnsDropToxic struct {
+	DnsQuestion
+}
+
+func (t *DnsDropToxic) Pipe(stub *ToxicStub) {
+	for {
+		select {
+		case <-stub.Interrupt:
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				stub.Close()
+				return
+			}
+			if stub.Affected(c) && t.parse(c, false) != nil {
+				stub.Drop()
+				continue
+			}
+			stub.Output <- c
+		}
+	}
+}
+
+// The DnsRcodeToxic turns the matching responses into errors, removing their
+// records.
+type DnsRcodeToxic struct {
+	DnsQuestion
+	// Response code, e.g. SERVFAIL (default), NXDOMAIN or REFUSED
+	Rcode string `json:"rcode"`
+}
+
+func (t *DnsRcodeToxic) Validate() error {
+	if _, ok := dns.StringToRcode[strings.ToUpper(t.Rcode)]; t.Rcode != "" && !ok {
+		return fmt.Errorf("unknown rcode %q", t.Rcode)
+	}
+	return t.DnsQuestion.Validate()
+}
+
+func (t *DnsRcodeToxic) rcode() int {
+	if rcode, ok := dns.StringToRcode[strings.ToUpper(t.Rcode)]; ok {
+		return rcode
+	}
+	return dns.RcodeServerFailure
+}
+
+func (t *DnsRcodeToxic) Pipe(stub *ToxicStub) {
+	for {
+		select {
+		case <-stub.Interrupt:
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				stub.Close()
+				return
+			}
+			if !stub.Affected(c) {
+				stub.Output <- c
+				continue
+			}
+			if msg := t.parse(c, true); msg != nil {
+				clearRecords(msg)
+				msg.Rcode = t.rcode()
+				repack(c, msg)
+			}
+			stub.Output <- c
+		}
+	}
+}
+
+// The DnsTruncateToxic sets the TC bit on the matching responses and removes
+// records, so resolvers have to fall back to TCP.
+type DnsTruncateToxic struct {
+	DnsQuestion
+	// Largest response in bytes, larger ones are truncated to fit, at least
+	// 512. 0 truncates all responses and removes all their records but the
+	// EDNS0 OPT record
+	Size int `json:"size"`
+}
+
+func (t *DnsTruncateToxic) Validate() error {
+	if t.Size < 0 || t.Size > 0 && t.Size < 512 {
+		return fmt.Errorf("size %d is neither 0 nor at least 512", t.Size)
+	}
+	return t.DnsQuestion.Validate()
+}
+
+func (t *DnsTruncateToxic) Pipe(stub *ToxicStub) {
+	for {
+		select {
+		case <-stub.Interrupt:
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				stub.Close()
+				return
+			}
+			if !stub.Affected(c) {
+				stub.Output <- c
+				continue
+			}
+			if msg := t.parse(c, true); msg != nil {
+				if t.Size == 0 {
+					clearRecords(msg)
+					msg.Truncated = true
+					repack(c, msg)
+				} else if len(c.Data) > t.Size {
+					msg.Truncate(t.Size)
+					repack(c, msg)
+				}
+			}
+			stub.Output <- c
+		}
+	}
+}
+
+// The DnsRewriteToxic rewrites the answers of the matching responses, e.g. to
+// point a name to a wrong or unreachable address.
+type DnsRewriteToxic struct {
+	DnsQuestion
+	// Address the A records (IPv4) or AAAA records (IPv6) answer with. Empty
+	// keeps the addresses
+	Ip string `json:"ip"`
+	// TTL in seconds of all answer records, null keeps the TTLs
+	Ttl *uint32 `json:"ttl"`
+}
+
+func (t *DnsRewriteToxic) Validate() error {
+	if t.Ip != "" && net.ParseIP(t.Ip) == nil {
+		return fmt.Errorf("invalid ip %q", t.Ip)
+	}
+	return t.DnsQuestion.Validate()
+}
+
+func (t *DnsRewriteToxic) rewrite(msg *dns.Msg) {
+	ip := net.ParseIP(t.Ip)
+	for _, rr := range msg.Answer {
+		switch rr := rr.(type) {
+		case *dns.A:
+			if ip != nil && ip.To4() != nil {
+				rr.A = ip.To4()
+			}
+		case *dns.AAAA:
+			if ip != nil && ip.To4() == nil {
+				rr.AAAA = ip
+			}
+		}
+		if t.Ttl != nil {
+			rr.Header().Ttl = *t.Ttl
+		}
+	}
+}
+
+func (t *DnsRewriteToxic) Pipe(stub *ToxicStub) {
+	for {
+		select {
+		case <-stub.Interrupt:
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				stub.Close()
+				return
+			}
+			if !stub.Affected(c) {
+				stub.Output <- c
+				continue
+			}
+			if msg := t.parse(c, true); msg != nil {
+				t.rewrite(msg)
+				repack(c, msg)
+			}
+			stub.Output <- c
+		}
+	}
+}
+
+const (
+	DnsSwapIdModeSwap   = "swap"
+	DnsSwapIdModeRandom = "random"
+)
+
+// The DnsSwapIdToxic breaks the transaction IDs of the matching responses, so
+// resolvers have to discard responses that don't belong to their queries. The
+// swap mode holds a response back until the next one arrives and exchanges
+// their IDs, the random mode replaces the ID with a random one.
+type DnsSwapIdToxic struct {
+	DnsQuestion
+	Seeded
+	// Mode, swap (default) or random
+	Mode string `json:"mode"`
+	// Milliseconds a held response waits for the next one in the swap mode,
+	// after which it passes unchanged. 0 waits up to 1 second
+	Wait int64 `json:"wait"`
+}
+
+func (t *DnsSwapIdToxic) GetBufferSize() int {
+	return 1024
+}
+
+func (t *DnsSwapIdToxic) Validate() error {
+	switch t.Mode {
+	case "", DnsSwapIdModeSwap, DnsSwapIdModeRandom:
+		return t.DnsQuestion.Validate()
+	}
+	return fmt.Errorf("unknown mode %q", t.Mode)
+}
+
+func (t *DnsSwapIdToxic) wait() time.Duration {
+	if t.Wait <= 0 {
+		return time.Second
+	}
+	return time.Duration(t.Wait) * time.Millisecond
+}
+
+func (t *DnsSwapIdToxic) Pipe(stub *ToxicStub) {
+	var held *stream.StreamChunk
+	var heldMsg *dns.Msg
+	var timeout <-chan time.Time
+
+	for {
+		select {
+		case <-stub.Interrupt:
+			if held != nil {
+				stub.Output <- held
+			}
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				if held != nil {
+					stub.Output <- held
+				}
+				stub.Close()
+				return
+			}
+			if !stub.Affected(c) {
+				stub.Output <- c
+				continue
+			}
+			msg := t.parse(c, true)
+			if msg == nil {
+				stub.Output <- c
+				continue
+			}
+
+			if t.Mode == DnsSwapIdModeRandom {
+				msg.Id ^= uint16(1 + stub.Rand.Intn(0xffff))
+				repack(c, msg)
+				stub.Output <- c
+				continue
+			}
+
+			if held == nil {
+				held, heldMsg = c, msg
+				timeout = time.After(t.wait())
+				continue
+			}
+			msg.Id, heldMsg.Id = heldMsg.Id, msg.Id
+			repack(held, heldMsg)
+			repack(c, msg)
+			stub.Output <- held
+			stub.Output <- c
+			held, heldMsg, timeout = nil, nil, nil
+		case <-timeout:
+			stub.Output <- held
+			held, heldMsg, timeout = nil, nil, nil
+		}
+	}
+}
+
+func init() {
+	Register("dns_drop", new(DnsDropToxic))
+	Register("dns_rcode", new(DnsRcodeToxic))
+	Register("dns_truncate", new(DnsTruncateToxic))
+	Register("dns_rewrite", new(DnsRewriteToxic))
+	Register("dns_swap_id", new(DnsSwapIdToxic))
+}
@@ -0,0 +1,249 @@
+package toxics_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/badrootd/udpcrusher/stream"
+	"github.com/badrootd/udpcrusher/toxics"
+)
+
+func DoDnsTest(t *testing.T, toxic toxics.Toxic, messages ...*dns.Msg) []*dns.Msg {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, len(messages))
+	stub := toxics.NewToxicStub(input, output)
+
+	done := make(chan bool)
+	go func() {
+		toxic.Pipe(stub)
+		done <- true
+	}()
+
+	for _, msg := range messages {
+		data, err := msg.Pack()
+		if err != nil {
+			t.Fatal("Unable to pack message:", err)
+		}
+		input <- &stream.StreamChunk{Data: data}
+	}
+	close(input)
+	<-done
+
+	var received []*dns.Msg
+	for c := range output {
+		msg := new(dns.Msg)
+		if err := msg.Unpack(c.Data); err != nil {
+			t.Fatal("Unable to unpack message:", err)
+		}
+		received = append(received, msg)
+	}
+	return received
+}
+
+func DnsQuery(name string, qtype uint16) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	return msg
+}
+
+func DnsResponse(name string, ips ...string) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetReply(DnsQuery(name, dns.TypeA))
+	for _, ip := range ips {
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.ParseIP(ip),
+		})
+	}
+	return msg
+}
+
+func TestDnsDropToxic(t *testing.T) {
+	received := DoDnsTest(t,
+		&toxics.DnsDropToxic{DnsQuestion: toxics.DnsQuestion{Qname: "*.example.com", Qtype: "aaaa"}},
+		DnsQuery("www.example.com.", dns.TypeAAAA),
+		DnsQuery("www.example.com.", dns.TypeA),
+		DnsQuery("example.com.", dns.TypeAAAA),
+		DnsQuery("WWW.Example.COM.", dns.TypeAAAA),
+	)
+
+	if len(received) != 2 {
+		t.Fatalf("Expected 2 messages to pass, got %d", len(received))
+	}
+	if received[0].Question[0].Qtype != dns.TypeA || received[1].Question[0].Name != "example.com." {
+		t.Fatal("Wrong messages passed:", received)
+	}
+}
+
+func TestDnsRcodeToxic(t *testing.T) {
+	received := DoDnsTest(t,
+		&toxics.DnsRcodeToxic{DnsQuestion: toxics.DnsQuestion{Qname: "example.com"}, Rcode: "nxdomain"},
+		DnsQuery("example.com.", dns.TypeA),
+		DnsResponse("example.com.", "10.0.0.1"),
+		DnsResponse("example.org.", "10.0.0.2"),
+	)
+
+	if received[0].Response || received[0].Rcode != dns.RcodeSuccess {
+		t.Fatal("Expected the query to pass unchanged, got:", received[0])
+	}
+	if received[1].Rcode != dns.RcodeNameError || len(received[1].Answer) != 0 {
+		t.Fatal("Expected an empty NXDOMAIN response, got:", received[1])
+	}
+	if received[2].Rcode != dns.RcodeSuccess || len(received[2].Answer) != 1 {
+		t.Fatal("Expected the other response to pass unchanged, got:", received[2])
+	}
+
+	received = DoDnsTest(t, &toxics.DnsRcodeToxic{}, DnsResponse("example.com.", "10.0.0.1"))
+	if received[0].Rcode != dns.RcodeServerFailure {
+		t.Fatal("Expected SERVFAIL by default, got:", received[0])
+	}
+}
+
+func TestDnsTruncateToxic(t *testing.T) {
+	opt := DnsResponse("example.com.", "10.0.0.1")
+	opt.SetEdns0(1232, false)
+	received := DoDnsTest(t, &toxics.DnsTruncateToxic{}, opt)
+
+	if !received[0].Truncated || len(received[0].Answer) != 0 {
+		t.Fatal("Expected an empty truncated response, got:", received[0])
+	}
+	if received[0].IsEdns0() == nil {
+		t.Fatal("Expected the OPT record to be kept")
+	}
+
+	ips := make([]string, 100)
+	for i := range ips {
+		ips[i] = net.IPv4(10, 0, 0, byte(i)).String()
+	}
+	received = DoDnsTest(t,
+		&toxics.DnsTruncateToxic{Size: 512},
+		DnsResponse("example.com.", "10.0.0.1"),
+		DnsResponse("example.com.", ips...),
+	)
+
+	if received[0].Truncated || len(received[0].Answer) != 1 {
+		t.Fatal("Expected the small response to pass unchanged, got:", received[0])
+	}
+	if !received[1].Truncated || len(received[1].Answer) == 0 || len(received[1].Answer) == len(ips) {
+		t.Fatal("Expected the large response to be truncated, got answers:", len(received[1].Answer))
+	}
+	received[1].Compress = true
+	if size := received[1].Len(); size > 512 {
+		t.Fatalf("Expected the truncated response to fit 512 bytes, got %d", size)
+	}
+}
+
+func TestDnsRewriteToxic(t *testing.T) {
+	ttl := uint32(0)
+	received := DoDnsTest(t,
+		&toxics.DnsRewriteToxic{Ip: "192.0.2.1", Ttl: &ttl},
+		DnsResponse("example.com.", "10.0.0.1", "10.0.0.2"),
+	)
+
+	for _, rr := range received[0].Answer {
+		a := rr.(*dns.A)
+		if !a.A.Equal(net.ParseIP("192.0.2.1")) || a.Hdr.Ttl != 0 {
+			t.Fatal("Expected the answer to be rewritten, got:", a)
+		}
+	}
+
+	received = DoDnsTest(t,
+		&toxics.DnsRewriteToxic{Ip: "2001:db8::1"},
+		DnsResponse("example.com.", "10.0.0.1"),
+	)
+	a := received[0].Answer[0].(*dns.A)
+	if !a.A.Equal(net.ParseIP("10.0.0.1")) || a.Hdr.Ttl != 300 {
+		t.Fatal("Expected an IPv6 address to leave A records alone, got:", a)
+	}
+}
+
+func TestDnsSwapIdToxic(t *testing.T) {
+	first, second := DnsResponse("example.com.", "10.0.0.1"), DnsResponse("example.com.", "10.0.0.2")
+	first.Id, second.Id = 1, 2
+	received := DoDnsTest(t, &toxics.DnsSwapIdToxic{}, first, second)
+
+	if len(received) != 2 || received[0].Id != 2 || received[1].Id != 1 {
+		t.Fatal("Expected the IDs to be swapped, got:", received)
+	}
+	if received[0].Answer[0].(*dns.A).A.String() != "10.0.0.1" {
+		t.Fatal("Expected the responses to keep their order")
+	}
+
+	received = DoDnsTest(t, &toxics.DnsSwapIdToxic{Mode: "random"}, first)
+	if received[0].Id == first.Id {
+		t.Fatal("Expected a random ID")
+	}
+}
+
+func TestDnsSwapIdToxicWait(t *testing.T) {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 1)
+	stub := toxics.NewToxicStub(input, output)
+	go (&toxics.DnsSwapIdToxic{Wait: 10}).Pipe(stub)
+	defer close(input)
+
+	msg := DnsResponse("example.com.", "10.0.0.1")
+	msg.Id = 7
+	data, _ := msg.Pack()
+	input <- &stream.StreamChunk{Data: data}
+
+	c := <-output
+	if err := msg.Unpack(c.Data); err != nil || msg.Id != 7 {
+		t.Fatal("Expected a lone response to pass unchanged after the wait")
+	}
+}
+
+func TestDnsToxicsPassOtherDatagrams(t *testing.T) {
+	for _, toxic := range []toxics.Toxic{
+		new(toxics.DnsDropToxic),
+		new(toxics.DnsRcodeToxic),
+		new(toxics.DnsTruncateToxic),
+		new(toxics.DnsRewriteToxic),
+		new(toxics.DnsSwapIdToxic),
+	} {
+		input := make(chan *stream.StreamChunk)
+		output := make(chan *stream.StreamChunk, 1)
+		stub := toxics.NewToxicStub(input, output)
+		go toxic.Pipe(stub)
+
+		input <- &stream.StreamChunk{Data: []byte("not dns")}
+		c := <-output
+		if string(c.Data) != "not dns" {
+			t.Fatalf("%T changed a datagram that isn't DNS: %q", toxic, c.Data)
+		}
+		close(input)
+	}
+}
+
+func TestDnsToxicsValidate(t *testing.T) {
+	for _, toxic := range []toxics.ValidatedToxic{
+		&toxics.DnsDropToxic{DnsQuestion: toxics.DnsQuestion{Qtype: "aaaa"}},
+		&toxics.DnsRcodeToxic{Rcode: "nxdomain"},
+		&toxics.DnsRcodeToxic{},
+		&toxics.DnsTruncateToxic{DnsQuestion: toxics.DnsQuestion{Qtype: "TXT"}},
+		&toxics.DnsTruncateToxic{Size: 512},
+		&toxics.DnsRewriteToxic{Ip: "2001:db8::1"},
+		&toxics.DnsSwapIdToxic{Mode: "random"},
+	} {
+		if err := toxic.Validate(); err != nil {
+			t.Errorf("Expected %+v to be valid, got: %v", toxic, err)
+		}
+	}
+
+	for _, toxic := range []toxics.ValidatedToxic{
+		&toxics.DnsDropToxic{DnsQuestion: toxics.DnsQuestion{Qtype: "aaa"}},
+		&toxics.DnsRcodeToxic{Rcode: "nxdomian"},
+		&toxics.DnsRcodeToxic{DnsQuestion: toxics.DnsQuestion{Qtype: "walrus"}},
+		&toxics.DnsTruncateToxic{DnsQuestion: toxics.DnsQuestion{Qtype: "walrus"}},
+		&toxics.DnsTruncateToxic{Size: -1},
+		&toxics.DnsTruncateToxic{Size: 100},
+		&toxics.DnsRewriteToxic{Ip: "192.0.2"},
+		&toxics.DnsSwapIdToxic{Mode: "shuffle"},
+	} {
+		if err := toxic.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", toxic)
+		}
+	}
+}
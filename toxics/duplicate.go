@@ -0,0 +1,95 @@
+package toxics
+
+import (
+	"time"
+
+	"github.com/badrootd/udpcrusher/stream"
+)
+
+// The DuplicateToxic sends extra copies of datagrams, like a network that
+// retransmits frames at the link layer.
+type DuplicateToxic struct {
+	Seeded
+	// Probability to duplicate a datagram
+	Probability float64 `json:"probability"`
+	// Number of extra copies of a duplicated datagram, at least one is sent
+	Copies int `json:"copies"`
+	// Milliseconds between the copies of a datagram
+	Delay int64 `json:"delay"`
+}
+
+type DuplicateToxicState struct {
+	// Copies waiting for their delay
+	copies delayQueue
+}
+
+func (t *DuplicateToxic) GetBufferSize() int {
+	return 1024
+}
+
+func (t *DuplicateToxic) NewState() interface{} {
+	return new(DuplicateToxicState)
+}
+
+// duplicate schedules the copies of a datagram on the queue.
+func (t *DuplicateToxic) duplicate(c *stream.StreamChunk, copies *delayQueue) {
+	count := t.Copies
+	if count < 1 {
+		count = 1
+	}
+	delay := time.Duration(t.Delay) * time.Millisecond
+	now := time.Now()
+	for i := 1; i <= count; i++ {
+		data := make([]byte, len(c.Data))
+		copy(data, c.Data)
+		copies.Push(&stream.StreamChunk{
+			Data:      data,
+			Timestamp: c.Timestamp,
+		}, now.Add(time.Duration(i)*delay))
+	}
+}
+
+func (t *DuplicateToxic) Pipe(stub *ToxicStub) {
+	state := stub.State.(*DuplicateToxicState)
+
+	for {
+		select {
+		case <-stub.Interrupt:
+			// Pending copies stay in the state until the toxic runs again
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				t.Cleanup(stub)
+				stub.Close()
+				return
+			}
+			if stub.Affected(c) && stub.Rand.Float64() < t.Probability {
+				t.duplicate(c, &state.copies)
+			}
+			stub.Output <- c
+		case <-state.copies.Timer():
+		}
+
+		for state.copies.Due(time.Now()) {
+			select {
+			case stub.Output <- state.copies.Peek():
+				state.copies.Pop()
+			case <-stub.Interrupt:
+				return
+			}
+		}
+	}
+}
+
+// Cleanup sends all pending copies right away, so removing the toxic doesn't
+// drop them.
+func (t *DuplicateToxic) Cleanup(stub *ToxicStub) {
+	state := stub.State.(*DuplicateToxicState)
+	for state.copies.Len() > 0 {
+		stub.Output <- state.copies.Pop()
+	}
+}
+
+func init() {
+	Register("duplicate", new(DuplicateToxic))
+}
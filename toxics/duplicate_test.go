@@ -0,0 +1,130 @@
+package toxics_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/badrootd/udpcrusher/stream"
+	"github.com/badrootd/udpcrusher/toxics"
+)
+
+func TestDuplicateToxic(t *testing.T) {
+	duplicate := &toxics.DuplicateToxic{Probability: 1, Copies: 2}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 100)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = duplicate.NewState()
+
+	done := make(chan bool)
+	go func() {
+		duplicate.Pipe(stub)
+		done <- true
+	}()
+
+	input <- &stream.StreamChunk{Data: []byte("hello")}
+	input <- &stream.StreamChunk{Data: []byte("world")}
+	close(input)
+	<-done
+
+	expected := []string{"hello", "hello", "hello", "world", "world", "world"}
+	received := 0
+	for c := range output {
+		if received >= len(expected) {
+			t.Fatalf("Received more than %d datagrams", len(expected))
+		}
+		if !bytes.Equal(c.Data, []byte(expected[received])) {
+			t.Fatalf("Expected datagram %d to be %q, got %q", received, expected[received], c.Data)
+		}
+		received++
+	}
+	if received != len(expected) {
+		t.Fatalf("Expected %d datagrams, got %d", len(expected), received)
+	}
+}
+
+func TestDuplicateToxicDelay(t *testing.T) {
+	duplicate := &toxics.DuplicateToxic{Probability: 1, Copies: 1, Delay: 100}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 100)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = duplicate.NewState()
+
+	go duplicate.Pipe(stub)
+	defer close(input)
+
+	start := time.Now()
+	input <- &stream.StreamChunk{Data: []byte("hello")}
+
+	<-output
+	AssertDeltaTime(t, "Original", time.Since(start), 0, 20*time.Millisecond)
+
+	c := <-output
+	AssertDeltaTime(t, "Copy", time.Since(start), 100*time.Millisecond, 20*time.Millisecond)
+	if !bytes.Equal(c.Data, []byte("hello")) {
+		t.Fatalf("Copy has wrong data: %q", c.Data)
+	}
+}
+
+func TestDuplicateToxicNoProbability(t *testing.T) {
+	duplicate := &toxics.DuplicateToxic{Probability: 0, Copies: 3}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 100)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = duplicate.NewState()
+
+	done := make(chan bool)
+	go func() {
+		duplicate.Pipe(stub)
+		done <- true
+	}()
+
+	for i := 0; i < 10; i++ {
+		input <- &stream.StreamChunk{Data: []byte{byte(i)}}
+	}
+	close(input)
+	<-done
+
+	if len(output) != 10 {
+		t.Fatalf("Expected no duplicates, got %d datagrams", len(output))
+	}
+}
+
+func TestDuplicateToxicKeepsCopiesOnUpdate(t *testing.T) {
+	duplicate := &toxics.DuplicateToxic{Probability: 1, Copies: 1, Delay: 100}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 100)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = duplicate.NewState()
+	defer close(input)
+
+	done := make(chan bool)
+	go func() {
+		duplicate.Pipe(stub)
+		done <- true
+	}()
+
+	start := time.Now()
+	input <- &stream.StreamChunk{Data: []byte("hello")}
+	<-output
+
+	// Updating the toxic interrupts and restarts it
+	stub.Interrupt <- struct{}{}
+	<-done
+	if len(output) != 0 {
+		t.Fatal("Expected the pending copy to wait for its delay after the interrupt")
+	}
+	duplicate.Probability = 0
+	go duplicate.Pipe(stub)
+
+	select {
+	case <-output:
+		AssertDeltaTime(t, "Copy after update", time.Since(start), 100*time.Millisecond, 20*time.Millisecond)
+	case <-time.After(time.Second):
+		t.Fatal("Pending copy was lost on update")
+	}
+}
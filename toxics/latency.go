@@ -3,55 +3,129 @@ package toxics
 import (
 	"math/rand"
 	"time"
+
+	"github.com/badrootd/udpcrusher/stream"
 )
 
 // The LatencyToxic passes data through with the a delay of latency +/- jitter added.
+// It is a delay line: every datagram is scheduled at its arrival plus its delay
+// independently, so the throughput of the link is preserved. Datagrams keep
+// their order unless reordering is enabled, in which case a jitter larger than
+// the gap between datagrams lets them overtake each other.
 type LatencyToxic struct {
+	Seeded
 	// Times in milliseconds
 	Latency int64 `json:"latency"`
 	Jitter  int64 `json:"jitter"`
+	// Let datagrams with a smaller delay overtake earlier ones
+	Reorder bool `json:"reorder"`
+	// Maximum number of datagrams in flight, further ones are dropped. 0 is
+	// unlimited
+	Limit int `json:"limit"`
+}
+
+type LatencyToxicState struct {
+	queue *delayQueue
+	// Release time of the latest datagram, later ones don't leave before it
+	// unless reordering is enabled
+	last time.Time
 }
 
 func (t *LatencyToxic) GetBufferSize() int {
 	return 1024
 }
 
-func (t *LatencyToxic) delay() time.Duration {
+func (t *LatencyToxic) NewState() interface{} {
+	return &LatencyToxicState{queue: new(delayQueue)}
+}
+
+func (t *LatencyToxic) delay(r *rand.Rand) time.Duration {
 	// Delay = t.Latency +/- t.Jitter
 	delay := t.Latency
 	jitter := t.Jitter
 	if jitter > 0 {
-		//#nosec
-		delay += rand.Int63n(jitter*2) - jitter
+		delay += r.Int63n(jitter*2) - jitter
 	}
 	return time.Duration(delay) * time.Millisecond
 }
 
+// schedule puts the datagram into the delay line, or drops it if the line is
+// full.
+func (t *LatencyToxic) schedule(stub *ToxicStub, state *LatencyToxicState, c *stream.StreamChunk) {
+	if t.Limit > 0 && state.queue.Len() >= t.Limit {
+		stub.Drop()
+		return
+	}
+
+	arrival := c.Timestamp
+	if arrival.IsZero() {
+		arrival = time.Now()
+	}
+	release := arrival.Add(t.delay(stub.Rand))
+	if !t.Reorder && release.Before(state.last) {
+		release = state.last
+	}
+	if release.After(state.last) {
+		state.last = release
+	}
+
+	stub.Delay(release.Sub(arrival))
+	c.Timestamp = release
+	state.queue.Push(c, release)
+}
+
+// release sends every datagram whose release time has passed. It returns false
+// if the toxic was interrupted, the unsent datagrams stay in the queue.
+func (t *LatencyToxic) release(stub *ToxicStub, state *LatencyToxicState) bool {
+	for state.queue.Due(time.Now()) {
+		select {
+		case stub.Output <- state.queue.Peek():
+			state.queue.Pop()
+		case <-stub.Interrupt:
+			return false
+		}
+	}
+	return true
+}
+
 func (t *LatencyToxic) Pipe(stub *ToxicStub) {
+	state := stub.State.(*LatencyToxicState)
+
 	for {
 		select {
 		case <-stub.Interrupt:
+			// Datagrams in flight stay in the state until the toxic runs again
 			return
 		case c := <-stub.Input:
 			if c == nil {
+				t.Cleanup(stub)
 				stub.Close()
 				return
 			}
-
-			sleep := t.delay() - time.Since(c.Timestamp)
-			select {
-			case <-time.After(sleep):
-				c.Timestamp = c.Timestamp.Add(sleep)
+			if !stub.Affected(c) {
 				stub.Output <- c
-			case <-stub.Interrupt:
-				// Exit fast without applying latency.
-				stub.Output <- c // Don't drop any data on the floor
-				return
+				continue
 			}
+			t.schedule(stub, state, c)
+		case <-state.queue.Timer():
+		}
+
+		if !t.release(stub, state) {
+			return
 		}
 	}
 }
 
+// Cleanup sends all datagrams in flight right away, so removing the toxic
+// doesn't drop them.
+func (t *LatencyToxic) Cleanup(stub *ToxicStub) {
+	state := stub.State.(*LatencyToxicState)
+	for state.queue.Len() > 0 {
+		stub.Output <- state.queue.Pop()
+	}
+	state.last = time.Time{}
+}
+
 func init() {
 	Register("latency", new(LatencyToxic))
 }
@@ -277,6 +277,22 @@ func TestLatencyToxicBandwidth(t *testing.T) {
 		}
 	}(upstreamConn, writtenPayload)
 
+	response := make([]byte, len(writtenPayload))
+	read := func() time.Duration {
+		start := time.Now()
+		for i := 0; i < 100; i++ {
+			if _, err := io.ReadFull(client, response); err != nil {
+				t.Fatalf("Could not read from socket: %v", err)
+			}
+		}
+		return time.Since(start)
+	}
+
+	// The rate a flooded link reaches depends on the machine, so the toxic is
+	// measured against the same link without it
+	time.Sleep(50 * time.Millisecond)
+	unaffected := read()
+
 	wrapper := &toxics.ToxicWrapper{
 		Toxic:  &toxics.LatencyToxic{Latency: 100},
 		Name:   "",
@@ -289,26 +305,15 @@ func TestLatencyToxicBandwidth(t *testing.T) {
 	}
 
 	time.Sleep(150 * time.Millisecond) // Wait for latency toxic
-	response := make([]byte, len(writtenPayload))
-
-	start := time.Now()
-	count := 0
-	for i := 0; i < 100; i++ {
-		n, err := io.ReadFull(client, response)
-		if err != nil {
-			t.Fatalf("Could not read from socket: %v", err)
-			break
-		}
-		count += n
-	}
 
-	// Assert the transfer was at least 100MB/s
+	// Assert the delay line didn't slow the transfer down once its latency was
+	// paid
 	AssertDeltaTime(
 		t,
 		"Latency toxic bandwidth",
-		time.Since(start),
-		0,
-		time.Duration(count/100000)*time.Millisecond,
+		read(),
+		unaffected,
+		unaffected+10*time.Millisecond,
 	)
 
 	err = client.Close()
@@ -391,3 +396,105 @@ func WithEchoServer(t *testing.T, f func(string, chan []byte)) {
 
 	close(response)
 }
+
+func StartLatencyStub(latency *toxics.LatencyToxic) (chan *stream.StreamChunk, chan *stream.StreamChunk, *toxics.ToxicStub) {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 1000)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = latency.NewState()
+	go latency.Pipe(stub)
+	return input, output, stub
+}
+
+func TestLatencyToxicPreservesThroughput(t *testing.T) {
+	input, output, _ := StartLatencyStub(&toxics.LatencyToxic{Latency: 100})
+	defer close(input)
+
+	// 100 datagrams over ~100ms each leave 100ms after their arrival, instead
+	// of queueing up behind each other
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		input <- &stream.StreamChunk{Data: []byte{byte(i)}, Timestamp: time.Now()}
+		time.Sleep(time.Millisecond)
+	}
+	sending := time.Since(start)
+	for i := 0; i < 100; i++ {
+		c := <-output
+		if int(c.Data[0]) != i {
+			t.Fatalf("Expected datagram %d, got %d", i, c.Data[0])
+		}
+	}
+	AssertDeltaTime(t, "Delay line", time.Since(start), sending+100*time.Millisecond, 20*time.Millisecond)
+}
+
+func TestLatencyToxicKeepsOrderUnlessReordering(t *testing.T) {
+	for _, reorder := range []bool{false, true} {
+		latency := &toxics.LatencyToxic{Latency: 50, Jitter: 40, Reorder: reorder}
+		input, output, stub := StartLatencyStub(latency)
+		stub.Reseed(&toxics.ToxicWrapper{Toxic: latency, Name: "latency"}, 42, 1)
+
+		for i := 0; i < 50; i++ {
+			input <- &stream.StreamChunk{Data: []byte{byte(i)}, Timestamp: time.Now()}
+		}
+		reordered := false
+		for i := 0; i < 50; i++ {
+			c := <-output
+			if int(c.Data[0]) != i {
+				reordered = true
+			}
+		}
+		close(input)
+
+		if reordered != reorder {
+			t.Errorf("Expected reordered to be %v with reorder %v", reorder, reorder)
+		}
+	}
+}
+
+func TestLatencyToxicKeepsDatagramsInFlightOnInterrupt(t *testing.T) {
+	latency := &toxics.LatencyToxic{Latency: 100}
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = latency.NewState()
+	defer close(input)
+
+	done := make(chan bool)
+	go func() {
+		latency.Pipe(stub)
+		done <- true
+	}()
+
+	start := time.Now()
+	input <- &stream.StreamChunk{Data: []byte("hello"), Timestamp: start}
+
+	// Updating the toxic interrupts and restarts it
+	stub.Interrupt <- struct{}{}
+	<-done
+	go latency.Pipe(stub)
+
+	select {
+	case <-output:
+		AssertDeltaTime(t, "Delay after restart", time.Since(start), 100*time.Millisecond, 20*time.Millisecond)
+	case <-time.After(time.Second):
+		t.Fatal("Datagram in flight was lost on interrupt")
+	}
+}
+
+func TestLatencyToxicLimit(t *testing.T) {
+	input, output, _ := StartLatencyStub(&toxics.LatencyToxic{Latency: 50, Limit: 5})
+
+	for i := 0; i < 10; i++ {
+		input <- &stream.StreamChunk{Data: []byte{byte(i)}, Timestamp: time.Now()}
+	}
+	close(input)
+
+	// Closing the link flushes the datagrams in flight
+	received := 0
+	for range output {
+		received++
+	}
+	if received != 5 {
+		t.Fatalf("Expected 5 datagrams within the limit, got %d", received)
+	}
+}
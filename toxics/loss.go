@@ -0,0 +1,103 @@
+package toxics
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+const (
+	LossModelUniform        = "uniform"
+	LossModelGilbertElliott = "gilbert_elliott"
+	LossModelNth            = "nth"
+)
+
+// The LossToxic drops individual datagrams according to a loss model.
+//
+// The uniform model drops every datagram with the same probability. The
+// gilbert_elliott model switches between a good and a bad state to produce
+// bursts of loss. The nth model deterministically drops every Nth datagram.
+type LossToxic struct {
+	Seeded
+	// Loss model, one of uniform (default), gilbert_elliott or nth
+	Model string `json:"model"`
+	// Probability to drop a datagram in the uniform model
+	Probability float64 `json:"probability"`
+	// Probabilities to transition between the good and bad states
+	GoodToBad float64 `json:"good_to_bad"`
+	BadToGood float64 `json:"bad_to_good"`
+	// Probabilities to drop a datagram while in the good or bad state
+	GoodLoss float64 `json:"good_loss"`
+	BadLoss  float64 `json:"bad_loss"`
+	// Drop every Nth datagram, 0 disables dropping
+	Nth int64 `json:"nth"`
+}
+
+type LossToxicState struct {
+	// Whether the gilbert_elliott model is in the bad state
+	Bad bool
+	// Number of datagrams seen by the nth model
+	Count int64
+}
+
+func (t *LossToxic) NewState() interface{} {
+	return new(LossToxicState)
+}
+
+func (t *LossToxic) Validate() error {
+	switch t.Model {
+	case "", LossModelUniform, LossModelGilbertElliott, LossModelNth:
+		return nil
+	}
+	return fmt.Errorf("unknown loss model %q", t.Model)
+}
+
+// drop decides whether the next datagram is lost and advances the model state.
+func (t *LossToxic) drop(r *rand.Rand, state *LossToxicState) bool {
+	switch t.Model {
+	case "", LossModelUniform:
+		return r.Float64() < t.Probability
+	case LossModelGilbertElliott:
+		if state.Bad {
+			state.Bad = r.Float64() >= t.BadToGood
+		} else {
+			state.Bad = r.Float64() < t.GoodToBad
+		}
+		loss := t.GoodLoss
+		if state.Bad {
+			loss = t.BadLoss
+		}
+		return r.Float64() < loss
+	case LossModelNth:
+		if t.Nth <= 0 {
+			return false
+		}
+		state.Count++
+		return state.Count%t.Nth == 0
+	}
+	return false
+}
+
+func (t *LossToxic) Pipe(stub *ToxicStub) {
+	state := stub.State.(*LossToxicState)
+
+	for {
+		select {
+		case <-stub.Interrupt:
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				stub.Close()
+				return
+			}
+			if stub.Affected(c) && t.drop(stub.Rand, state) {
+				stub.Drop()
+				continue
+			}
+			stub.Output <- c
+		}
+	}
+}
+
+func init() {
+	Register("loss", new(LossToxic))
+}
@@ -0,0 +1,106 @@
+package toxics_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/badrootd/udpcrusher/stream"
+	"github.com/badrootd/udpcrusher/toxics"
+)
+
+// DoLossTest pushes count datagrams through the loss toxic and returns the
+// indexes of the datagrams that made it to the output.
+func DoLossTest(t *testing.T, loss *toxics.LossToxic, count int) []int {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, count)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = loss.NewState()
+
+	done := make(chan bool)
+	go func() {
+		loss.Pipe(stub)
+		done <- true
+	}()
+
+	for i := 0; i < count; i++ {
+		input <- &stream.StreamChunk{Data: []byte{byte(i >> 8), byte(i)}}
+	}
+	close(input)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Loss toxic did not finish in time")
+	}
+
+	received := make([]int, 0, count)
+	for c := range output {
+		received = append(received, int(c.Data[0])<<8|int(c.Data[1]))
+	}
+	return received
+}
+
+func TestLossToxicNth(t *testing.T) {
+	received := DoLossTest(t, &toxics.LossToxic{Model: "nth", Nth: 3}, 9)
+
+	expected := []int{0, 1, 3, 4, 6, 7}
+	if len(received) != len(expected) {
+		t.Fatalf("Expected %d datagrams, got %d: %v", len(expected), len(received), received)
+	}
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Fatalf("Expected datagrams %v, got %v", expected, received)
+		}
+	}
+}
+
+func TestLossToxicUniform(t *testing.T) {
+	received := DoLossTest(t, &toxics.LossToxic{Probability: 0}, 100)
+	if len(received) != 100 {
+		t.Errorf("Expected no loss, got %d of 100 datagrams", len(received))
+	}
+
+	received = DoLossTest(t, &toxics.LossToxic{Probability: 1}, 100)
+	if len(received) != 0 {
+		t.Errorf("Expected full loss, got %d of 100 datagrams", len(received))
+	}
+
+	received = DoLossTest(t, &toxics.LossToxic{Model: "uniform", Probability: 0.5}, 10000)
+	if len(received) < 4500 || len(received) > 5500 {
+		t.Errorf("Expected about 5000 of 10000 datagrams, got %d", len(received))
+	}
+}
+
+func TestLossToxicGilbertElliott(t *testing.T) {
+	// Never leave the good state, which is lossless
+	received := DoLossTest(t, &toxics.LossToxic{
+		Model:     "gilbert_elliott",
+		GoodToBad: 0,
+		BadLoss:   1,
+	}, 100)
+	if len(received) != 100 {
+		t.Errorf("Expected no loss, got %d of 100 datagrams", len(received))
+	}
+
+	// Move to the bad state immediately and never recover
+	received = DoLossTest(t, &toxics.LossToxic{
+		Model:     "gilbert_elliott",
+		GoodToBad: 1,
+		BadToGood: 0,
+		BadLoss:   1,
+	}, 100)
+	if len(received) != 0 {
+		t.Errorf("Expected full loss, got %d of 100 datagrams", len(received))
+	}
+}
+
+func TestLossToxicValidate(t *testing.T) {
+	for _, model := range []string{"", "uniform", "gilbert_elliott", "nth"} {
+		if err := (&toxics.LossToxic{Model: model}).Validate(); err != nil {
+			t.Errorf("Expected model %q to be valid, got: %v", model, err)
+		}
+	}
+	if err := (&toxics.LossToxic{Model: "uniformm"}).Validate(); err == nil {
+		t.Error("Expected an unknown model to be invalid")
+	}
+}
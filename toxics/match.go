@@ -0,0 +1,111 @@
+package toxics
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// A Match restricts a toxic to the datagrams whose payload matches, the others
+// pass through untouched. All set conditions must hold, e.g. QUIC short header
+// packets have the first bit cleared:
+//
+//	{"offset": 0, "mask": "80", "bytes": "00"}
+//
+// Regular expressions match the payload as UTF-8, so escapes like \x00 only
+// match bytes below 0x80. Combine them with a masked compare for other bytes.
+type Match struct {
+	// Hex bytes expected at Offset
+	Bytes string `json:"bytes,omitempty"`
+	// Hex mask the payload at Offset is ANDed with before comparing it with
+	// Bytes, as long as Bytes. Empty compares all bits
+	Mask string `json:"mask,omitempty"`
+	// Offset of Bytes into the payload, negative offsets count from the end
+	Offset int `json:"offset,omitempty"`
+	// Payload length range in bytes, a zero maximum is unbounded
+	MinLength int `json:"min_length,omitempty"`
+	MaxLength int `json:"max_length,omitempty"`
+	// Regular expression searched in the payload
+	Regex string `json:"regex,omitempty"`
+
+	bytes []byte
+	mask  []byte
+	regex *regexp.Regexp
+}
+
+// Load validates the match and prepares it for matching, it must be called
+// before Matches.
+func (m *Match) Load() error {
+	var err error
+	m.bytes, err = decodeHex(m.Bytes)
+	if err != nil {
+		return fmt.Errorf("bytes: %w", err)
+	}
+	m.mask, err = decodeHex(m.Mask)
+	if err != nil {
+		return fmt.Errorf("mask: %w", err)
+	}
+	if m.mask != nil && len(m.mask) != len(m.bytes) {
+		return errors.New("mask must be as long as bytes")
+	}
+
+	if m.MinLength < 0 || m.MaxLength < 0 || (m.MaxLength > 0 && m.MinLength > m.MaxLength) {
+		return fmt.Errorf("invalid length range %d-%d", m.MinLength, m.MaxLength)
+	}
+
+	m.regex = nil
+	if m.Regex != "" {
+		m.regex, err = regexp.Compile(m.Regex)
+		if err != nil {
+			return fmt.Errorf("regex: %w", err)
+		}
+	}
+	return nil
+}
+
+func decodeHex(s string) ([]byte, error) {
+	s = strings.TrimPrefix(strings.ReplaceAll(s, " ", ""), "0x")
+	if s == "" {
+		return nil, nil
+	}
+	return hex.DecodeString(s)
+}
+
+// Matches reports whether the toxic acts on a datagram with payload data. A nil
+// match matches every datagram.
+func (m *Match) Matches(data []byte) bool {
+	if m == nil {
+		return true
+	}
+
+	if len(data) < m.MinLength || (m.MaxLength > 0 && len(data) > m.MaxLength) {
+		return false
+	}
+
+	if len(m.bytes) > 0 {
+		offset := m.Offset
+		if offset < 0 {
+			offset += len(data)
+		}
+		if offset < 0 || offset+len(m.bytes) > len(data) {
+			return false
+		}
+		payload := data[offset : offset+len(m.bytes)]
+		if m.mask == nil {
+			if !bytes.Equal(payload, m.bytes) {
+				return false
+			}
+		} else {
+			for i, b := range payload {
+				if b&m.mask[i] != m.bytes[i] {
+					return false
+				}
+			}
+		}
+	}
+
+	return m.regex == nil || m.regex.Match(data)
+}
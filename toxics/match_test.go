@@ -0,0 +1,105 @@
+package toxics_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/badrootd/udpcrusher/stream"
+	"github.com/badrootd/udpcrusher/toxics"
+)
+
+func TestMatchMatches(t *testing.T) {
+	quicShort := []byte{0x41, 0x01, 0x02, 0x03}
+	quicLong := []byte{0xc3, 0x00, 0x00, 0x00, 0x01}
+	tests := []struct {
+		name    string
+		match   toxics.Match
+		data    []byte
+		matches bool
+	}{
+		{"empty", toxics.Match{}, quicShort, true},
+		{"bytes", toxics.Match{Bytes: "0102", Offset: 1}, quicShort, true},
+		{"bytes mismatch", toxics.Match{Bytes: "0103", Offset: 1}, quicShort, false},
+		{"bytes beyond payload", toxics.Match{Bytes: "0304", Offset: 3}, quicShort, false},
+		{"offset from end", toxics.Match{Bytes: "0001", Offset: -2}, quicLong, true},
+		{"masked short header", toxics.Match{Bytes: "40", Mask: "c0"}, quicShort, true},
+		{"masked long header", toxics.Match{Bytes: "40", Mask: "c0"}, quicLong, false},
+		{"hex prefix", toxics.Match{Bytes: "0x40", Mask: "0xc0"}, quicShort, true},
+		{"min length", toxics.Match{MinLength: 5}, quicShort, false},
+		{"max length", toxics.Match{MaxLength: 4}, quicShort, true},
+		{"length range", toxics.Match{MinLength: 5, MaxLength: 10}, quicLong, true},
+		{"regex", toxics.Match{Regex: `\x00\x00\x01$`}, quicLong, true},
+		{"regex mismatch", toxics.Match{Regex: `^\x00`}, quicLong, false},
+		{"all conditions", toxics.Match{Bytes: "c0", Mask: "c0", MinLength: 5, Regex: `\x01`}, quicLong, true},
+	}
+	for _, test := range tests {
+		match := test.match
+		if err := match.Load(); err != nil {
+			t.Fatalf("%s: failed to load match: %v", test.name, err)
+		}
+		if matches := match.Matches(test.data); matches != test.matches {
+			t.Errorf("%s: expected %v, got %v", test.name, test.matches, matches)
+		}
+	}
+}
+
+func TestMatchInvalid(t *testing.T) {
+	for _, match := range []toxics.Match{
+		{Bytes: "zz"},
+		{Bytes: "0"},
+		{Bytes: "40", Mask: "c0c0"},
+		{Mask: "c0"},
+		{MinLength: 10, MaxLength: 5},
+		{MinLength: -1},
+		{Regex: "("},
+	} {
+		if err := match.Load(); err == nil {
+			t.Errorf("Expected %+v to be invalid", match)
+		}
+	}
+}
+
+func TestMatchPassesOtherDatagrams(t *testing.T) {
+	// Drop only datagrams starting with 0xff
+	match := &toxics.Match{Bytes: "ff"}
+	if err := match.Load(); err != nil {
+		t.Fatal(err)
+	}
+	loss := &toxics.LossToxic{Probability: 1}
+	wrapper := &toxics.ToxicWrapper{
+		Toxic:    loss,
+		Name:     "loss",
+		Toxicity: 1,
+		Match:    match,
+	}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 10)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = loss.NewState()
+
+	done := make(chan bool)
+	go func() {
+		stub.Run(wrapper)
+		done <- true
+	}()
+
+	for i := 0; i < 10; i++ {
+		input <- &stream.StreamChunk{Data: []byte{byte(250 + i%2*5), byte(i)}}
+	}
+	close(input)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Toxic did not finish in time")
+	}
+	if len(output) != 5 {
+		t.Fatalf("Expected the 5 datagrams not matching to pass, got %d", len(output))
+	}
+	for c := range output {
+		if c.Data[0] == 0xff {
+			t.Fatalf("Expected matching datagram %d to be dropped", c.Data[1])
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package toxics
+
+import "fmt"
+
+const (
+	MtuActionDrop     = "drop"
+	MtuActionTruncate = "truncate"
+)
+
+// The MtuToxic enforces a maximum datagram size to simulate a path MTU black
+// hole. Unlike the SlicerToxic, an oversized datagram is never split, since UDP
+// receivers don't reassemble; it is either dropped or truncated.
+type MtuToxic struct {
+	// Largest datagram in bytes that passes unchanged, 0 disables the limit
+	Mtu int `json:"mtu"`
+	// What happens to oversized datagrams, drop (default) or truncate
+	Action string `json:"action"`
+	// Number of oversized datagrams seen, like ICMP "fragmentation needed"
+	// messages a router would have sent back
+	Exceeded Counter `json:"exceeded"`
+}
+
+func (t *MtuToxic) Validate() error {
+	switch t.Action {
+	case "", MtuActionDrop, MtuActionTruncate:
+		return nil
+	}
+	return fmt.Errorf("unknown mtu action %q", t.Action)
+}
+
+func (t *MtuToxic) Carry(previous Toxic) {
+	t.Exceeded.carry(&previous.(*MtuToxic).Exceeded)
+}
+
+func (t *MtuToxic) Pipe(stub *ToxicStub) {
+	for {
+		select {
+		case <-stub.Interrupt:
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				stub.Close()
+				return
+			}
+			if t.Mtu > 0 && len(c.Data) > t.Mtu && stub.Affected(c) {
+				t.Exceeded.Inc()
+				if t.Action != MtuActionTruncate {
+					stub.Drop()
+					continue
+				}
+				c.Data = c.Data[:t.Mtu]
+			}
+			stub.Output <- c
+		}
+	}
+}
+
+func init() {
+	Register("mtu", new(MtuToxic))
+}
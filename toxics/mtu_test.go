@@ -0,0 +1,73 @@
+package toxics_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/badrootd/udpcrusher/stream"
+	"github.com/badrootd/udpcrusher/toxics"
+)
+
+func DoMtuTest(t *testing.T, mtu *toxics.MtuToxic, payloads ...string) []string {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, len(payloads))
+	stub := toxics.NewToxicStub(input, output)
+
+	done := make(chan bool)
+	go func() {
+		mtu.Pipe(stub)
+		done <- true
+	}()
+
+	for _, p := range payloads {
+		input <- &stream.StreamChunk{Data: []byte(p)}
+	}
+	close(input)
+	<-done
+
+	var received []string
+	for c := range output {
+		received = append(received, string(c.Data))
+	}
+	return received
+}
+
+func TestMtuToxicDrop(t *testing.T) {
+	mtu := &toxics.MtuToxic{Mtu: 5}
+	received := DoMtuTest(t, mtu, "hi", "hello world", "hello")
+
+	if len(received) != 2 || received[0] != "hi" || received[1] != "hello" {
+		t.Fatalf("Expected oversized datagram to be dropped, got %q", received)
+	}
+	if mtu.Exceeded.Load() != 1 {
+		t.Fatalf("Expected 1 exceeded datagram, got %d", mtu.Exceeded.Load())
+	}
+}
+
+func TestMtuToxicTruncate(t *testing.T) {
+	mtu := &toxics.MtuToxic{Mtu: 5, Action: "truncate"}
+	received := DoMtuTest(t, mtu, "hi", "hello world")
+
+	if len(received) != 2 || received[0] != "hi" || received[1] != "hello" {
+		t.Fatalf("Expected oversized datagram to be truncated, got %q", received)
+	}
+
+	data, err := json.Marshal(mtu)
+	if err != nil {
+		t.Fatal("Unable to marshal toxic:", err)
+	}
+	expected := []byte(`{"mtu":5,"action":"truncate","exceeded":1}`)
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("Expected %s, got %s", expected, data)
+	}
+}
+
+func TestMtuToxicValidate(t *testing.T) {
+	if err := (&toxics.MtuToxic{Action: "truncate"}).Validate(); err != nil {
+		t.Fatal("Expected the truncate action to be valid, got:", err)
+	}
+	if err := (&toxics.MtuToxic{Action: "trunc"}).Validate(); err == nil {
+		t.Fatal("Expected an unknown action to be invalid")
+	}
+}
@@ -0,0 +1,255 @@
+package toxics
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TraceDir restricts the trace files of profiles to a directory, trace paths are
+// relative to it then. Empty allows any path on the server.
+var TraceDir string
+
+const (
+	// Attributes change linearly from one point to the next.
+	ProfileRamp = "ramp"
+	// Attributes keep the values of a point until the next one.
+	ProfileStep = "step"
+)
+
+// A Profile changes the attributes of a toxic over time, e.g. a latency ramp
+// from 0 to 500ms over 60s or the replay of a captured LTE trace.
+type Profile struct {
+	// How values between points are computed, ramp or step
+	Mode string `json:"mode"`
+	// Points sorted by time
+	Points []ProfilePoint `json:"points,omitempty"`
+	// Path of a CSV trace file to load the points from instead. The header
+	// names the columns, the first one is the time in milliseconds, the others
+	// are attributes. Columns that are no attributes of the toxic are ignored,
+	// so one trace can drive latency, loss and bandwidth toxics together.
+	Trace string `json:"trace,omitempty"`
+	// Milliseconds between attribute updates, defaults to 100
+	Interval int64 `json:"interval,omitempty"`
+	// Start over after the last point instead of keeping its values
+	Loop bool `json:"loop,omitempty"`
+}
+
+// A ProfilePoint sets attributes at a time since the toxic was added.
+type ProfilePoint struct {
+	// Milliseconds since the profile started
+	Time       int64              `json:"time"`
+	Attributes map[string]float64 `json:"attributes"`
+}
+
+// Load reads the trace file, if any, and validates the profile against the
+// attributes of the toxic.
+func (p *Profile) Load(toxic Toxic) error {
+	if p.Mode == "" {
+		p.Mode = ProfileRamp
+		if p.Trace != "" {
+			p.Mode = ProfileStep
+		}
+	}
+	if p.Mode != ProfileRamp && p.Mode != ProfileStep {
+		return fmt.Errorf("mode %q can be either ramp or step", p.Mode)
+	}
+	if p.Interval < 0 {
+		return errors.New("interval must not be negative")
+	}
+
+	strict := true
+	if p.Trace != "" {
+		path, err := tracePath(p.Trace)
+		if err != nil {
+			return err
+		}
+		points, err := readTrace(path)
+		if err != nil {
+			return err
+		}
+		p.Points = points
+		strict = false
+	}
+	if len(p.Points) == 0 {
+		return errors.New("no points")
+	}
+
+	known := attributeFields(toxic)
+	matched := false
+	for i, point := range p.Points {
+		if i > 0 && point.Time < p.Points[i-1].Time {
+			return fmt.Errorf("point at %dms is not sorted by time", point.Time)
+		}
+		for name := range point.Attributes {
+			if _, ok := known[name]; ok {
+				matched = true
+			} else if strict {
+				return fmt.Errorf("unknown attribute %q", name)
+			}
+		}
+	}
+	if !matched {
+		return errors.New("no attribute of the toxic is changed")
+	}
+	return nil
+}
+
+// Tick is the time between attribute updates.
+func (p *Profile) Tick() time.Duration {
+	if p.Interval > 0 {
+		return time.Duration(p.Interval) * time.Millisecond
+	}
+	return 100 * time.Millisecond
+}
+
+// At returns the attributes at the elapsed time since the profile started and
+// whether the profile reached its final values.
+func (p *Profile) At(elapsed time.Duration) (map[string]float64, bool) {
+	now := elapsed.Milliseconds()
+	last := p.Points[len(p.Points)-1]
+	if p.Loop && last.Time > 0 {
+		now %= last.Time
+	} else if now >= last.Time {
+		return last.Attributes, true
+	}
+
+	i := 0
+	for i+1 < len(p.Points) && p.Points[i+1].Time <= now {
+		i++
+	}
+	from := p.Points[i]
+	if p.Mode == ProfileStep || now < from.Time || i+1 == len(p.Points) {
+		return from.Attributes, false
+	}
+
+	to := p.Points[i+1]
+	progress := float64(now-from.Time) / float64(to.Time-from.Time)
+	attrs := make(map[string]float64, len(from.Attributes))
+	for name, value := range from.Attributes {
+		if target, ok := to.Attributes[name]; ok {
+			value += (target - value) * progress
+		}
+		attrs[name] = value
+	}
+	return attrs, false
+}
+
+// SetAttributes sets the numeric attributes of a toxic by their JSON names,
+// rounding values of integer attributes. Unknown names are ignored.
+func SetAttributes(toxic Toxic, attrs map[string]float64) {
+	known := attributeFields(toxic)
+	for name, value := range attrs {
+		field, ok := known[name]
+		if !ok {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			field.SetInt(int64(math.Round(value)))
+		case reflect.Float32, reflect.Float64:
+			field.SetFloat(value)
+		}
+	}
+}
+
+// attributeFields maps the JSON names of the numeric attributes of a toxic to
+// their settable values, including those of embedded structs like Seeded.
+func attributeFields(toxic Toxic) map[string]reflect.Value {
+	fields := make(map[string]reflect.Value)
+	value := reflect.ValueOf(toxic)
+	if value.Kind() == reflect.Ptr {
+		collectFields(value.Elem(), fields)
+	}
+	return fields
+}
+
+func collectFields(value reflect.Value, fields map[string]reflect.Value) {
+	if value.Kind() != reflect.Struct {
+		return
+	}
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.Anonymous {
+			collectFields(value.Field(i), fields)
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		switch value.Field(i).Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Float32, reflect.Float64:
+			fields[name] = value.Field(i)
+		}
+	}
+}
+
+// tracePath resolves the path of a trace file against TraceDir.
+func tracePath(trace string) (string, error) {
+	if TraceDir == "" {
+		return trace, nil
+	}
+	if !filepath.IsLocal(trace) {
+		return "", fmt.Errorf("trace %s is outside of the trace directory", trace)
+	}
+	return filepath.Join(TraceDir, trace), nil
+}
+
+// readTrace reads the points of a CSV trace file. Errors only name the position
+// of an invalid field, not its value, since they are returned to API callers
+// and the file may be any file on the server.
+func readTrace(path string) ([]ProfilePoint, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("trace %s: %w", path, err)
+	}
+	if len(header) < 2 {
+		return nil, fmt.Errorf("trace %s: expected time and attribute columns", path)
+	}
+
+	var points []ProfilePoint
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return points, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("trace %s: %w", path, err)
+		}
+
+		point := ProfilePoint{Attributes: make(map[string]float64, len(header)-1)}
+		for i, field := range record {
+			value, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				line, _ := reader.FieldPos(i)
+				return nil, fmt.Errorf("trace %s: line %d, field %d is not a number", path, line, i+1)
+			}
+			if i == 0 {
+				point.Time = int64(value)
+			} else {
+				point.Attributes[header[i]] = value
+			}
+		}
+		points = append(points, point)
+	}
+}
@@ -0,0 +1,162 @@
+package toxics_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/badrootd/udpcrusher/toxics"
+)
+
+func TestProfileRamp(t *testing.T) {
+	profile := &toxics.Profile{
+		Points: []toxics.ProfilePoint{
+			{Time: 0, Attributes: map[string]float64{"latency": 0}},
+			{Time: 1000, Attributes: map[string]float64{"latency": 500}},
+		},
+	}
+	if err := profile.Load(new(toxics.LatencyToxic)); err != nil {
+		t.Fatal("Failed to load profile:", err)
+	}
+	if profile.Mode != toxics.ProfileRamp {
+		t.Fatal("Expected points to ramp by default, got", profile.Mode)
+	}
+
+	for _, tc := range []struct {
+		elapsed time.Duration
+		latency float64
+		done    bool
+	}{
+		{0, 0, false},
+		{250 * time.Millisecond, 125, false},
+		{500 * time.Millisecond, 250, false},
+		{time.Second, 500, true},
+		{time.Minute, 500, true},
+	} {
+		attrs, done := profile.At(tc.elapsed)
+		if attrs["latency"] != tc.latency || done != tc.done {
+			t.Errorf("At %s: expected latency %v (done %v), got %v (done %v)",
+				tc.elapsed, tc.latency, tc.done, attrs["latency"], done)
+		}
+	}
+}
+
+func TestProfileStepLoop(t *testing.T) {
+	profile := &toxics.Profile{
+		Mode: toxics.ProfileStep,
+		Loop: true,
+		Points: []toxics.ProfilePoint{
+			{Time: 0, Attributes: map[string]float64{"rate": 100}},
+			{Time: 100, Attributes: map[string]float64{"rate": 10}},
+			{Time: 200, Attributes: map[string]float64{"rate": 100}},
+		},
+	}
+	if err := profile.Load(new(toxics.BandwidthToxic)); err != nil {
+		t.Fatal("Failed to load profile:", err)
+	}
+
+	for _, tc := range []struct {
+		elapsed time.Duration
+		rate    float64
+	}{
+		{50 * time.Millisecond, 100},
+		{150 * time.Millisecond, 10},
+		{250 * time.Millisecond, 100},
+		{350 * time.Millisecond, 10},
+	} {
+		attrs, done := profile.At(tc.elapsed)
+		if attrs["rate"] != tc.rate || done {
+			t.Errorf("At %s: expected rate %v, got %v (done %v)", tc.elapsed, tc.rate, attrs["rate"], done)
+		}
+	}
+}
+
+func TestProfileTrace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lte.csv")
+	trace := "# captured LTE trace\ntime,latency,probability,rate\n0,40,0.01,5000\n500,120,0.05,800\n"
+	if err := os.WriteFile(path, []byte(trace), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Columns of other toxics are ignored
+	profile := &toxics.Profile{Trace: path}
+	latency := new(toxics.LatencyToxic)
+	if err := profile.Load(latency); err != nil {
+		t.Fatal("Failed to load trace:", err)
+	}
+	if profile.Mode != toxics.ProfileStep || len(profile.Points) != 2 {
+		t.Fatalf("Expected 2 step points, got %s %v", profile.Mode, profile.Points)
+	}
+
+	attrs, _ := profile.At(600 * time.Millisecond)
+	toxics.SetAttributes(latency, attrs)
+	if latency.Latency != 120 {
+		t.Fatal("Expected latency 120, got", latency.Latency)
+	}
+}
+
+func TestProfileInvalid(t *testing.T) {
+	for name, profile := range map[string]*toxics.Profile{
+		"no points": {},
+		"bad mode": {Mode: "sine", Points: []toxics.ProfilePoint{
+			{Attributes: map[string]float64{"latency": 1}},
+		}},
+		"unknown attribute": {Points: []toxics.ProfilePoint{
+			{Attributes: map[string]float64{"walrus": 1}},
+		}},
+		"unsorted": {Points: []toxics.ProfilePoint{
+			{Time: 100, Attributes: map[string]float64{"latency": 1}},
+			{Time: 0, Attributes: map[string]float64{"latency": 2}},
+		}},
+		"missing trace": {Trace: filepath.Join(t.TempDir(), "missing.csv")},
+	} {
+		if err := profile.Load(new(toxics.LatencyToxic)); err == nil {
+			t.Errorf("Expected %s profile to be invalid", name)
+		}
+	}
+}
+
+func TestProfileTraceErrorHidesValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "creds.csv")
+	if err := os.WriteFile(path, []byte("user,password\nalice,secret\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := (&toxics.Profile{Trace: path}).Load(new(toxics.LatencyToxic))
+	if err == nil {
+		t.Fatal("Expected the trace to be invalid")
+	}
+	if strings.Contains(err.Error(), "alice") || !strings.Contains(err.Error(), "line 2, field 1") {
+		t.Fatal("Expected the error to name the position of the field only, got:", err)
+	}
+}
+
+func TestProfileTraceDir(t *testing.T) {
+	dir := t.TempDir()
+	trace := "time,latency\n0,40\n"
+	if err := os.WriteFile(filepath.Join(dir, "lte.csv"), []byte(trace), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	toxics.TraceDir = dir
+	defer func() { toxics.TraceDir = "" }()
+
+	if err := (&toxics.Profile{Trace: "lte.csv"}).Load(new(toxics.LatencyToxic)); err != nil {
+		t.Fatal("Failed to load trace from the trace directory:", err)
+	}
+	for _, trace := range []string{"../lte.csv", filepath.Join(dir, "lte.csv")} {
+		if err := (&toxics.Profile{Trace: trace}).Load(new(toxics.LatencyToxic)); err == nil {
+			t.Errorf("Expected trace %s outside of the trace directory to be rejected", trace)
+		}
+	}
+}
+
+func TestSetAttributesRoundsIntegers(t *testing.T) {
+	loss := &toxics.LossToxic{}
+	toxics.SetAttributes(loss, map[string]float64{"probability": 0.25, "nth": 2.6, "seed": 7})
+
+	if loss.Probability != 0.25 || loss.Nth != 3 || loss.Seed != 7 {
+		t.Fatalf("Attributes were not set: %+v", loss)
+	}
+}
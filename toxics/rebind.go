@@ -0,0 +1,85 @@
+package toxics
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// The RebindToxic simulates a NAT rebinding: the client session of the link is
+// moved to a new source port towards the upstream, periodically or whenever the
+// trigger attribute changes. Add it to one stream only, every link of the
+// stream rebinds its session.
+type RebindToxic struct {
+	// Milliseconds between rebinds, 0 only rebinds when triggered
+	Interval int64 `json:"interval"`
+	// Changing the trigger, e.g. incrementing it, rebinds every session once
+	Trigger int64 `json:"trigger"`
+	// Number of successful rebinds
+	Rebinds Counter `json:"rebinds"`
+}
+
+type RebindToxicState struct {
+	trigger int64
+	next    time.Time
+}
+
+func (t *RebindToxic) NewState() interface{} {
+	return &RebindToxicState{trigger: t.Trigger}
+}
+
+func (t *RebindToxic) Carry(previous Toxic) {
+	t.Rebinds.carry(&previous.(*RebindToxic).Rebinds)
+}
+
+func (t *RebindToxic) rebind(stub *ToxicStub) {
+	if stub.Rebinder == nil {
+		return
+	}
+	err := stub.Rebinder.Rebind()
+	if err != nil {
+		log.Warn().Err(err).Str("toxic_type", "rebind").Msg("Unable to rebind session")
+		return
+	}
+	t.Rebinds.Inc()
+}
+
+// timer fires at the next scheduled rebind, or never without an interval.
+func (t *RebindToxic) timer(state *RebindToxicState) <-chan time.Time {
+	if t.Interval <= 0 {
+		return nil
+	}
+	if state.next.IsZero() {
+		state.next = time.Now().Add(time.Duration(t.Interval) * time.Millisecond)
+	}
+	return time.After(time.Until(state.next))
+}
+
+func (t *RebindToxic) Pipe(stub *ToxicStub) {
+	state := stub.State.(*RebindToxicState)
+
+	if state.trigger != t.Trigger {
+		state.trigger = t.Trigger
+		t.rebind(stub)
+	}
+
+	for {
+		select {
+		case <-stub.Interrupt:
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				stub.Close()
+				return
+			}
+			stub.Output <- c
+		case <-t.timer(state):
+			t.rebind(stub)
+			state.next = time.Time{}
+		}
+	}
+}
+
+func init() {
+	Register("rebind", new(RebindToxic))
+}
@@ -0,0 +1,80 @@
+package toxics_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/badrootd/udpcrusher/stream"
+	"github.com/badrootd/udpcrusher/toxics"
+)
+
+type countingRebinder struct {
+	count atomic.Int64
+}
+
+func (r *countingRebinder) Rebind() error {
+	r.count.Add(1)
+	return nil
+}
+
+func TestRebindToxicInterval(t *testing.T) {
+	rebind := &toxics.RebindToxic{Interval: 20}
+	rebinder := new(countingRebinder)
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = rebind.NewState()
+	stub.Rebinder = rebinder
+
+	done := make(chan bool)
+	go func() {
+		rebind.Pipe(stub)
+		done <- true
+	}()
+
+	// Datagrams pass through unchanged while rebinding
+	for i := 0; i < 5; i++ {
+		input <- &stream.StreamChunk{Data: []byte("hello")}
+		if c := <-output; string(c.Data) != "hello" {
+			t.Fatalf("Expected hello, got %q", c.Data)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	close(input)
+	<-done
+
+	if rebinder.count.Load() < 2 || rebind.Rebinds.Load() != uint64(rebinder.count.Load()) {
+		t.Fatalf("Expected several counted rebinds, got %d (counted %d)",
+			rebinder.count.Load(), rebind.Rebinds.Load())
+	}
+}
+
+func TestRebindToxicTrigger(t *testing.T) {
+	rebind := &toxics.RebindToxic{Trigger: 1}
+	rebinder := new(countingRebinder)
+
+	stub := toxics.NewToxicStub(make(chan *stream.StreamChunk), make(chan *stream.StreamChunk))
+	stub.State = rebind.NewState()
+	stub.Rebinder = rebinder
+
+	run := func() {
+		go rebind.Pipe(stub)
+		stub.Interrupt <- struct{}{}
+	}
+
+	// The trigger the toxic was created with doesn't rebind
+	run()
+	if rebinder.count.Load() != 0 {
+		t.Fatalf("Expected no rebind, got %d", rebinder.count.Load())
+	}
+
+	// Every change rebinds once, no matter how often the toxic restarts
+	rebind.Trigger = 2
+	run()
+	run()
+	if rebinder.count.Load() != 1 {
+		t.Fatalf("Expected 1 rebind, got %d", rebinder.count.Load())
+	}
+}
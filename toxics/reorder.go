@@ -0,0 +1,131 @@
+package toxics
+
+import (
+	"time"
+
+	"github.com/badrootd/udpcrusher/stream"
+)
+
+// The ReorderToxic holds back a fraction of datagrams and releases them after a
+// number of subsequent datagrams have passed, or once they have been held for
+// too long, similar to the reorder option of netem.
+type ReorderToxic struct {
+	// Probability to hold back a datagram
+	Probability float64 `json:"probability"`
+	// Number of subsequent datagrams after which a held datagram is released,
+	// at least 1
+	Gap int `json:"gap"`
+	// Maximum time in milliseconds a datagram is held, 0 holds it until enough
+	// datagrams have passed
+	MaxHold int64 `json:"max_hold"`
+	Seeded
+}
+
+type ReorderToxicState struct {
+	held []*heldChunk
+}
+
+type heldChunk struct {
+	chunk     *stream.StreamChunk
+	remaining int
+	since     time.Time
+	deadline  time.Time
+}
+
+func (t *ReorderToxic) GetBufferSize() int {
+	return 1024
+}
+
+func (t *ReorderToxic) NewState() interface{} {
+	return new(ReorderToxicState)
+}
+
+// hold decides whether the datagram is held back, and counts it as a subsequent
+// datagram for everything that is already held.
+func (t *ReorderToxic) hold(stub *ToxicStub, state *ReorderToxicState, c *stream.StreamChunk) bool {
+	for _, h := range state.held {
+		h.remaining--
+	}
+
+	if !stub.Affected(c) || stub.Rand.Float64() >= t.Probability {
+		return false
+	}
+
+	gap := t.Gap
+	if gap < 1 {
+		gap = 1
+	}
+	h := &heldChunk{chunk: c, remaining: gap, since: time.Now()}
+	if t.MaxHold > 0 {
+		h.deadline = time.Now().Add(time.Duration(t.MaxHold) * time.Millisecond)
+	}
+	state.held = append(state.held, h)
+	return true
+}
+
+// release sends every held datagram that has waited long enough, in the order
+// they were held.
+func (t *ReorderToxic) release(stub *ToxicStub, state *ReorderToxicState) {
+	now := time.Now()
+	held := state.held[:0]
+	for _, h := range state.held {
+		if h.remaining <= 0 || (!h.deadline.IsZero() && !now.Before(h.deadline)) {
+			stub.Delay(now.Sub(h.since))
+			stub.Output <- h.chunk
+		} else {
+			held = append(held, h)
+		}
+	}
+	state.held = held
+}
+
+// timer fires when the next held datagram reaches its deadline.
+func (t *ReorderToxic) timer(state *ReorderToxicState) <-chan time.Time {
+	var next time.Time
+	for _, h := range state.held {
+		if !h.deadline.IsZero() && (next.IsZero() || h.deadline.Before(next)) {
+			next = h.deadline
+		}
+	}
+	if next.IsZero() {
+		return nil
+	}
+	return time.After(time.Until(next))
+}
+
+func (t *ReorderToxic) Pipe(stub *ToxicStub) {
+	state := stub.State.(*ReorderToxicState)
+
+	for {
+		select {
+		case <-stub.Interrupt:
+			// Held datagrams stay in the state until the toxic runs again
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				t.Cleanup(stub)
+				stub.Close()
+				return
+			}
+			if !t.hold(stub, state, c) {
+				stub.Output <- c
+			}
+		case <-t.timer(state):
+		}
+
+		t.release(stub, state)
+	}
+}
+
+// Cleanup releases all held datagrams, so removing the toxic doesn't drop them.
+func (t *ReorderToxic) Cleanup(stub *ToxicStub) {
+	state := stub.State.(*ReorderToxicState)
+	for _, h := range state.held {
+		stub.Output <- h.chunk
+	}
+	state.held = nil
+}
+
+func init() {
+	Register("reorder", new(ReorderToxic))
+}
@@ -0,0 +1,103 @@
+package toxics_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/badrootd/udpcrusher/stream"
+	"github.com/badrootd/udpcrusher/toxics"
+)
+
+func DoReorderTest(t *testing.T, reorder *toxics.ReorderToxic, count int) []int {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, count)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = reorder.NewState()
+	stub.Reseed(&toxics.ToxicWrapper{Toxic: reorder, Name: "reorder"}, 0, 1)
+
+	done := make(chan bool)
+	go func() {
+		reorder.Pipe(stub)
+		done <- true
+	}()
+
+	for i := 0; i < count; i++ {
+		input <- &stream.StreamChunk{Data: []byte{byte(i)}}
+	}
+	close(input)
+	<-done
+
+	received := make([]int, 0, count)
+	for c := range output {
+		received = append(received, int(c.Data[0]))
+	}
+	return received
+}
+
+func TestReorderToxicIsDeterministic(t *testing.T) {
+	reorder := &toxics.ReorderToxic{
+		Probability: 0.3,
+		Gap:         3,
+		Seeded:      toxics.Seeded{Seed: 42},
+	}
+
+	first := DoReorderTest(t, reorder, 100)
+	second := DoReorderTest(t, reorder, 100)
+
+	if len(first) != 100 || len(second) != 100 {
+		t.Fatalf("Expected all 100 datagrams, got %d and %d", len(first), len(second))
+	}
+
+	seen := make(map[int]bool)
+	reordered := false
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("Same seed produced different orders: %v and %v", first, second)
+		}
+		if first[i] != i {
+			reordered = true
+		}
+		seen[first[i]] = true
+	}
+	if !reordered {
+		t.Fatal("Expected some datagrams to be reordered")
+	}
+	if len(seen) != 100 {
+		t.Fatalf("Expected 100 distinct datagrams, got %d", len(seen))
+	}
+}
+
+func TestReorderToxicGap(t *testing.T) {
+	// Every datagram is held for exactly 2 subsequent ones, the tail is
+	// flushed when the input closes
+	received := DoReorderTest(t, &toxics.ReorderToxic{Probability: 1, Gap: 2}, 5)
+
+	expected := []int{0, 1, 2, 3, 4}
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, received)
+		}
+	}
+}
+
+func TestReorderToxicMaxHold(t *testing.T) {
+	reorder := &toxics.ReorderToxic{Probability: 1, Gap: 100, MaxHold: 100}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = reorder.NewState()
+
+	go reorder.Pipe(stub)
+	defer close(input)
+
+	start := time.Now()
+	input <- &stream.StreamChunk{Data: []byte("hello")}
+
+	select {
+	case <-output:
+		AssertDeltaTime(t, "Max hold", time.Since(start), 100*time.Millisecond, 20*time.Millisecond)
+	case <-time.After(time.Second):
+		t.Fatal("Held datagram was not released after max hold time")
+	}
+}
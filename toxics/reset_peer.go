@@ -25,7 +25,11 @@ func (t *ResetToxic) Pipe(stub *ToxicStub) {
 		select {
 		case <-stub.Interrupt:
 			return
-		case <-stub.Input:
+		case c := <-stub.Input:
+			if c != nil && !stub.Affected(c) {
+				stub.Output <- c
+				continue
+			}
 			<-time.After(timeout)
 			stub.Close()
 			return
@@ -0,0 +1,70 @@
+package toxics
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
+)
+
+// Seeded is embedded by toxics that make random decisions, so that their seed
+// can be set with the "seed" attribute.
+type Seeded struct {
+	// Seed for the random decisions, 0 falls back to the seed of the proxy
+	Seed int64 `json:"seed"`
+}
+
+func (s *Seeded) GetSeed() int64 {
+	return s.Seed
+}
+
+// SeededToxic is implemented by toxics that accept a seed attribute.
+type SeededToxic interface {
+	GetSeed() int64
+}
+
+// Reseed replaces the random source of the stub for the given toxic. The seed
+// attribute of the toxic takes precedence over the proxy seed, and the actual
+// seed is derived from it together with the ordinal of the link's session, the
+// direction and the toxic name, so every toxic on every link gets its own
+// sequence. It doesn't depend on the client's address, a run replays as long
+// as its clients arrive in the same order. Without any seed a random one is
+// picked.
+func (s *ToxicStub) Reseed(toxic *ToxicWrapper, proxySeed int64, session uint64) {
+	s.Rand = newRand(deriveSeed(toxic, proxySeed, session))
+}
+
+// SharedRand returns the random source for the decisions a toxic shares between
+// all its links, e.g. a schedule. It's derived like the sources of the links,
+// with the session ordinal 0 that no link has.
+func SharedRand(toxic *ToxicWrapper, proxySeed int64) *rand.Rand {
+	return newRand(deriveSeed(toxic, proxySeed, 0))
+}
+
+// deriveSeed returns the seed of a toxic for a session, 0 if neither the toxic
+// nor the proxy has a seed.
+func deriveSeed(toxic *ToxicWrapper, proxySeed int64, session uint64) int64 {
+	seed := proxySeed
+	if seeded, ok := toxic.Toxic.(SeededToxic); ok && seeded.GetSeed() != 0 {
+		seed = seeded.GetSeed()
+	}
+	if seed == 0 {
+		return 0
+	}
+
+	hash := fnv.New64a()
+	_ = binary.Write(hash, binary.LittleEndian, seed)
+	_ = binary.Write(hash, binary.LittleEndian, session)
+	hash.Write([]byte{byte(toxic.Direction)})
+	hash.Write([]byte(toxic.Name))
+	return int64(hash.Sum64())
+}
+
+// newRand returns a random source for the seed, 0 picks a random seed.
+func newRand(seed int64) *rand.Rand {
+	if seed == 0 {
+		//#nosec
+		seed = rand.Int63()
+	}
+	//#nosec
+	return rand.New(rand.NewSource(seed))
+}
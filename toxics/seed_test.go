@@ -0,0 +1,61 @@
+package toxics_test
+
+import (
+	"testing"
+
+	"github.com/badrootd/udpcrusher/stream"
+	"github.com/badrootd/udpcrusher/toxics"
+)
+
+func reseededValue(toxic *toxics.ToxicWrapper, proxySeed int64, session uint64) int64 {
+	stub := toxics.NewToxicStub(nil, nil)
+	stub.Reseed(toxic, proxySeed, session)
+	return stub.Rand.Int63()
+}
+
+func TestReseedIsReplayable(t *testing.T) {
+	toxic := &toxics.ToxicWrapper{Toxic: new(toxics.LossToxic), Name: "loss"}
+
+	if reseededValue(toxic, 42, 1) != reseededValue(toxic, 42, 1) {
+		t.Fatal("Expected the same proxy seed and session to replay the same sequence")
+	}
+	if reseededValue(toxic, 42, 1) == reseededValue(toxic, 42, 2) {
+		t.Fatal("Expected different sessions to get different sequences")
+	}
+
+	downstream := &toxics.ToxicWrapper{
+		Toxic:     new(toxics.LossToxic),
+		Name:      "loss",
+		Direction: stream.Downstream,
+	}
+	if reseededValue(toxic, 42, 1) == reseededValue(downstream, 42, 1) {
+		t.Fatal("Expected different directions to get different sequences")
+	}
+
+	other := &toxics.ToxicWrapper{Toxic: new(toxics.LossToxic), Name: "other"}
+	if reseededValue(toxic, 42, 1) == reseededValue(other, 42, 1) {
+		t.Fatal("Expected different toxics to get different sequences")
+	}
+}
+
+func TestReseedPrefersToxicSeed(t *testing.T) {
+	toxic := &toxics.ToxicWrapper{
+		Toxic: &toxics.LossToxic{Seeded: toxics.Seeded{Seed: 7}},
+		Name:  "loss",
+	}
+
+	if reseededValue(toxic, 1, 1) != reseededValue(toxic, 2, 1) {
+		t.Fatal("Expected the toxic seed to take precedence over the proxy seed")
+	}
+}
+
+func TestSharedRandIsReplayable(t *testing.T) {
+	toxic := &toxics.ToxicWrapper{Toxic: new(toxics.BlackholeToxic), Name: "blackhole"}
+
+	if toxics.SharedRand(toxic, 42).Int63() != toxics.SharedRand(toxic, 42).Int63() {
+		t.Fatal("Expected the same proxy seed to replay the same shared sequence")
+	}
+	if toxics.SharedRand(toxic, 42).Int63() == reseededValue(toxic, 42, 1) {
+		t.Fatal("Expected the shared sequence to differ from the one of the first session")
+	}
+}
@@ -0,0 +1,89 @@
+package toxics
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// A Selector scopes a toxic to the links of some clients of a proxy, so one
+// client can be impaired while the others behind the same proxy stay healthy.
+// All set fields must match, links of other clients run a noop toxic instead.
+type Selector struct {
+	// Client IP or CIDR, e.g. 10.0.0.7 or 10.0.0.0/24
+	Source string `json:"source,omitempty"`
+	// Client port or port range, e.g. 40000 or 40000-40100
+	Ports string `json:"ports,omitempty"`
+	// Name of the client session, the client address as ip:port
+	Session string `json:"session,omitempty"`
+
+	prefix netip.Prefix
+	low    int
+	high   int
+}
+
+// Load validates the selector and prepares it for matching, it must be called
+// before Matches.
+func (s *Selector) Load() error {
+	s.prefix = netip.Prefix{}
+	if s.Source != "" {
+		if strings.Contains(s.Source, "/") {
+			prefix, err := netip.ParsePrefix(s.Source)
+			if err != nil {
+				return err
+			}
+			s.prefix = prefix.Masked()
+		} else {
+			addr, err := netip.ParseAddr(s.Source)
+			if err != nil {
+				return err
+			}
+			s.prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+	}
+
+	s.low, s.high = 0, 65535
+	if s.Ports != "" {
+		low, high, found := strings.Cut(s.Ports, "-")
+		var err error
+		s.low, err = strconv.Atoi(strings.TrimSpace(low))
+		if err != nil {
+			return fmt.Errorf("ports: %w", err)
+		}
+		s.high = s.low
+		if found {
+			s.high, err = strconv.Atoi(strings.TrimSpace(high))
+			if err != nil {
+				return fmt.Errorf("ports: %w", err)
+			}
+		}
+		if s.low < 0 || s.high > 65535 || s.low > s.high {
+			return fmt.Errorf("ports: invalid range %s", s.Ports)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the toxic applies to the link of client. A nil
+// selector matches every client.
+func (s *Selector) Matches(client *net.UDPAddr) bool {
+	if s == nil {
+		return true
+	}
+	if client == nil {
+		return false
+	}
+
+	if s.Session != "" && s.Session != client.String() {
+		return false
+	}
+	if s.prefix.IsValid() {
+		addr, ok := netip.AddrFromSlice(client.IP)
+		if !ok || !s.prefix.Contains(addr.Unmap()) {
+			return false
+		}
+	}
+	return client.Port >= s.low && client.Port <= s.high
+}
@@ -0,0 +1,68 @@
+package toxics_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/badrootd/udpcrusher/toxics"
+)
+
+func TestSelectorMatches(t *testing.T) {
+	client := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 7), Port: 40050}
+	tests := []struct {
+		selector toxics.Selector
+		matches  bool
+	}{
+		{toxics.Selector{}, true},
+		{toxics.Selector{Source: "10.0.0.7"}, true},
+		{toxics.Selector{Source: "10.0.0.8"}, false},
+		{toxics.Selector{Source: "10.0.0.0/24"}, true},
+		{toxics.Selector{Source: "10.0.1.0/24"}, false},
+		{toxics.Selector{Ports: "40050"}, true},
+		{toxics.Selector{Ports: "40000-40100"}, true},
+		{toxics.Selector{Ports: "40051-40100"}, false},
+		{toxics.Selector{Session: "10.0.0.7:40050"}, true},
+		{toxics.Selector{Session: "10.0.0.7:40051"}, false},
+		{toxics.Selector{Source: "10.0.0.0/8", Ports: "40000-40100"}, true},
+		{toxics.Selector{Source: "10.0.0.0/8", Ports: "1-1024"}, false},
+	}
+	for _, test := range tests {
+		selector := test.selector
+		if err := selector.Load(); err != nil {
+			t.Fatalf("Failed to load selector %+v: %v", test.selector, err)
+		}
+		if matches := selector.Matches(client); matches != test.matches {
+			t.Errorf("Expected %+v to match %v, got %v", test.selector, test.matches, matches)
+		}
+	}
+
+	var selector *toxics.Selector
+	if !selector.Matches(client) {
+		t.Error("Expected a nil selector to match every client")
+	}
+}
+
+func TestSelectorMatchesMappedIPv4(t *testing.T) {
+	selector := &toxics.Selector{Source: "127.0.0.0/8"}
+	if err := selector.Load(); err != nil {
+		t.Fatal(err)
+	}
+	client := &net.UDPAddr{IP: net.ParseIP("::ffff:127.0.0.1"), Port: 1}
+	if !selector.Matches(client) {
+		t.Error("Expected an IPv4-mapped client to match an IPv4 CIDR")
+	}
+}
+
+func TestSelectorInvalid(t *testing.T) {
+	for _, selector := range []toxics.Selector{
+		{Source: "10.0.0"},
+		{Source: "10.0.0.0/33"},
+		{Ports: "http"},
+		{Ports: "40100-40000"},
+		{Ports: "1-70000"},
+	} {
+		if err := selector.Load(); err == nil {
+			t.Errorf("Expected %+v to be invalid", selector)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 // The SlicerToxic slices data into multiple smaller packets
 // to simulate real-world TCP behavior.
 type SlicerToxic struct {
+	Seeded
 	// Average number of bytes to slice at
 	AverageSize int `json:"average_size"`
 	// +/- bytes to vary sliced amounts. Must be less than
@@ -28,7 +29,7 @@ type SlicerToxic struct {
 //
 // This tries to get fairly evenly-varying chunks (no tendency
 // to have a small/large chunk at the start/end).
-func (t *SlicerToxic) chunk(start int, end int) []int {
+func (t *SlicerToxic) chunk(r *rand.Rand, start int, end int) []int {
 	// Base case:
 	// If the size is within the random varation, _or already
 	// less than the average size_, just return it.
@@ -38,12 +39,11 @@ func (t *SlicerToxic) chunk(start int, end int) []int {
 	}
 
 	mid := start + (end-start)/2
-	//#nosec
 	if t.SizeVariation > 0 {
-		mid += rand.Intn(t.SizeVariation*2) - t.SizeVariation
+		mid += r.Intn(t.SizeVariation*2) - t.SizeVariation
 	}
-	left := t.chunk(start, mid)
-	right := t.chunk(mid, end)
+	left := t.chunk(r, start, mid)
+	right := t.chunk(r, mid, end)
 
 	return append(left, right...)
 }
@@ -58,8 +58,12 @@ func (t *SlicerToxic) Pipe(stub *ToxicStub) {
 				stub.Close()
 				return
 			}
+			if !stub.Affected(c) {
+				stub.Output <- c
+				continue
+			}
 
-			chunks := t.chunk(0, len(c.Data))
+			chunks := t.chunk(stub.Rand, 0, len(c.Data))
 			for i := 1; i < len(chunks); i += 2 {
 				stub.Output <- &stream.StreamChunk{
 					Data:      c.Data[chunks[i-1]:chunks[i]],
@@ -0,0 +1,243 @@
+package toxics
+
+import (
+	"time"
+
+	"github.com/badrootd/udpcrusher/stream"
+)
+
+// The TokenBucketToxic shapes a link like a bottleneck router, similar to the
+// tbf qdisc of Linux. Datagrams wait in a bounded queue until the byte and
+// packet buckets hold enough tokens, and are dropped when the queue overflows
+// or, with random early detection, already when it fills up. Unlike the
+// bandwidth toxic datagrams are never split.
+type TokenBucketToxic struct {
+	// Rate in KB/s, 0 is unlimited
+	Rate int64 `json:"rate"`
+	// Bytes that can be sent at once after the link was idle
+	Burst int64 `json:"burst"`
+	// Datagrams per second, 0 is unlimited
+	PacketRate int64 `json:"packet_rate"`
+	// Datagrams that can be sent at once after the link was idle, at least 1
+	PacketBurst int64 `json:"packet_burst"`
+	// Maximum number of queued datagrams, 1000 if neither queue limit is set
+	QueuePackets int `json:"queue_packets"`
+	// Maximum number of queued bytes
+	QueueBytes int64 `json:"queue_bytes"`
+	// Random early detection drops datagrams with a probability rising from 0
+	// at red_min to red_probability at red_max, and all datagrams above
+	// red_max. Thresholds are fractions of the queue limit, 0 disables it.
+	RedMin         float64 `json:"red_min"`
+	RedMax         float64 `json:"red_max"`
+	RedProbability float64 `json:"red_probability"`
+	Seeded
+}
+
+type TokenBucketToxicState struct {
+	queue  []queuedChunk
+	bytes  int64
+	tokens float64
+	// Packet tokens
+	packets float64
+	refill  time.Time
+}
+
+type queuedChunk struct {
+	chunk *stream.StreamChunk
+	since time.Time
+}
+
+func (t *TokenBucketToxic) GetBufferSize() int {
+	return 1024
+}
+
+func (t *TokenBucketToxic) NewState() interface{} {
+	return &TokenBucketToxicState{
+		tokens:  float64(t.Burst),
+		packets: float64(t.packetBurst()),
+		refill:  time.Now(),
+	}
+}
+
+func (t *TokenBucketToxic) packetBurst() int64 {
+	if t.PacketBurst < 1 {
+		return 1
+	}
+	return t.PacketBurst
+}
+
+func (t *TokenBucketToxic) queueLimits() (int, int64) {
+	if t.QueuePackets <= 0 && t.QueueBytes <= 0 {
+		return 1000, 0
+	}
+	return t.QueuePackets, t.QueueBytes
+}
+
+// fill is the fraction of the queue limit that is used.
+func (t *TokenBucketToxic) fill(state *TokenBucketToxicState) float64 {
+	packets, bytes := t.queueLimits()
+	fill := 0.0
+	if packets > 0 {
+		fill = float64(len(state.queue)) / float64(packets)
+	}
+	if bytes > 0 && float64(state.bytes)/float64(bytes) > fill {
+		fill = float64(state.bytes) / float64(bytes)
+	}
+	return fill
+}
+
+// enqueue appends the datagram to the queue, or drops it if the queue is full
+// or random early detection decides so.
+func (t *TokenBucketToxic) enqueue(stub *ToxicStub, state *TokenBucketToxicState, c *stream.StreamChunk) {
+	packets, bytes := t.queueLimits()
+	size := int64(len(c.Data))
+	if (packets > 0 && len(state.queue) >= packets) || (bytes > 0 && state.bytes+size > bytes) {
+		stub.Drop()
+		return
+	}
+
+	if t.RedMax > 0 {
+		fill := t.fill(state)
+		if fill >= t.RedMax {
+			stub.Drop()
+			return
+		}
+		if fill >= t.RedMin && t.RedMax > t.RedMin {
+			probability := t.RedProbability * (fill - t.RedMin) / (t.RedMax - t.RedMin)
+			if stub.Rand.Float64() < probability {
+				stub.Drop()
+				return
+			}
+		}
+	}
+
+	state.queue = append(state.queue, queuedChunk{chunk: c, since: time.Now()})
+	state.bytes += size
+}
+
+// refill adds the tokens earned since the last refill, up to the burst sizes.
+func (t *TokenBucketToxic) refill(state *TokenBucketToxicState, now time.Time) {
+	elapsed := now.Sub(state.refill).Seconds()
+	state.refill = now
+
+	if t.Rate > 0 {
+		state.tokens += elapsed * float64(t.Rate*1000)
+		if state.tokens > float64(t.Burst) {
+			state.tokens = float64(t.Burst)
+		}
+	}
+	if t.PacketRate > 0 {
+		state.packets += elapsed * float64(t.PacketRate)
+		if state.packets > float64(t.packetBurst()) {
+			state.packets = float64(t.packetBurst())
+		}
+	}
+}
+
+// wait returns how long the head of the queue has to wait for tokens. A
+// datagram larger than the burst leaves once the bucket is full and takes
+// the tokens into debt, so the average rate still holds.
+func (t *TokenBucketToxic) wait(state *TokenBucketToxicState) time.Duration {
+	var wait time.Duration
+	if t.Rate > 0 {
+		need := int64(len(state.queue[0].chunk.Data))
+		if need > t.Burst {
+			need = t.Burst
+		}
+		if missing := float64(need) - state.tokens; missing > 0 {
+			wait = time.Duration(missing / float64(t.Rate*1000) * float64(time.Second))
+		}
+	}
+	if t.PacketRate > 0 {
+		if missing := 1 - state.packets; missing > 0 {
+			packetWait := time.Duration(missing / float64(t.PacketRate) * float64(time.Second))
+			if packetWait > wait {
+				wait = packetWait
+			}
+		}
+	}
+	return wait
+}
+
+// dequeue sends queued datagrams as long as there are enough tokens. It
+// returns false if the toxic was interrupted, the unsent datagrams stay queued.
+func (t *TokenBucketToxic) dequeue(stub *ToxicStub, state *TokenBucketToxicState) bool {
+	for len(state.queue) > 0 {
+		t.refill(state, time.Now())
+		if t.wait(state) > 0 {
+			return true
+		}
+
+		head := state.queue[0]
+		select {
+		case stub.Output <- head.chunk:
+		case <-stub.Interrupt:
+			return false
+		}
+		stub.Delay(time.Since(head.since))
+
+		size := int64(len(head.chunk.Data))
+		state.queue[0] = queuedChunk{}
+		state.queue = state.queue[1:]
+		state.bytes -= size
+		if t.Rate > 0 {
+			state.tokens -= float64(size)
+		}
+		if t.PacketRate > 0 {
+			state.packets--
+		}
+	}
+	return true
+}
+
+// timer fires when the head of the queue has enough tokens.
+func (t *TokenBucketToxic) timer(state *TokenBucketToxicState) <-chan time.Time {
+	if len(state.queue) == 0 {
+		return nil
+	}
+	t.refill(state, time.Now())
+	return time.After(t.wait(state))
+}
+
+func (t *TokenBucketToxic) Pipe(stub *ToxicStub) {
+	state := stub.State.(*TokenBucketToxicState)
+
+	for {
+		select {
+		case <-stub.Interrupt:
+			// Queued datagrams stay in the state until the toxic runs again
+			return
+		case c := <-stub.Input:
+			if c == nil {
+				t.Cleanup(stub)
+				stub.Close()
+				return
+			}
+			if !stub.Affected(c) {
+				stub.Output <- c
+				continue
+			}
+			t.enqueue(stub, state, c)
+		case <-t.timer(state):
+		}
+
+		if !t.dequeue(stub, state) {
+			return
+		}
+	}
+}
+
+// Cleanup sends all queued datagrams right away, so removing the toxic doesn't
+// drop them.
+func (t *TokenBucketToxic) Cleanup(stub *ToxicStub) {
+	state := stub.State.(*TokenBucketToxicState)
+	for _, q := range state.queue {
+		stub.Output <- q.chunk
+	}
+	state.queue = nil
+	state.bytes = 0
+}
+
+func init() {
+	Register("token_bucket", new(TokenBucketToxic))
+}
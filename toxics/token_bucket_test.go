@@ -0,0 +1,121 @@
+package toxics_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/badrootd/udpcrusher/stream"
+	"github.com/badrootd/udpcrusher/toxics"
+)
+
+// DoTokenBucketTest sends count datagrams of size bytes at once and returns the
+// number of datagrams that passed and how long the last one took.
+func DoTokenBucketTest(t *testing.T, bucket *toxics.TokenBucketToxic, count, size int) (int, time.Duration) {
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, count)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = bucket.NewState()
+
+	done := make(chan bool)
+	go func() {
+		bucket.Pipe(stub)
+		done <- true
+	}()
+
+	start := time.Now()
+	for i := 0; i < count; i++ {
+		input <- &stream.StreamChunk{Data: make([]byte, size), Timestamp: time.Now()}
+	}
+
+	received := 0
+	var elapsed time.Duration
+	for received < count {
+		select {
+		case <-output:
+			received++
+			elapsed = time.Since(start)
+			continue
+		case <-time.After(500 * time.Millisecond):
+		}
+		break
+	}
+	close(input)
+	<-done
+	return received, elapsed
+}
+
+func TestTokenBucketToxicRate(t *testing.T) {
+	// 20 datagrams of 10KB at 1MB/s, the first one leaves right away
+	received, elapsed := DoTokenBucketTest(t, &toxics.TokenBucketToxic{Rate: 1000}, 20, 10000)
+	if received != 20 {
+		t.Fatalf("Expected all 20 datagrams, got %d", received)
+	}
+	AssertDeltaTime(t, "Token bucket rate", elapsed, 190*time.Millisecond, 30*time.Millisecond)
+}
+
+func TestTokenBucketToxicBurst(t *testing.T) {
+	// The first 5 datagrams fit into the burst
+	received, elapsed := DoTokenBucketTest(t, &toxics.TokenBucketToxic{Rate: 1000, Burst: 50000}, 20, 10000)
+	if received != 20 {
+		t.Fatalf("Expected all 20 datagrams, got %d", received)
+	}
+	AssertDeltaTime(t, "Token bucket burst", elapsed, 150*time.Millisecond, 30*time.Millisecond)
+}
+
+func TestTokenBucketToxicPacketRate(t *testing.T) {
+	received, elapsed := DoTokenBucketTest(t, &toxics.TokenBucketToxic{PacketRate: 100}, 20, 100)
+	if received != 20 {
+		t.Fatalf("Expected all 20 datagrams, got %d", received)
+	}
+	AssertDeltaTime(t, "Token bucket packet rate", elapsed, 190*time.Millisecond, 30*time.Millisecond)
+}
+
+func TestTokenBucketToxicTailDrop(t *testing.T) {
+	bucket := &toxics.TokenBucketToxic{PacketRate: 1, QueuePackets: 5}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 20)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = bucket.NewState()
+	go bucket.Pipe(stub)
+
+	for i := 0; i < 20; i++ {
+		input <- &stream.StreamChunk{Data: []byte{byte(i)}}
+	}
+	close(input)
+
+	// The first datagram leaves right away, 5 wait in the queue and are flushed
+	// when the link closes, the rest is dropped
+	var received []int
+	for c := range output {
+		received = append(received, int(c.Data[0]))
+	}
+	if len(received) != 6 || received[5] != 5 {
+		t.Fatalf("Expected datagrams 0 to 5, got %v", received)
+	}
+}
+
+func TestTokenBucketToxicRandomEarlyDetection(t *testing.T) {
+	bucket := &toxics.TokenBucketToxic{PacketRate: 1, QueuePackets: 100, RedMin: 0.1, RedMax: 0.2, RedProbability: 0.5}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, 100)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = bucket.NewState()
+	go bucket.Pipe(stub)
+
+	for i := 0; i < 100; i++ {
+		input <- &stream.StreamChunk{Data: []byte{byte(i)}}
+	}
+	close(input)
+
+	// Nothing is dropped below 10 queued datagrams, some are between 10 and 20
+	// and the queue never grows beyond 20
+	received := 0
+	for range output {
+		received++
+	}
+	if received <= 11 || received > 21 {
+		t.Fatalf("Expected between 12 and 21 datagrams, got %d", received)
+	}
+}
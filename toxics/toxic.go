@@ -1,8 +1,10 @@
 package toxics
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/rand"
+	"net"
 	"reflect"
 	"sync"
 	"time"
@@ -47,29 +49,87 @@ type StatefulToxic interface {
 	NewState() interface{}
 }
 
+// Carried toxics keep state shared by all links, e.g. counters, that survives
+// changes of their attributes. Changes are made on a copy of the toxic, which
+// then replaces the running one.
+type CarriedToxic interface {
+	// Carry takes over the state of the previous version of the toxic
+	Carry(previous Toxic)
+}
+
+// Scheduled toxics follow a schedule shared by all their links. It starts over
+// with its own random source when the toxic is added or changed through the API.
+type ScheduledToxic interface {
+	Schedule(r *rand.Rand)
+}
+
+// Validated toxics check their attributes when they are added or updated, so an
+// invalid value is rejected instead of turning the toxic into a noop.
+type ValidatedToxic interface {
+	// Validate returns an error describing the first invalid attribute
+	Validate() error
+}
+
+// A Reporter receives the per-datagram events of the toxics running on a link,
+// for example to export them as metrics.
+type Reporter interface {
+	// Dropped is called for every datagram a toxic discards.
+	Dropped(toxic *ToxicWrapper)
+	// Delayed is called with the delay a toxic added to a datagram.
+	Delayed(toxic *ToxicWrapper, delay time.Duration)
+}
+
+const (
+	// The toxic applies to every datagram with a probability of Toxicity.
+	ToxicityPerPacket = "packet"
+	// The toxic applies to all datagrams of a link with a probability of
+	// Toxicity, decided once when the toxic starts running on the link.
+	ToxicityPerLink = "link"
+)
+
+// A Rebinder moves the client session of a link to a new source port towards
+// the upstream, like a NAT that rebinds its mapping.
+type Rebinder interface {
+	Rebind() error
+}
+
 type ToxicWrapper struct {
-	Toxic      `json:"attributes"`
-	Name       string           `json:"name"`
-	Type       string           `json:"type"`
-	Stream     string           `json:"stream"`
-	Toxicity   float32          `json:"toxicity"`
-	Direction  stream.Direction `json:"-"`
-	Index      int              `json:"-"`
-	BufferSize int              `json:"-"`
+	Toxic        `json:"attributes"`
+	Name         string           `json:"name"`
+	Type         string           `json:"type"`
+	Stream       string           `json:"stream"`
+	Toxicity     float32          `json:"toxicity"`
+	ToxicityMode string           `json:"toxicity_mode"`
+	Profile      *Profile         `json:"profile,omitempty"`
+	Selector     *Selector        `json:"selector,omitempty"`
+	Match        *Match           `json:"match,omitempty"`
+	Direction    stream.Direction `json:"-"`
+	Index        int              `json:"-"`
+	BufferSize   int              `json:"-"`
 }
 
 type ToxicStub struct {
 	Input     <-chan *stream.StreamChunk
 	Output    chan<- *stream.StreamChunk
 	State     interface{}
+	Rand      *rand.Rand
 	Interrupt chan struct{}
-	running   chan struct{}
-	closed    chan struct{}
+	Reporter  Reporter
+	Rebinder  Rebinder
+	// Address of the link's client, matched against the toxic's selector
+	Client   *net.UDPAddr
+	toxic    *ToxicWrapper
+	match    *Match
+	toxicity float32
+	running  chan struct{}
+	closed   chan struct{}
 }
 
 func NewToxicStub(input <-chan *stream.StreamChunk, output chan<- *stream.StreamChunk) *ToxicStub {
 	return &ToxicStub{
 		Interrupt: make(chan struct{}),
+		Rand:      newRand(0),
+		toxicity:  1,
 		closed:    make(chan struct{}),
 		Input:     input,
 		Output:    output,
@@ -77,16 +137,37 @@ func NewToxicStub(input <-chan *stream.StreamChunk, output chan<- *stream.Stream
 }
 
 // Begin running a toxic on this stub, can be interrupted.
-// Runs a noop toxic randomly depending on toxicity.
+// A noop toxic runs instead if the toxic's selector doesn't match the client.
+// In the per-link toxicity mode a noop toxic runs instead depending on
+// toxicity, otherwise the toxic decides per datagram using Affected.
 func (s *ToxicStub) Run(toxic *ToxicWrapper) {
 	s.running = make(chan struct{})
 	defer close(s.running)
-	//#nosec
-	if rand.Float32() < toxic.Toxicity {
-		toxic.Pipe(s)
-	} else {
+	s.toxic = toxic
+	s.toxicity = toxic.Toxicity
+	s.match = toxic.Match
+	if !toxic.Selector.Matches(s.Client) {
 		new(NoopToxic).Pipe(s)
+		return
+	}
+	if toxic.ToxicityMode == ToxicityPerLink {
+		if s.Rand.Float32() >= toxic.Toxicity {
+			new(NoopToxic).Pipe(s)
+			return
+		}
+		s.toxicity = 1
+	}
+	toxic.Pipe(s)
+}
+
+// Affected decides whether the running toxic applies to datagram c, which must
+// match the toxic's match conditions. Toxics pass datagrams that are not
+// affected through unchanged.
+func (s *ToxicStub) Affected(c *stream.StreamChunk) bool {
+	if !s.match.Matches(c.Data) {
+		return false
 	}
+	return s.toxicity >= 1 || s.Rand.Float32() < s.toxicity
 }
 
 // WriteOutput allows to write to Output with timeout to avoid deadlocks.
@@ -105,6 +186,20 @@ func (s *ToxicStub) WriteOutput(p *stream.StreamChunk, d time.Duration) error {
 	}
 }
 
+// Drop reports that the running toxic discarded a datagram.
+func (s *ToxicStub) Drop() {
+	if s.Reporter != nil && s.toxic != nil {
+		s.Reporter.Dropped(s.toxic)
+	}
+}
+
+// Delay reports the delay the running toxic added to a datagram.
+func (s *ToxicStub) Delay(d time.Duration) {
+	if s.Reporter != nil && s.toxic != nil {
+		s.Reporter.Delayed(s.toxic, d)
+	}
+}
+
 // Interrupt the flow of data so that the toxic controlling the stub can be replaced.
 // Returns true if the stream was successfully interrupted, or false if the stream is closed.
 func (s *ToxicStub) InterruptToxic() bool {
@@ -165,6 +260,27 @@ func New(wrapper *ToxicWrapper) Toxic {
 	return wrapper.Toxic
 }
 
+// Copy returns a copy of the wrapper and its toxic. The wrapper is shared with
+// the links running it and with API responses, so changes are made on a copy
+// that replaces it.
+func (w *ToxicWrapper) Copy() (*ToxicWrapper, error) {
+	attrs, err := json.Marshal(w.Toxic)
+	if err != nil {
+		return nil, err
+	}
+	wrapper := *w
+	if New(&wrapper) == nil {
+		return nil, fmt.Errorf("unknown toxic type %q", w.Type)
+	}
+	if err := json.Unmarshal(attrs, wrapper.Toxic); err != nil {
+		return nil, err
+	}
+	if carried, ok := wrapper.Toxic.(CarriedToxic); ok {
+		carried.Carry(w.Toxic)
+	}
+	return &wrapper, nil
+}
+
 func Count() int {
 	registryMutex.RLock()
 	defer registryMutex.RUnlock()
@@ -0,0 +1,86 @@
+package toxics_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/badrootd/udpcrusher/stream"
+	"github.com/badrootd/udpcrusher/toxics"
+)
+
+// DoToxicityTest runs a full loss toxic with the given toxicity on a stub and
+// returns how many of count datagrams made it through.
+func DoToxicityTest(t *testing.T, toxicity float32, mode string, count int) int {
+	loss := &toxics.LossToxic{Probability: 1}
+	wrapper := &toxics.ToxicWrapper{
+		Toxic:        loss,
+		Name:         "loss",
+		Toxicity:     toxicity,
+		ToxicityMode: mode,
+	}
+
+	input := make(chan *stream.StreamChunk)
+	output := make(chan *stream.StreamChunk, count)
+	stub := toxics.NewToxicStub(input, output)
+	stub.State = loss.NewState()
+
+	done := make(chan bool)
+	go func() {
+		stub.Run(wrapper)
+		done <- true
+	}()
+
+	for i := 0; i < count; i++ {
+		input <- &stream.StreamChunk{Data: []byte{byte(i)}}
+	}
+	close(input)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Toxic did not finish in time")
+	}
+	return len(output)
+}
+
+func TestToxicityPerPacket(t *testing.T) {
+	received := DoToxicityTest(t, 0.3, toxics.ToxicityPerPacket, 10000)
+	if received < 6500 || received > 7500 {
+		t.Errorf("Expected about 7000 of 10000 datagrams, got %d", received)
+	}
+}
+
+func TestToxicityPerLink(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		received := DoToxicityTest(t, 0.5, toxics.ToxicityPerLink, 100)
+		if received != 0 && received != 100 {
+			t.Fatalf("Expected all or no datagrams, got %d of 100", received)
+		}
+	}
+}
+
+func TestToxicWrapperCopy(t *testing.T) {
+	mtu := &toxics.MtuToxic{Mtu: 1200, Action: toxics.MtuActionTruncate}
+	mtu.Exceeded.Inc()
+	wrapper := &toxics.ToxicWrapper{Toxic: mtu, Name: "mtu", Type: "mtu", Toxicity: 0.5}
+
+	copied, err := wrapper.Copy()
+	if err != nil {
+		t.Fatal("Unable to copy toxic:", err)
+	}
+	toxic, ok := copied.Toxic.(*toxics.MtuToxic)
+	if !ok || toxic == mtu {
+		t.Fatalf("Expected a new mtu toxic, got %T", copied.Toxic)
+	}
+	if toxic.Mtu != 1200 || toxic.Action != toxics.MtuActionTruncate || copied.Toxicity != 0.5 {
+		t.Fatalf("Expected the attributes to be copied, got %+v", copied)
+	}
+	if toxic.Exceeded.Load() != 1 {
+		t.Fatalf("Expected the counter to carry over, got %d", toxic.Exceeded.Load())
+	}
+
+	toxic.Mtu = 500
+	if mtu.Mtu != 1200 {
+		t.Fatal("Changing the copy changed the original toxic")
+	}
+}
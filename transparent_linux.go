@@ -0,0 +1,38 @@
+//go:build linux
+
+package toxiproxy
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const transparentSupported = true
+
+// transparentControl marks a socket as transparent, so it can be bound to the
+// address of a client that is not local to this host. Requires CAP_NET_ADMIN.
+func transparentControl(network, address string, conn syscall.RawConn) error {
+	var err error
+	controlErr := conn.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+		if err != nil {
+			return
+		}
+		if network == "udp6" {
+			err = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+			if err == nil {
+				err = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_FREEBIND, 1)
+			}
+			return
+		}
+		err = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+		if err == nil {
+			err = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_FREEBIND, 1)
+		}
+	})
+	if controlErr != nil {
+		return controlErr
+	}
+	return err
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package toxiproxy
+
+import (
+	"syscall"
+)
+
+const transparentSupported = false
+
+func transparentControl(network, address string, conn syscall.RawConn) error {
+	return ErrTransparentUnsupported
+}
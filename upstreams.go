@@ -0,0 +1,115 @@
+package toxiproxy
+
+import (
+	"hash/fnv"
+	"net"
+	"sync"
+	"time"
+)
+
+// Policies spreading the client sessions of a proxy over its upstreams.
+const (
+	// Every new session takes the next upstream in turn
+	PolicyRoundRobin = "round_robin"
+	// The client's IP picks the upstream by rendezvous hashing, so a client
+	// sticks to its upstream and only moves if that upstream is removed
+	PolicyHash = "hash"
+	// Sessions use the first upstream that didn't refuse datagrams recently, and
+	// move to the next one once theirs does
+	PolicyFailover = "failover"
+	// Every datagram goes to all upstreams, only replies of the first return
+	PolicyMirror = "mirror"
+)
+
+// How long an upstream that refused datagrams is skipped by failover.
+const upstreamDownTime = 10 * time.Second
+
+// upstreamPool picks the upstreams of new client sessions.
+type upstreamPool struct {
+	sync.Mutex
+
+	policy    string
+	addresses []string // The primary upstream first
+	next      int
+	down      []time.Time
+}
+
+func newUpstreamPool(policy string, addresses []string) (*upstreamPool, error) {
+	switch policy {
+	case "":
+		policy = PolicyRoundRobin
+	case PolicyRoundRobin, PolicyHash, PolicyFailover, PolicyMirror:
+	default:
+		return nil, ErrUnknownPolicy
+	}
+	return &upstreamPool{
+		policy:    policy,
+		addresses: addresses,
+		down:      make([]time.Time, len(addresses)),
+	}, nil
+}
+
+// pick returns the index of the upstream a session of client talks to.
+func (p *upstreamPool) pick(client net.Addr) int {
+	p.Lock()
+	defer p.Unlock()
+
+	switch p.policy {
+	case PolicyRoundRobin:
+		i := p.next % len(p.addresses)
+		p.next++
+		return i
+	case PolicyHash:
+		return p.hash(client)
+	case PolicyFailover:
+		// The upstream that refused datagrams the longest time ago if all are down
+		now, oldest := time.Now(), 0
+		for i, down := range p.down {
+			if now.Sub(down) >= upstreamDownTime {
+				return i
+			}
+			if down.Before(p.down[oldest]) {
+				oldest = i
+			}
+		}
+		return oldest
+	}
+	return 0
+}
+
+// hash returns the upstream with the highest weight for the client's IP.
+func (p *upstreamPool) hash(client net.Addr) int {
+	host := client.String()
+	if udp, ok := client.(*net.UDPAddr); ok {
+		host = udp.IP.String()
+	}
+
+	best, bestWeight := 0, uint64(0)
+	for i, address := range p.addresses {
+		h := fnv.New64a()
+		h.Write([]byte(host))
+		h.Write([]byte{0})
+		h.Write([]byte(address))
+		if weight := h.Sum64(); weight > bestWeight {
+			best, bestWeight = i, weight
+		}
+	}
+	return best
+}
+
+// markDown skips the upstream for new picks of failover for a while.
+func (p *upstreamPool) markDown(i int) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.down[i] = time.Now()
+}
+
+// mirrors returns the upstreams every datagram is copied to besides the
+// primary one.
+func (p *upstreamPool) mirrors() []string {
+	if p.policy != PolicyMirror {
+		return nil
+	}
+	return p.addresses[1:]
+}